@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -236,6 +238,13 @@ func (b *BaseBackend) Clone() *BaseBackend {
 	}
 }
 
+// SetBackend sets the backend that is used to dispatch calls.
+// Clones of a backend must set it to the clone, so that calls are not
+// executed by the original backend.
+func (b *BaseBackend) SetBackend(backend Backend) {
+	b.backend = backend
+}
+
 /**
  * Hooks.
  */
@@ -358,7 +367,7 @@ func (b *BaseBackend) CreateCollection(collections ...string) apperror.Error {
 			return b.unknownColErr(collection)
 		}
 
-		stmt := info.BuildCreateStmt(false)
+		stmt := info.BuildCreateStmt(true)
 		if err := b.backend.Exec(stmt); err != nil {
 			return err
 		}
@@ -635,8 +644,11 @@ func (b *BaseBackend) Query(q *Query, targetSlice ...interface{}) ([]interface{}
 		stats.Joining = stats.Finished.Sub(stats.Started) - stats.Normalizing - stats.Execution - stats.ModelBuild
 	}
 
-	// Call after query hook.
+	// Remember the loaded values for dirty tracking, and call after query hook.
 	for _, model := range models {
+		if err := takeSnapshot(info, model); err != nil {
+			return nil, err
+		}
 		if err := CallModelHook(b.backend, model, "AfterQuery"); err != nil {
 			return nil, err
 		}
@@ -666,7 +678,59 @@ func (b *BaseBackend) Query(q *Query, targetSlice ...interface{}) ([]interface{}
 }
 
 func (b *BaseBackend) QueryCursor(q *Query) (Cursor, apperror.Error) {
-	panic("QueryCursor() not implemented!")
+	info := b.ModelInfo(q.GetCollection())
+	if info == nil {
+		return nil, b.unknownColErr(q.GetCollection())
+	}
+
+	// Keep an unmodified copy for counting.
+	countQuery := q.Clone()
+
+	// Normalize query.
+	// Ensure backend is set.
+	q.SetBackend(b.backend)
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+
+	if err := b.BuildJoins(info, q); err != nil {
+		return nil, err
+	}
+
+	var rows RowIterator
+	var err apperror.Error
+	if txBackend, ok := b.backend.(TransactionBackend); ok && txBackend.IsTransaction() && len(q.GetJoins()) > 0 {
+		// Joins execute queries, which is not possible in a transaction while
+		// the rows of a streaming iterator are read, so the complete result is
+		// read first.
+		rows, err = b.ExecQueryIterator(q.GetStatement())
+	} else {
+		rows, err = b.backend.ExecQueryIterator(q.GetStatement())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := &BaseCursor{
+		backend:    b,
+		info:       info,
+		query:      q,
+		countQuery: countQuery,
+		rows:       rows,
+		count:      -1,
+	}
+	return cursor, nil
+}
+
+// ExecQueryIterator executes the statement with ExecQuery() and returns an
+// iterator over the complete result.
+// Backends that support streaming results should override it.
+func (b *BaseBackend) ExecQueryIterator(statement FieldedExpression) (RowIterator, apperror.Error) {
+	result, err := b.backend.ExecQuery(statement)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceIterator(result), nil
 }
 
 func (b *BaseBackend) QueryOne(q *Query, targetModels ...interface{}) (interface{}, apperror.Error) {
@@ -769,6 +833,14 @@ func (b *BaseBackend) Count(q *Query) (int, apperror.Error) {
 }
 
 func (b *BaseBackend) Pluck(q *Query) ([]map[string]interface{}, apperror.Error) {
+	if q.GetStatement().IsAggregate() {
+		// Aggregates and group by fields must be converted to backend names.
+		q.SetBackend(b.backend)
+		if err := q.Normalize(); err != nil {
+			return nil, err
+		}
+	}
+
 	res, err := b.backend.ExecQuery(q.GetStatement())
 	if err != nil {
 		return nil, err
@@ -1183,12 +1255,18 @@ func (b *BaseBackend) buildJoin(relation *Relation, jq *RelationQuery) (*JoinStm
 	localField := baseInfo.Attribute(relation.LocalField())
 	fkField := info.Attribute(relation.ForeignField())
 
-	condition := NewFieldFilter(
+	var condition Expression = NewFieldFilter(
 		baseInfo.BackendName(),
 		localField.BackendName(),
 		OPERATOR_EQ,
 		NewColFieldIdExpr(info.BackendName(), fkField.BackendName()))
 
+	// Only the join condition is rendered for native joins, so the soft
+	// delete scope must be part of it.
+	if filter := jq.softDeleteFilter(info); filter != nil {
+		condition = And(condition, filter)
+	}
+
 	s := jq.GetStatement()
 	s.SetJoinCondition(condition)
 	s.SetName(relation.Name())
@@ -1296,10 +1374,16 @@ func (b *BaseBackend) M2M(model interface{}, name string) (M2MCollection, apperr
  * Create, update, delete.
  */
 
-func (b *BaseBackend) doCreate(info *ModelInfo, model interface{}) apperror.Error {
+// CREATE_BATCH_SIZE is the maximum number of models created with a single
+// multi-row insert.
+const CREATE_BATCH_SIZE = 1000
+
+// beforeCreate calls the before create hooks, persists relations and
+// validates the model. It returns the values to insert.
+func (b *BaseBackend) beforeCreate(info *ModelInfo, model interface{}) ([]*FieldValueExpr, apperror.Error) {
 	// Call BeforeCreate hook on model.
 	if err := CallModelHook(b.backend, model, "BeforeCreate"); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Call backend-wide before_create hooks.
@@ -1309,14 +1393,126 @@ func (b *BaseBackend) doCreate(info *ModelInfo, model interface{}) apperror.Erro
 
 	// Persist relationships before create.
 	if err := b.PersistRelations("create", true, info, model); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := info.ValidateModel(model); err != nil {
+		return nil, err
+	}
+
+	// New models start with version 1.
+	if attr := info.VersionAttribute(); attr != nil {
+		if version := versionField(model, attr); version.IsValid() && isZeroVersion(version) {
+			setVersion(version, 1)
+		}
+	}
+
+	return info.ModelToFieldExpressions(model)
+}
+
+/**
+ * Optimistic locking.
+ */
+
+// versionField returns the value of the version attribute of a model.
+func versionField(model interface{}, attr *Attribute) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(model)).FieldByName(attr.Name())
+}
+
+func isZeroVersion(version reflect.Value) bool {
+	switch version.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return version.Uint() == 0
+	}
+	return version.Int() == 0
+}
+
+func setVersion(version reflect.Value, val int64) {
+	switch version.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		version.SetUint(uint64(val))
+	default:
+		version.SetInt(val)
+	}
+}
+
+func versionInt(version reflect.Value) int64 {
+	switch version.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(version.Uint())
+	}
+	return version.Int()
+}
+
+// staleObjectError is returned when an update or delete with optimistic
+// locking affected no rows, since the model was changed or deleted
+// concurrently.
+func staleObjectError(info *ModelInfo, model interface{}) apperror.Error {
+	id, _ := info.DetermineModelStrId(model)
+	return &apperror.Err{
+		Code:    "stale_object",
+		Message: fmt.Sprintf("The %v with id %v was modified or deleted concurrently", info.Collection(), id),
+		Public:  true,
+	}
+}
+
+// execLocked executes an update or delete statement for a model with a
+// version attribute.
+// The select of the statement is restricted to the given version, and a
+// stale_object error is returned if no rows were affected.
+func (b *BaseBackend) execLocked(info *ModelInfo, model interface{}, stmt Expression, sel *SelectStmt, version int64) apperror.Error {
+	attr := info.VersionAttribute()
+	sel.FilterAnd(NewFieldValFilter(info.BackendName(), attr.BackendName(), OPERATOR_EQ, version))
+
+	affected, err := b.backend.ExecAffected(stmt)
+	if err != nil {
 		return err
+	} else if affected == 0 {
+		return staleObjectError(info, model)
 	}
+	return nil
+}
 
-	values, err := info.ModelToFieldExpressions(model)
+// lockedTransaction returns a backend that supports transactions if the
+// model has a version attribute and the backend is not in a transaction yet.
+// Updates and deletes of such models run in a transaction, so that the
+// relations persisted before the version check are rolled back on a
+// stale_object error.
+// Inside of a transaction, rolling back is left to the caller.
+func (b *BaseBackend) lockedTransaction(info *ModelInfo) TransactionBackend {
+	if info.VersionAttribute() == nil {
+		return nil
+	}
+	if txBackend, ok := b.backend.(TransactionBackend); ok && !txBackend.IsTransaction() {
+		return txBackend
+	}
+	return nil
+}
+
+// afterCreate persists relations that need the model id and calls the after
+// create hooks.
+func (b *BaseBackend) afterCreate(info *ModelInfo, model interface{}) apperror.Error {
+	// Persist relationships again since m2m can only be handled  when an Id is set.
+	if err := b.PersistRelations("create", false, info, model); err != nil {
+		return err
+	}
+
+	if err := takeSnapshot(info, model); err != nil {
+		return err
+	}
+
+	CallModelHook(b.backend, model, "AfterCreate")
+
+	// Call backend-wide after_create hooks.
+	for _, handler := range b.GetHooks("after_create") {
+		handler(b.backend, model)
+	}
+
+	return nil
+}
+
+func (b *BaseBackend) doCreate(info *ModelInfo, model interface{}) apperror.Error {
+	values, err := b.beforeCreate(info, model)
 	if err != nil {
 		return err
 	}
@@ -1336,21 +1532,90 @@ func (b *BaseBackend) doCreate(info *ModelInfo, model interface{}) apperror.Erro
 		}
 	}
 
-	// Persist relationships again since m2m can only be handled  when an Id is set.
-	if err := b.PersistRelations("create", false, info, model); err != nil {
-		return err
+	return b.afterCreate(info, model)
+}
+
+// createBatch creates models with multi-row inserts.
+// Hooks and validation run for each model. Consecutive models with the same
+// fields are inserted together, in chunks of at most the batch size of the
+// backend.
+func (b *BaseBackend) createBatch(batcher BatchCreateBackend, info *ModelInfo, models []interface{}) apperror.Error {
+	var stmt *CreateStmt
+	var signature string
+	batchSize := 0
+	batch := make([]interface{}, 0)
+
+	flush := func() apperror.Error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		res, err := b.backend.ExecQuery(stmt)
+		if err != nil {
+			return err
+		}
+
+		// Returned rows hold the generated data for each model, in insert order.
+		if len(res) == len(batch) {
+			for i, model := range batch {
+				if err := info.UpdateModelFromData(model, res[i].(map[string]interface{})); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, model := range batch {
+			if err := b.afterCreate(info, model); err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
 	}
 
-	CallModelHook(b.backend, model, "AfterCreate")
+	for _, model := range models {
+		values, err := b.beforeCreate(info, model)
+		if err != nil {
+			return err
+		}
 
-	// Call backend-wide after_create hooks.
-	for _, handler := range b.GetHooks("after_create") {
-		handler(b.backend, model)
+		names := make([]string, 0)
+		for _, val := range values {
+			if id, ok := val.Field().(*IdentifierExpr); ok {
+				names = append(names, id.Identifier())
+			}
+		}
+		sort.Strings(names)
+		rowSignature := strings.Join(names, ",")
+
+		if len(batch) > 0 && (rowSignature != signature || len(batch) >= batchSize) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) == 0 {
+			signature = rowSignature
+			batchSize = batcher.CreateBatchSize(info, len(values))
+			if batchSize > CREATE_BATCH_SIZE {
+				batchSize = CREATE_BATCH_SIZE
+			} else if batchSize < 1 {
+				batchSize = 1
+			}
+			stmt = NewCreateStmt(info.BackendName(), nil)
+		}
+
+		stmt.AddRow(values, model)
+		batch = append(batch, model)
 	}
 
-	return nil
+	return flush()
 }
 
+// Create persists models.
+// If the backend implements BatchCreateBackend, multiple models are created
+// with multi-row inserts.
 func (b *BaseBackend) Create(models ...interface{}) apperror.Error {
 	if len(models) < 1 {
 		return apperror.New("no_models")
@@ -1361,6 +1626,12 @@ func (b *BaseBackend) Create(models ...interface{}) apperror.Error {
 		return err
 	}
 
+	if batcher, ok := b.backend.(BatchCreateBackend); ok && len(models) > 1 {
+		if batcher.CreateBatchSize(info, len(info.Attributes())) > 1 {
+			return b.createBatch(batcher, info, models)
+		}
+	}
+
 	for _, model := range models {
 		if err := b.doCreate(info, model); err != nil {
 			return err
@@ -1420,6 +1691,12 @@ func (b *BaseBackend) Update(model interface{}) apperror.Error {
 			fmt.Sprintf("Trying to update model %v with zero id", info.Collection()))
 	}
 
+	if txBackend := b.lockedTransaction(info); txBackend != nil {
+		return WithTransaction(txBackend, func(tx Transaction) error {
+			return tx.Update(model)
+		})
+	}
+
 	if err := CallModelHook(b.backend, model, "BeforeUpdate"); err != nil {
 		return err
 	}
@@ -1436,17 +1713,42 @@ func (b *BaseBackend) Update(model interface{}) apperror.Error {
 		return err
 	}
 
-	values, err := info.ModelToFieldExpressions(model)
+	// With optimistic locking, the version is incremented, and only the
+	// current version is updated.
+	var version reflect.Value
+	var oldVersion int64
+	if attr := info.VersionAttribute(); attr != nil {
+		version = versionField(model, attr)
+		oldVersion = versionInt(version)
+		setVersion(version, oldVersion+1)
+	}
+
+	// With dirty tracking, only the changed fields are written.
+	values, err := modelUpdateValues(info, model)
 	if err != nil {
+		if version.IsValid() {
+			setVersion(version, oldVersion)
+		}
 		return err
 	}
 
-	// Build a update statement.
-	stmt := NewUpdateStmt(info.BackendName(), values, info.ModelSelect(model))
-	stmt.SetRawValue(model)
+	if len(values) > 0 {
+		// Build a update statement.
+		stmt := NewUpdateStmt(info.BackendName(), values, info.ModelSelect(model))
+		stmt.SetRawValue(model)
 
-	if err := b.backend.Exec(stmt); err != nil {
-		return err
+		if version.IsValid() {
+			if err := b.execLocked(info, model, stmt, stmt.Select(), oldVersion); err != nil {
+				setVersion(version, oldVersion)
+				return err
+			}
+		} else if err := b.backend.Exec(stmt); err != nil {
+			return err
+		}
+
+		if err := takeSnapshot(info, model); err != nil {
+			return err
+		}
 	}
 
 	// Persist relationships again since m2m can only be handled  when an Id is set.
@@ -1482,24 +1784,245 @@ func (b *BaseBackend) Save(model interface{}) apperror.Error {
 	}
 }
 
+// UpsertOptions configures the conflict handling of UpsertWithOptions.
+// Fields may be specified with their name, backend name or marshal name.
+type UpsertOptions struct {
+	// ConflictFields are the fields of the unique constraint that detects
+	// existing models. Defaults to the primary key.
+	ConflictFields []string
+
+	// UpdateFields are the fields that are updated on conflict.
+	// Defaults to all fields except the conflict fields, the primary key and
+	// the soft delete field, so soft deleted models stay deleted.
+	UpdateFields []string
+
+	// DoNothing keeps the existing model unchanged on conflict.
+	DoNothing bool
+}
+
+func (b *BaseBackend) Upsert(model interface{}, conflictFields ...string) apperror.Error {
+	return b.backend.UpsertWithOptions(model, UpsertOptions{ConflictFields: conflictFields})
+}
+
+// UpsertWithOptions creates the model, or updates the existing model on a
+// conflict of the conflict fields.
+// The id of the model is set to the id of the created or existing model in
+// both cases, also if the existing model is kept with DoNothing. Backends that
+// do not return the id re-select it by the conflict fields.
+// With optimistic locking, updating the existing model increments its
+// version, and the version of the model is set to the stored one. The version
+// is not checked.
+// Note that hooks are not called and relations are not persisted.
+func (b *BaseBackend) UpsertWithOptions(model interface{}, options UpsertOptions) apperror.Error {
+	info, err := b.backend.InfoForModel(model)
+	if err != nil {
+		return err
+	}
+
+	if err := info.ValidateModel(model); err != nil {
+		return err
+	}
+
+	// backendNames converts field names to backend names.
+	backendNames := func(names []string) ([]string, apperror.Error) {
+		result := make([]string, 0)
+		for _, name := range names {
+			attr := info.FindAttribute(name)
+			if attr == nil {
+				return nil, &apperror.Err{
+					Code:    "unknown_field",
+					Message: fmt.Sprintf("The collection %v has no field %v", info.Collection(), name),
+				}
+			}
+			result = append(result, attr.BackendName())
+		}
+		return result, nil
+	}
+
+	conflictFields := options.ConflictFields
+	if len(conflictFields) < 1 {
+		conflictFields = []string{info.PkAttribute().Name()}
+	}
+	conflictFields, err = backendNames(conflictFields)
+	if err != nil {
+		return err
+	}
+	updateFields, err := backendNames(options.UpdateFields)
+	if err != nil {
+		return err
+	}
+
+	// New models start with version 1.
+	versionAttr := info.VersionAttribute()
+	if versionAttr != nil {
+		if version := versionField(model, versionAttr); version.IsValid() && isZeroVersion(version) {
+			setVersion(version, 1)
+		}
+	}
+
+	values, err := info.ModelToFieldExpressions(model)
+	if err != nil {
+		return err
+	}
+
+	if len(updateFields) < 1 {
+		// Update all fields except the conflict fields, the primary key and
+		// the soft delete field.
+		skip := map[string]bool{info.PkAttribute().BackendName(): true}
+		for _, name := range conflictFields {
+			skip[name] = true
+		}
+		if attr := info.SoftDeleteAttribute(); attr != nil {
+			skip[attr.BackendName()] = true
+		}
+		for _, val := range values {
+			name := val.Field().(*IdentifierExpr).Identifier()
+			if !skip[name] {
+				updateFields = append(updateFields, name)
+			}
+		}
+		sort.Strings(updateFields)
+	}
+
+	// Rows without a value for a conflict field, like a new model with an auto
+	// incremented primary key, can not conflict, so they are just inserted.
+	canConflict := true
+	for _, field := range conflictFields {
+		found := false
+		for _, val := range values {
+			if val.Field().(*IdentifierExpr).Identifier() == field {
+				found = true
+				break
+			}
+		}
+		canConflict = canConflict && found
+	}
+
+	var stmt FieldedExpression
+	if canConflict {
+		upsert := NewUpsertStmt(info.BackendName(), values, conflictFields)
+		upsert.SetUpdateFields(updateFields)
+		upsert.SetDoNothing(options.DoNothing || len(updateFields) < 1)
+		upsert.SetRawValue(model)
+		if versionAttr != nil {
+			name := versionAttr.BackendName()
+			upsert.AddUpdateExpression(Expr(name, Add(NewColFieldIdExpr(info.BackendName(), name), Value(1))))
+		}
+		stmt = upsert
+	} else {
+		create := NewCreateStmt(info.BackendName(), values)
+		create.SetRawValue(model)
+		stmt = create
+	}
+
+	res, err := b.backend.ExecQuery(stmt)
+	if err != nil {
+		return err
+	}
+
+	if len(res) == 1 {
+		if err := info.UpdateModelFromData(model, res[0].(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+
+	hasId, err := info.ModelHasId(model)
+	if err != nil {
+		return err
+	}
+	onlyPk := len(conflictFields) == 1 && conflictFields[0] == info.PkAttribute().BackendName()
+	// If the model has an id, it is the id of the stored model unless other
+	// fields may have conflicted.
+	knownId := len(res) == 1 || (hasId && (onlyPk || !canConflict))
+
+	if !knownId || (versionAttr != nil && canConflict) {
+		// Look up the stored model by the conflict fields, to determine its id
+		// and version. On a conflict, the id of the model may differ from the
+		// id of the existing model.
+		data, err := info.ModelToMap(model, true, false, false)
+		if err != nil {
+			return err
+		}
+		q := b.backend.Q(info.Collection()).WithDeleted()
+		for _, field := range conflictFields {
+			q.Filter(field, data[field])
+		}
+		existing, err := q.First()
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			id, err := info.DetermineModelId(existing)
+			if err != nil {
+				return err
+			}
+			if err := info.SetModelId(model, id); err != nil {
+				return err
+			}
+			if versionAttr != nil {
+				setVersion(versionField(model, versionAttr), versionInt(versionField(existing, versionAttr)))
+			}
+		}
+	}
+
+	return takeSnapshot(info, model)
+}
+
+// UpdateByMap updates all models matching the query.
+// Values may be literal values or value expressions like
+// Add(FieldRef("views"), Value(1)).
 func (b *BaseBackend) UpdateByMap(query *Query, data map[string]interface{}) apperror.Error {
+	keys := make([]string, 0)
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]*FieldValueExpr, 0)
+	for _, key := range keys {
+		values = append(values, NewFieldValExpr(NewIdExpr(key), updateValueExpr(data[key])))
+	}
+
+	return b.backend.UpdateMany(query, values...)
+}
+
+// updateValueExpr wraps literal update values in a ValueExpr.
+func updateValueExpr(val interface{}) Expression {
+	switch val.(type) {
+	case *ValueExpr, *ArithmeticExpr, *NowExpr, *IdentifierExpr, *ColFieldIdentifierExpr, *FunctionExpr:
+		return val
+	}
+	return NewValueExpr(val)
+}
+
+// UpdateMany updates all models matching the query with the values.
+// Values are evaluated by the backend, so updates like Incr("views", 1)
+// are atomic.
+func (b *BaseBackend) UpdateMany(query *Query, values ...*FieldValueExpr) apperror.Error {
+	if len(values) < 1 {
+		return apperror.New("no_values")
+	}
+
 	collection := query.GetCollection()
 	info := b.ModelInfo(collection)
 	if info != nil {
 		collection = info.BackendName()
 	}
 
-	values := make([]*FieldValueExpr, 0)
-	for key, val := range data {
-		values = append(values, NewFieldVal(key, val))
-	}
 	stmt := NewUpdateStmt(collection, values, query.GetStatement())
-	stmt.SetRawValue(data)
-
 	return b.backend.Exec(stmt)
 }
 
+// Delete deletes a model.
+// Models with a soft-delete field are only marked as deleted.
 func (b *BaseBackend) Delete(model interface{}) apperror.Error {
+	return b.deleteModel(model, false)
+}
+
+// deleteModel deletes a model, or soft deletes it if supported and force is
+// false.
+func (b *BaseBackend) deleteModel(model interface{}, force bool) apperror.Error {
 	info, err := b.backend.InfoForModel(model)
 	if err != nil {
 		return err
@@ -1513,6 +2036,15 @@ func (b *BaseBackend) Delete(model interface{}) apperror.Error {
 		return apperror.New("model_without_id", "Can't delete a model without an id.")
 	}
 
+	if txBackend := b.lockedTransaction(info); txBackend != nil && (force || info.SoftDeleteAttribute() == nil) {
+		return WithTransaction(txBackend, func(tx Transaction) error {
+			if force {
+				return tx.ForceDelete(model)
+			}
+			return tx.Delete(model)
+		})
+	}
+
 	if err := CallModelHook(b.backend, model, "BeforeDelete"); err != nil {
 		return err
 	}
@@ -1522,17 +2054,29 @@ func (b *BaseBackend) Delete(model interface{}) apperror.Error {
 		handler(b.backend, model)
 	}
 
-	if err := b.PersistRelations("delete", true, info, model); err != nil {
-		return err
-	}
+	if info.SoftDeleteAttribute() != nil && !force {
+		// Relations are kept, so that the model can be restored.
+		if err := b.softDelete(info, model); err != nil {
+			return err
+		}
+	} else {
+		if err := b.PersistRelations("delete", true, info, model); err != nil {
+			return err
+		}
 
-	stmt := info.ModelDeleteStmt(model)
-	if err := b.backend.Exec(stmt); err != nil {
-		return err
-	}
+		stmt := info.ModelDeleteStmt(model)
+		if attr := info.VersionAttribute(); attr != nil {
+			version := versionInt(versionField(model, attr))
+			if err := b.execLocked(info, model, stmt, stmt.SelectStmt(), version); err != nil {
+				return err
+			}
+		} else if err := b.backend.Exec(stmt); err != nil {
+			return err
+		}
 
-	if err := b.PersistRelations("delete", false, info, model); err != nil {
-		return err
+		if err := b.PersistRelations("delete", false, info, model); err != nil {
+			return err
+		}
 	}
 
 	CallModelHook(b.backend, model, "AfterDelete")
@@ -1545,11 +2089,22 @@ func (b *BaseBackend) Delete(model interface{}) apperror.Error {
 	return nil
 }
 
+// DeleteMany deletes all models matching the query.
+// Models with a soft-delete field are only marked as deleted.
 func (b *BaseBackend) DeleteMany(query *Query) apperror.Error {
 	collection := query.GetCollection()
 	info := b.ModelInfo(collection)
 	if info != nil {
 		collection = info.BackendName()
+
+		if attr := info.SoftDeleteAttribute(); attr != nil {
+			// Models that are already deleted keep their deletion time.
+			sel := query.GetStatement().Copy()
+			sel.FilterAnd(IsNull(info.BackendName(), attr.BackendName()))
+			now := time.Now()
+			values := []*FieldValueExpr{NewFieldVal(attr.BackendName(), &now)}
+			return b.backend.Exec(NewUpdateStmt(collection, values, sel))
+		}
 	}
 
 	stmt := NewDeleteStmt(collection, query.GetStatement())
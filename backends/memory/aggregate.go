@@ -0,0 +1,431 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Aggregation.
+ */
+
+// aggregate groups the items by the group by expressions of the statement,
+// and returns a result map for each group.
+func (b *Backend) aggregate(info *db.ModelInfo, items *reflector.SliceReflector, s *SelectStmt) ([]interface{}, apperror.Error) {
+	// Group items.
+	groups := make(map[string][]*reflector.Reflector)
+	keys := make([]string, 0)
+	for _, item := range items.Items() {
+		key := ""
+		for _, expr := range s.GroupBy() {
+			attr, err := b.aggregateAttribute(info, expr)
+			if err != nil {
+				return nil, err
+			}
+			val, err := itemValue(info, item, attr)
+			if err != nil {
+				return nil, err
+			}
+			key += fmt.Sprintf("%v|", val)
+		}
+
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], item)
+	}
+
+	if len(s.GroupBy()) == 0 && len(keys) == 0 {
+		// Aggregates without a group by always return one row.
+		keys = append(keys, "")
+	}
+
+	rows := make([]map[string]interface{}, 0)
+	for _, key := range keys {
+		row, err := b.aggregateRow(info, groups[key], s)
+		if err != nil {
+			return nil, err
+		}
+
+		if having := s.Having(); having != nil {
+			flag, err := b.filterRow(info, s, row, having)
+			if err != nil {
+				return nil, err
+			}
+			if !flag {
+				continue
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(s.Sorts()) > 0 {
+		sorter := &rowSorter{rows: rows}
+		for _, sortExpr := range s.Sorts() {
+			name, err := b.rowFieldName(info, s, sortExpr.Expression())
+			if err != nil {
+				return nil, err
+			}
+			sorter.fields = append(sorter.fields, name)
+			sorter.ascending = append(sorter.ascending, sortExpr.Ascending())
+		}
+		sort.Sort(sorter)
+	}
+
+	if offset := s.Offset(); offset > 0 {
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		rows = rows[offset:]
+	}
+	if limit := s.Limit(); limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	result := make([]interface{}, len(rows), len(rows))
+	for i, row := range rows {
+		result[i] = row
+	}
+	return result, nil
+}
+
+// aggregateRow builds the result map for a group of items.
+func (b *Backend) aggregateRow(info *db.ModelInfo, items []*reflector.Reflector, s *SelectStmt) (map[string]interface{}, apperror.Error) {
+	row := make(map[string]interface{})
+
+	for _, field := range s.Fields() {
+		sel, ok := field.(*FieldSelectorExpr)
+		if !ok {
+			return nil, apperror.New("unsupported_aggregate_field", fmt.Sprintf("The memory backend only supports named fields in aggregate queries, got %v", reflect.TypeOf(field)))
+		}
+
+		if fn, ok := sel.Expression().(*FunctionExpr); ok && IsAggregate(fn) {
+			val, err := b.aggregateFunction(info, items, fn)
+			if err != nil {
+				return nil, err
+			}
+			row[sel.Name()] = val
+			continue
+		}
+
+		attr, err := b.aggregateAttribute(info, sel.Expression())
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			val, err := itemValue(info, items[0], attr)
+			if err != nil {
+				return nil, err
+			}
+			row[sel.Name()] = val
+		} else {
+			row[sel.Name()] = nil
+		}
+	}
+
+	return row, nil
+}
+
+// aggregateFunction computes COUNT, SUM, AVG, MIN or MAX for a group of items.
+func (b *Backend) aggregateFunction(info *db.ModelInfo, items []*reflector.Reflector, fn *FunctionExpr) (interface{}, apperror.Error) {
+	function := strings.ToUpper(fn.Function())
+
+	if text, ok := fn.Expression().(*TextExpr); ok && text.Text() == "*" {
+		if function != AGGREGATE_COUNT {
+			return nil, apperror.New("invalid_aggregate", fmt.Sprintf("%v(*) is not supported", function))
+		}
+		return len(items), nil
+	}
+
+	attr, err := b.aggregateAttribute(info, fn.Expression())
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0)
+	for _, item := range items {
+		val, err := itemValue(info, item, attr)
+		if err != nil {
+			return nil, err
+		}
+		if val != nil {
+			values = append(values, val)
+		}
+	}
+
+	switch function {
+	case AGGREGATE_COUNT:
+		return len(values), nil
+
+	case AGGREGATE_SUM, AGGREGATE_AVG:
+		if len(values) == 0 {
+			return nil, nil
+		}
+
+		isInt := true
+		var intSum int64
+		var floatSum float64
+		for _, val := range values {
+			r := reflector.R(val)
+			if !r.IsNumeric() {
+				return nil, apperror.New("invalid_aggregate", fmt.Sprintf("Can't compute %v of non-numeric field %v", function, attr.Name()))
+			}
+
+			switch reflect.TypeOf(val).Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				x, err := r.ConvertTo(int64(0))
+				if err != nil {
+					return nil, apperror.Wrap(err, "aggregate_conversion_error")
+				}
+				intSum += x.(int64)
+				floatSum += float64(x.(int64))
+			default:
+				isInt = false
+				x, err := r.ConvertTo(float64(0))
+				if err != nil {
+					return nil, apperror.Wrap(err, "aggregate_conversion_error")
+				}
+				floatSum += x.(float64)
+			}
+		}
+
+		if function == AGGREGATE_AVG {
+			return floatSum / float64(len(values)), nil
+		} else if isInt {
+			return intSum, nil
+		}
+		return floatSum, nil
+
+	case AGGREGATE_MIN, AGGREGATE_MAX:
+		var result interface{}
+		operator := "<"
+		if function == AGGREGATE_MAX {
+			operator = ">"
+		}
+		for _, val := range values {
+			if result == nil {
+				result = val
+				continue
+			}
+			flag, err := reflector.R(val).CompareTo(result, operator)
+			if err != nil {
+				return nil, apperror.Wrap(err, "compare_error")
+			}
+			if flag {
+				result = val
+			}
+		}
+		return result, nil
+	}
+
+	return nil, apperror.New("unsupported_aggregate", fmt.Sprintf("Unknown aggregate function %v", function))
+}
+
+// filterRow checks if an aggregated row matches a having filter.
+func (b *Backend) filterRow(info *db.ModelInfo, s *SelectStmt, row map[string]interface{}, filter Expression) (bool, apperror.Error) {
+	switch f := filter.(type) {
+	case *AndExpr:
+		for _, e := range f.Expressions() {
+			flag, err := b.filterRow(info, s, row, e)
+			if err != nil || !flag {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case *OrExpr:
+		for _, e := range f.Expressions() {
+			flag, err := b.filterRow(info, s, row, e)
+			if err != nil {
+				return false, err
+			} else if flag {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case *NotExpr:
+		flag, err := b.filterRow(info, s, row, f.Not())
+		if err != nil {
+			return false, err
+		}
+		return !flag, nil
+
+	case FilterExpression:
+		name, err := b.rowFieldName(info, s, f.Field())
+		if err != nil {
+			return false, err
+		}
+		val, ok := row[name]
+		if !ok {
+			return false, apperror.New("invalid_having", fmt.Sprintf("Having filter references field %v which was not selected", name))
+		}
+
+		valExpr, ok := f.Clause().(*ValueExpr)
+		if !ok {
+			return false, apperror.New("unsupported_filter_clause", fmt.Sprintf("The memory backend does not support filtering with custom clause expressions"))
+		}
+
+		flag, err2 := reflector.R(val).CompareTo(valExpr.Value(), f.Operator())
+		if err2 != nil {
+			return false, apperror.Wrap(err2, "compare_error")
+		}
+		return flag, nil
+	}
+
+	return false, apperror.New("unsupported_filter", fmt.Sprintf("Unhandled having expression: %v", reflect.TypeOf(filter)))
+}
+
+// rowFieldName returns the key in an aggregated row for an expression.
+// Identifiers are names of aggregates or selected fields, collection fields
+// are resolved to the name of the field selector that selects them.
+func (b *Backend) rowFieldName(info *db.ModelInfo, s *SelectStmt, expr Expression) (string, apperror.Error) {
+	if id, ok := expr.(*IdentifierExpr); ok {
+		return id.Identifier(), nil
+	}
+
+	attr, err := b.aggregateAttribute(info, expr)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range s.Fields() {
+		sel, ok := field.(*FieldSelectorExpr)
+		if !ok || IsAggregate(sel.Expression()) {
+			continue
+		}
+		if selAttr, _ := b.aggregateAttribute(info, sel.Expression()); selAttr == attr {
+			return sel.Name(), nil
+		}
+	}
+
+	return "", apperror.New("invalid_field", fmt.Sprintf("The field %v was not selected", attr.Name()))
+}
+
+// aggregateAttribute returns the attribute referenced by an identifier.
+func (b *Backend) aggregateAttribute(info *db.ModelInfo, expr Expression) (*db.Attribute, apperror.Error) {
+	fieldName := ""
+	if id, ok := expr.(*IdentifierExpr); ok {
+		fieldName = id.Identifier()
+	} else if id, ok := expr.(*ColFieldIdentifierExpr); ok {
+		if col := id.Collection(); col != "" && col != info.Collection() && col != info.BackendName() {
+			return nil, apperror.New("unsupported_aggregate", "The memory backend does not support aggregating joined collections")
+		}
+		fieldName = id.Field()
+	} else {
+		return nil, apperror.New("unsupported_aggregate", fmt.Sprintf("The memory backend does not support aggregating custom expressions"))
+	}
+
+	attr := info.FindAttribute(fieldName)
+	if attr == nil {
+		return nil, apperror.New("unknown_field", fmt.Sprintf("The collection %v does not have a field %v", info.Collection(), fieldName))
+	}
+	return attr, nil
+}
+
+// itemValue returns the value of an attribute of a struct or map item.
+func itemValue(info *db.ModelInfo, item *reflector.Reflector, attr *db.Attribute) (interface{}, apperror.Error) {
+	if info.StructName() != "" {
+		s, err := item.Struct()
+		if err != nil {
+			return nil, apperror.Wrap(err, "invalid_model_error")
+		}
+		return s.Field(attr.Name()).Interface(), nil
+	}
+
+	if !item.IsMap() {
+		return nil, apperror.New("invalid_model", "Model value is neither struct nor map")
+	}
+	val := item.Value().MapIndex(reflect.ValueOf(attr.BackendName()))
+	if !val.IsValid() {
+		return nil, nil
+	}
+	return val.Interface(), nil
+}
+
+/**
+ * rowSorter.
+ */
+
+// rowSorter implements sort.Interface for aggregated rows.
+type rowSorter struct {
+	rows      []map[string]interface{}
+	fields    []string
+	ascending []bool
+}
+
+func (s *rowSorter) Len() int {
+	return len(s.rows)
+}
+
+func (s *rowSorter) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+func (s *rowSorter) Less(i, j int) bool {
+	for index, field := range s.fields {
+		a := s.rows[i][field]
+		b := s.rows[j][field]
+
+		if equal, _ := reflector.R(a).CompareTo(b, "="); equal {
+			continue
+		}
+
+		operator := "<"
+		if !s.ascending[index] {
+			operator = ">"
+		}
+		flag, _ := reflector.R(a).CompareTo(b, operator)
+		return flag
+	}
+	return false
+}
+
+/**
+ * itemSorter.
+ */
+
+// itemSorter implements sort.Interface for items, with the values of the
+// sort fields of each item.
+type itemSorter struct {
+	items     []*reflector.Reflector
+	values    [][]interface{}
+	ascending []bool
+}
+
+func (s *itemSorter) Len() int {
+	return len(s.items)
+}
+
+func (s *itemSorter) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+func (s *itemSorter) Less(i, j int) bool {
+	for index, ascending := range s.ascending {
+		a := s.values[i][index]
+		b := s.values[j][index]
+
+		if equal, _ := reflector.R(a).CompareTo(b, "="); equal {
+			continue
+		}
+
+		operator := "<"
+		if !ascending {
+			operator = ">"
+		}
+		flag, _ := reflector.R(a).CompareTo(b, operator)
+		return flag
+	}
+	return false
+}
@@ -3,7 +3,9 @@ package memory
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/theduke/go-apperror"
 	"github.com/theduke/go-reflector"
@@ -16,13 +18,23 @@ type Backend struct {
 	db.BaseBackend
 
 	data map[string]map[string]interface{}
+	// lock guards data. It is shared by all clones using the same data.
+	lock *sync.RWMutex
+	// state holds the id counters and change tracking of data.
+	// It is guarded by lock, and shared by all clones and transactions.
+	state *dataState
 
 	MigrationHandler *db.MigrationHandler
 	MigrationVersion int
+
+	// tx holds the transaction state if the backend is a transaction.
+	tx *transaction
 }
 
 // Ensure that Backend implements the db.Backend interface at compile time.
 var _ db.Backend = (*Backend)(nil)
+var _ db.TransactionBackend = (*Backend)(nil)
+var _ db.RetryableErrorBackend = (*Backend)(nil)
 var _ db.MigrationBackend = (*Backend)(nil)
 
 func New() *Backend {
@@ -31,6 +43,8 @@ func New() *Backend {
 	b.SetName("memory")
 
 	b.data = make(map[string]map[string]interface{})
+	b.lock = &sync.RWMutex{}
+	b.state = newDataState()
 
 	b.MigrationHandler = db.NewMigrationHandler(b)
 	b.MigrationVersion = 0
@@ -53,16 +67,24 @@ func (b *Backend) Clone() db.Backend {
 	copied := &Backend{
 		BaseBackend:      b.BaseBackend,
 		data:             b.data,
+		lock:             b.lock,
+		state:            b.state,
 		MigrationHandler: b.MigrationHandler,
 		MigrationVersion: b.MigrationVersion,
+		tx:               b.tx,
 	}
+	copied.BaseBackend.SetBackend(copied)
 
 	return copied
 }
 
 func (b *Backend) RegisterModel(m interface{}) *db.ModelInfo {
 	info := b.BaseBackend.RegisterModel(m)
+
+	b.lock.Lock()
 	b.data[info.Collection()] = make(map[string]interface{})
+	b.lock.Unlock()
+
 	return info
 }
 
@@ -86,34 +108,61 @@ func (b *Backend) Build() {
 			attr.SetType(reflect.TypeOf(""))
 			m2mCol.AddAttribute(attr)
 
+			b.lock.Lock()
 			b.data[relation.BackendName()] = make(map[string]interface{})
+			b.lock.Unlock()
 		}
 	}
 }
 
-func (b *Backend) sort(info *db.ModelInfo, items *reflector.SliceReflector, field Expression, asc bool) apperror.Error {
-	fieldName := ""
-	if id, ok := field.(*IdentifierExpr); ok {
-		fieldName = id.Identifier()
-	} else if id, ok := field.(*ColFieldIdentifierExpr); ok {
-		if id.Collection() != info.Collection() {
-			return apperror.New("unsupported_sort", fmt.Sprint("The memory backend does not support sorting with joined collections"))
+// sort sorts items lexicographically by all sort expressions.
+// Items with equal sort values keep their order.
+func (b *Backend) sort(info *db.ModelInfo, items *reflector.SliceReflector, sorts []*SortExpr) (*reflector.SliceReflector, apperror.Error) {
+	attrs := make([]*db.Attribute, 0, len(sorts))
+	for _, sortExpr := range sorts {
+		fieldName := ""
+		if id, ok := sortExpr.Expression().(*IdentifierExpr); ok {
+			fieldName = id.Identifier()
+		} else if id, ok := sortExpr.Expression().(*ColFieldIdentifierExpr); ok {
+			if id.Collection() != info.Collection() {
+				return nil, apperror.New("unsupported_sort", fmt.Sprint("The memory backend does not support sorting with joined collections"))
+			}
+			fieldName = id.Field()
+		} else {
+			return nil, apperror.New("unsupported_sort", fmt.Sprintf("The memory backend does not support sorting with custom field expressions"))
 		}
-		fieldName = id.Field()
-	} else {
-		return apperror.New("unsupported_sort", fmt.Sprintf("The memory backend does not support sorting with custom field expressions"))
-	}
 
-	attr := info.FindAttribute(fieldName)
-	if attr == nil {
-		return apperror.New("invalid_sort", fmt.Sprintf("Invalid sort for inexistant field %v", fieldName))
+		attr := info.FindAttribute(fieldName)
+		if attr == nil {
+			return nil, apperror.New("invalid_sort", fmt.Sprintf("Invalid sort for inexistant field %v", fieldName))
+		}
+		attrs = append(attrs, attr)
 	}
 
-	if err := items.SortByField(attr.Name(), asc); err != nil {
-		return apperror.Wrap(err, "sort_error")
+	sorter := &itemSorter{items: items.Items()}
+	for _, item := range sorter.items {
+		values := make([]interface{}, 0, len(attrs))
+		for _, attr := range attrs {
+			val, err := itemValue(info, item, attr)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+		}
+		sorter.values = append(sorter.values, values)
+	}
+	for _, sortExpr := range sorts {
+		sorter.ascending = append(sorter.ascending, sortExpr.Ascending())
 	}
+	sort.Stable(sorter)
 
-	return nil
+	sorted := reflector.R(info.Item()).NewSlice()
+	for _, item := range sorter.items {
+		if err := sorted.AppendValue(item.Interface()); err != nil {
+			return nil, apperror.Wrap(err, "slice_append_error")
+		}
+	}
+	return sorted, nil
 }
 
 func (b *Backend) filter(info *db.ModelInfo, items *reflector.SliceReflector, filter Expression) (*reflector.SliceReflector, apperror.Error) {
@@ -166,6 +215,52 @@ func (b *Backend) filterItem(info *db.ModelInfo, item *reflector.Reflector, filt
 			return !flag, nil
 		}
 
+	case *ElemMatchExpr:
+		fieldName := ""
+		if id, ok := f.Field().(*IdentifierExpr); ok {
+			fieldName = id.Identifier()
+		} else if id, ok := f.Field().(*ColFieldIdentifierExpr); ok {
+			fieldName = id.Field()
+		}
+		attr := info.FindAttribute(fieldName)
+		if attr == nil {
+			return false, apperror.New("invalid_filter", fmt.Sprintf("Invalid filter for inexistant field %v", fieldName))
+		}
+
+		val, err := itemValue(info, item, attr)
+		if err != nil {
+			return false, err
+		}
+		r := reflector.R(val)
+		if !r.IsSlice() {
+			return false, apperror.New("invalid_filter", fmt.Sprintf("Element match filter on non-array field %v", fieldName))
+		}
+		slice, err2 := r.Slice()
+		if err2 != nil {
+			return false, apperror.Wrap(err2, "invalid_filter")
+		}
+		for _, elem := range slice.Items() {
+			flag, err := matchElement(elem.Interface(), f.Filter())
+			if err != nil {
+				return false, apperror.Wrap(err, "compare_error")
+			}
+			if flag {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case *ExistsExpr:
+		stmt, err := b.bindOuterFields(info, item, f.SelectStmt())
+		if err != nil {
+			return false, err
+		}
+		result, err := b.exec(stmt)
+		if err != nil {
+			return false, err
+		}
+		return len(result) > 0, nil
+
 	case FilterExpression:
 		field := f.Field()
 
@@ -189,9 +284,15 @@ func (b *Backend) filterItem(info *db.ModelInfo, item *reflector.Reflector, filt
 
 		operator := f.Operator()
 
+		if stmt, ok := f.Clause().(*SelectStmt); ok {
+			return b.filterSubquery(info, item, attr, operator, stmt)
+		}
+
 		var clauseValue interface{}
 
-		if valExpr, ok := f.Clause().(*ValueExpr); ok {
+		if f.Clause() == nil && IsNullOperator(operator) {
+			// Null checks have no clause.
+		} else if valExpr, ok := f.Clause().(*ValueExpr); ok {
 			clauseValue = valExpr.Value()
 		} else {
 			return false, apperror.New("unsupported_filter_clause", fmt.Sprintf("The memory backend does not support filtering with custom clause expressions"))
@@ -203,7 +304,7 @@ func (b *Backend) filterItem(info *db.ModelInfo, item *reflector.Reflector, filt
 			if err != nil {
 				return false, apperror.Wrap(err, "invalid_model_error")
 			}
-			flag, err := s.Field(attr.Name()).CompareTo(clauseValue, operator)
+			flag, err := compare(s.Field(attr.Name()), clauseValue, operator)
 			if err != nil {
 				return false, apperror.Wrap(err, "compare_error")
 			}
@@ -213,7 +314,7 @@ func (b *Backend) filterItem(info *db.ModelInfo, item *reflector.Reflector, filt
 			if !item.IsMap() {
 				return false, apperror.New("filter_invalid_model", "Could not filter because model value is neither struct nor map.")
 			}
-			flag, err := reflector.R(item.Value().MapIndex(reflect.ValueOf(attr.BackendName()))).CompareTo(clauseValue, operator)
+			flag, err := compare(reflector.R(item.Value().MapIndex(reflect.ValueOf(attr.BackendName()))), clauseValue, operator)
 			if err != nil {
 				return false, apperror.Wrap(err, "compare_error")
 			}
@@ -235,16 +336,22 @@ func (b *Backend) exec(statement Expression) ([]interface{}, apperror.Error) {
 		if _, ok := b.data[col]; !ok {
 			b.data[col] = make(map[string]interface{})
 		}
+		b.recordSchemaChange(s)
 
 	case *RenameCollectionStmt:
 		b.data[s.NewName()] = b.data[s.Collection()]
 		delete(b.data, s.Collection())
+		b.recordSchemaChange(s)
 
 	case *DropFieldStmt:
 		// No-op.
 
 	case *DropCollectionStmt:
 		delete(b.data, s.Collection())
+		if b.tx == nil {
+			delete(b.state.ids, s.Collection())
+		}
+		b.recordSchemaChange(s)
 
 	case *CreateFieldStmt:
 		// No-op.
@@ -285,13 +392,20 @@ func (b *Backend) exec(statement Expression) ([]interface{}, apperror.Error) {
 			}
 		}
 
-		if sorts := s.Sorts(); len(sorts) > 1 {
-			panic("Memory backend does not support sorting by more than one field")
-		} else if len(sorts) == 1 {
-			b.Logger().Infof("Sorting with %+v", sorts[0])
-			if err := b.sort(info, items, sorts[0].Expression(), sorts[0].Ascending()); err != nil {
+		if s.IsAggregate() {
+			if len(s.Joins()) > 0 {
+				panic("Memory backend does not support native joins.")
+			}
+			return b.aggregate(info, items, s)
+		}
+
+		if sorts := s.Sorts(); len(sorts) > 0 {
+			b.Logger().Infof("Sorting with %+v", sorts)
+			sorted, err := b.sort(info, items, sorts)
+			if err != nil {
 				return nil, err
 			}
+			items = sorted
 		}
 
 		if offset := s.Offset(); offset > 0 {
@@ -324,9 +438,13 @@ func (b *Backend) exec(statement Expression) ([]interface{}, apperror.Error) {
 			panic("Memory backend does not support native joins.")
 		}
 
+		// Items are copied, so that changes to returned models do not modify
+		// the stored data.
+		// Attributes that were not selected are left empty.
+		selected := selectedAttributes(info, s)
 		ifSlice := make([]interface{}, items.Len(), items.Len())
 		for i, item := range items.Items() {
-			ifSlice[i] = item.Interface()
+			ifSlice[i] = projectItem(info, copyItem(item.Interface()), selected)
 		}
 		b.Logger().Infof("if slice %+v", ifSlice)
 		return ifSlice, nil
@@ -340,170 +458,491 @@ func (b *Backend) exec(statement Expression) ([]interface{}, apperror.Error) {
 		if info == nil {
 			return nil, apperror.New("unknown_collection", fmt.Sprintf("Collection %v was not registered with backend", s.Collection()))
 		}
-		collection = info.Collection()
 
-		obj := s.RawValue()
+		// Multi-row inserts hold a raw value for each row.
+		for _, obj := range s.RawValues() {
+			if err := b.createItem(info, obj); err != nil {
+				return nil, err
+			}
+		}
+
+	case *UpsertStmt:
+		info := b.ModelInfos().Find(s.Collection())
+		if info == nil {
+			return nil, apperror.New("unknown_collection", fmt.Sprintf("Collection %v was not registered with backend", s.Collection()))
+		}
+		if err := b.upsertItem(info, s); err != nil {
+			return nil, err
+		}
+
+	case *UpdateStmt:
+		_, err := b.execUpdate(s)
+		return nil, err
+
+	case *DeleteStmt:
+		_, err := b.execDelete(s)
+		return nil, err
+
+	default:
+		panic(fmt.Sprintf("Unhandled statement type: %v", reflect.TypeOf(statement)))
+	}
 
-		collection = info.Collection()
+	return nil, nil
+}
 
-		b.Logger().Infof("Creating with alldata: %+v", b.data[collection])
+// execUpdate executes an UpdateStmt and returns the number of updated items.
+func (b *Backend) execUpdate(s *UpdateStmt) (int, apperror.Error) {
 
-		var newId string
+	obj := s.RawValue()
+	var info *db.ModelInfo
+	if obj != nil {
+		info, _ = b.InfoForModel(obj)
+	}
+	if info != nil {
+		// Direct update for one model.
+		// So just update the model in the data.
+		id, err := info.DetermineModelStrId(obj)
+		if err != nil {
+			return 0, err
+		}
 
-		if info.HasStruct() {
-			id, err := info.DetermineModelStrId(obj)
+		existing, ok := b.data[info.Collection()][id]
+		if !ok {
+			return 0, nil
+		}
+		// The select may hold additional conditions, like the version
+		// filter of optimistic locking, which must match the stored item.
+		if sel := s.Select(); sel != nil && sel.Filter() != nil {
+			matches, err := b.filterItem(info, reflector.R(existing), sel.Filter())
 			if err != nil {
-				return nil, err
+				return 0, err
+			} else if !matches {
+				return 0, nil
 			}
-			if id == "" {
-				// Empty id, so create a new one and update the model.
-				intId := len(b.data[collection]) + 1
-				id = strconv.Itoa(intId)
-				if err := info.SetModelId(obj, id); err != nil {
-					return nil, err
-				}
+		}
+
+		// Only the fields in the statement are written, so fields that were
+		// not changed keep their stored values.
+		b.data[info.Collection()][id] = mergeUpdate(info, existing, obj, s.Values())
+		b.recordChange(info.Collection(), id)
+
+		// All done.
+		return 1, nil
+	}
+
+	// Must be a custom update with a select.
+
+	info = b.ModelInfos().Find(s.Collection())
+	if info == nil {
+		return 0, apperror.New("unknown_collection", fmt.Sprintf("Collection %v was not registered with backend", s.Collection()))
+	}
+
+	// Execute select query to find items.
+	items, err := b.exec(s.Select())
+	if err != nil {
+		return 0, err
+	}
+
+	slice := reflector.R(items).MustSlice()
+
+	// Raw data is applied as is. Otherwise the values are evaluated
+	// for each item, since they may reference the fields of the item.
+	rawData, _ := s.RawValue().(map[string]interface{})
+
+	attrs := make([]*db.Attribute, 0)
+	if rawData == nil {
+		for _, field := range s.Values() {
+			expr, ok := field.Field().(*IdentifierExpr)
+			if !ok {
+				return 0, apperror.New("unsupported_field_expression",
+					"The memory backend does not support custom field expressions")
 			}
-			newId = id
-		} else {
-			// Map instead of struct.
-			mapObj := obj.(map[string]interface{})
-			rawId := mapObj[info.PkAttribute().BackendName()]
-			idRefl := reflector.R(rawId)
-
-			id := ""
-			if idRefl.IsZero() {
-				intId := len(b.data[collection]) + 1
-				id = strconv.Itoa(intId)
-				mapObj[info.PkAttribute().BackendName()] = id
-			} else {
-				strId, err := idRefl.ConvertTo("")
+
+			attr := info.FindAttribute(expr.Identifier())
+			if attr == nil {
+				return 0, apperror.New("unknown_field",
+					fmt.Sprintf("The collection %v does not have a field %v", info.Collection(), expr.Identifier()))
+			}
+			attrs = append(attrs, attr)
+		}
+	}
+
+	// Update each item with the new data.
+	for _, item := range slice.Items() {
+		data := rawData
+		if data == nil {
+			// Values are evaluated with the data before the update.
+			data = make(map[string]interface{})
+			for i, field := range s.Values() {
+				val, err := evalValue(info, item, field.Value())
 				if err != nil {
-					return nil, apperror.Wrap(err, "id_conversion_error")
+					return 0, err
+				}
+				if item.IsMap() {
+					data[attrs[i].BackendName()] = val
+				} else {
+					data[attrs[i].Name()] = val
 				}
-				mapObj[info.PkAttribute().BackendName()] = strId.(string)
 			}
-
-			obj = mapObj
-			newId = id
 		}
 
-		b.data[collection][newId] = obj
-		b.Logger().Infof("created model %+v", obj)
+		// Items may be in use by concurrent readers or shared with the
+		// parent backend of a transaction, so they are copied before
+		// they are modified.
+		obj := copyItem(item.Interface())
+		id, err := info.DetermineModelStrId(obj)
+		if err != nil {
+			return 0, err
+		}
+		b.data[info.Collection()][id] = obj
+		b.recordChange(info.Collection(), id)
+		item = reflector.R(obj)
 
-	case *UpdateStmt:
+		if item.IsStruct() || item.IsStructPtr() {
+			s := item.MustStruct()
 
-		obj := s.RawValue()
-		info, err := b.InfoForModel(obj)
-		if err == nil {
-			// Direct update for one model.
-			// So just update the model in the data.
-			id, err := info.DetermineModelStrId(obj)
-			if err != nil {
-				return nil, err
+			for key, val := range data {
+				if err := s.Field(key).SetValue(val); err != nil {
+					return 0, apperror.Wrap(err, "struct_field_update_error")
+				}
+			}
+		} else if item.IsMap() {
+			for key, val := range data {
+				if err := item.SetStrMapKeyValue(key, val, true); err != nil {
+					return 0, apperror.Wrap(err, "struct_field_update_error")
+				}
 			}
-			b.data[info.Collection()][id] = obj
+		}
+	}
+
+	return len(slice.Items()), nil
+}
+
+// mergeUpdate returns a copy of the updated model, where all attributes
+// that are not part of the update values keep the value of the stored item.
+func mergeUpdate(info *db.ModelInfo, existing, obj interface{}, values []*FieldValueExpr) interface{} {
+	updated := copyItem(obj)
 
-			// All done.
-			return nil, nil
+	written := make(map[string]bool)
+	for _, val := range values {
+		if id, ok := val.Field().(*IdentifierExpr); ok {
+			written[id.Identifier()] = true
 		}
+	}
 
-		// Must be a custom update with a select.
+	existingVal := reflect.Indirect(reflect.ValueOf(existing))
+	updatedVal := reflect.Indirect(reflect.ValueOf(updated))
+	for name, attr := range info.Attributes() {
+		if !written[attr.BackendName()] {
+			updatedVal.FieldByName(name).Set(existingVal.FieldByName(name))
+		}
+	}
 
-		info = b.ModelInfos().Find(s.Collection())
-		if info == nil {
-			return nil, apperror.New("unknown_collection", fmt.Sprintf("Collection %v was not registered with backend", s.Collection()))
+	return updated
+}
+
+// selectedAttributes returns the names of the attributes selected by a
+// statement, or nil if all attributes are selected.
+func selectedAttributes(info *db.ModelInfo, s *SelectStmt) map[string]bool {
+	if len(s.Fields()) == 0 {
+		return nil
+	}
+
+	selected := make(map[string]bool)
+	for _, field := range s.Fields() {
+		sel, ok := field.(*FieldSelectorExpr)
+		if !ok {
+			return nil
+		}
+		id, ok := sel.Expression().(*ColFieldIdentifierExpr)
+		if !ok {
+			return nil
+		}
+		attr := info.FindAttribute(id.Field())
+		if attr == nil {
+			// Custom or nested field, so all attributes are returned.
+			return nil
 		}
+		selected[attr.Name()] = true
+	}
+	return selected
+}
 
-		// Execute select query to find items.
-		items, err := b.exec(s.Select())
+// projectItem sets all attributes of a struct item that are not selected to
+// their zero value.
+func projectItem(info *db.ModelInfo, item interface{}, selected map[string]bool) interface{} {
+	if selected == nil {
+		return item
+	}
+	val := reflect.Indirect(reflect.ValueOf(item))
+	if val.Kind() != reflect.Struct {
+		return item
+	}
+
+	for name := range info.Attributes() {
+		if !selected[name] {
+			field := val.FieldByName(name)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+	return item
+}
+
+// execDelete executes a DeleteStmt and returns the number of deleted items.
+func (b *Backend) execDelete(s *DeleteStmt) (int, apperror.Error) {
+	info := b.ModelInfos().Find(s.Collection())
+
+	// Execute select query to find items.
+	items, err := b.exec(s.SelectStmt())
+	if err != nil {
+		return 0, err
+	}
+
+	slice := reflector.R(items).MustSlice()
+	for _, item := range slice.Items() {
+		id, err := info.DetermineModelStrId(item.Interface())
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 
-		slice := reflector.R(items).MustSlice()
+		delete(b.data[info.Collection()], id)
+		b.recordChange(info.Collection(), id)
+	}
 
-		// Determine update data.
-		var data map[string]interface{}
-		if d, ok := s.RawValue().(map[string]interface{}); ok {
-			// Data supplied as raw value.
-			data = d
-		} else {
-			// Build a map with the fields values to update.
-			data = make(map[string]interface{})
-			for _, field := range s.Values() {
-				expr, ok := field.Field().(*IdentifierExpr)
-				if !ok {
-					return nil, apperror.New("unsupported_field_expression",
-						"The memory backend does not support custom field expressions")
-				}
-				valExpr, ok := field.Value().(*ValueExpr)
-				if !ok {
-					return nil, apperror.New("unsupported_field_value_expression",
-						"The memory backend does not support custom field value expressions")
-				}
+	return len(slice.Items()), nil
+}
 
-				attr := info.FindAttribute(expr.Identifier())
-				if attr == nil {
-					return nil, apperror.New("unknown_field",
-						fmt.Sprintf("The collection %v does not have a field %v", info.Collection(), expr.Identifier()))
-				}
+// nextId returns a new unused integer id for the collection.
+// Transactions allocate ids from their parent under the parent lock, so
+// concurrent transactions and direct writes never get the same id.
+func (b *Backend) nextId(collection string) string {
+	if b.tx == nil {
+		// The lock is already held by the caller.
+		return b.allocateId(collection, nil)
+	}
+
+	parent := b.tx.parent
+	parent.lock.Lock()
+	defer parent.lock.Unlock()
+	return parent.allocateId(collection, b.data[collection])
+}
 
-				data[attr.Name()] = valExpr.Value()
+// allocateId increments the id counter of the collection until the id is
+// neither used in the backend nor in the passed items.
+// The lock must be held.
+func (b *Backend) allocateId(collection string, items map[string]interface{}) string {
+	intId := b.state.ids[collection]
+	for {
+		intId++
+		id := strconv.Itoa(intId)
+		_, inData := b.data[collection][id]
+		_, inItems := items[id]
+		if !inData && !inItems {
+			b.state.ids[collection] = intId
+			return id
+		}
+	}
+}
+
+// lockedExec executes a statement while holding the data lock.
+// Selects only acquire a read lock.
+func (b *Backend) lockedExec(statement Expression) ([]interface{}, apperror.Error) {
+	if _, isSelect := statement.(*SelectStmt); isSelect {
+		b.lock.RLock()
+		defer b.lock.RUnlock()
+	} else {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+	}
+	return b.exec(statement)
+}
+
+// createItem stores a new struct or map item.
+func (b *Backend) createItem(info *db.ModelInfo, obj interface{}) apperror.Error {
+	collection := info.Collection()
+
+	b.Logger().Infof("Creating with alldata: %+v", b.data[collection])
+
+	var newId string
+
+	if info.HasStruct() {
+		id, err := info.DetermineModelStrId(obj)
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			// Empty id, so create a new one and update the model.
+			id = b.nextId(collection)
+			if err := info.SetModelId(obj, id); err != nil {
+				return err
 			}
 		}
+		newId = id
+	} else {
+		// Map instead of struct.
+		mapObj := obj.(map[string]interface{})
+		rawId := mapObj[info.PkAttribute().BackendName()]
+		idRefl := reflector.R(rawId)
+
+		id := ""
+		if idRefl.IsZero() {
+			id = b.nextId(collection)
+			mapObj[info.PkAttribute().BackendName()] = id
+		} else {
+			strId, err := idRefl.ConvertTo("")
+			if err != nil {
+				return apperror.Wrap(err, "id_conversion_error")
+			}
+			id = strId.(string)
+			mapObj[info.PkAttribute().BackendName()] = id
+		}
+
+		obj = mapObj
+		newId = id
+	}
 
-		// Update each item with the new data.
-		for _, item := range slice.Items() {
-			if item.IsStruct() || item.IsStructPtr() {
-				s := item.MustStruct()
+	// A copy is stored, so that later changes to the model do not modify the
+	// stored data.
+	b.data[collection][newId] = copyItem(obj)
+	b.recordChange(collection, newId)
+	b.Logger().Infof("created model %+v", obj)
+	return nil
+}
+
+// upsertItem creates the raw value of an upsert statement, or updates the
+// existing item with equal conflict fields.
+func (b *Backend) upsertItem(info *db.ModelInfo, s *UpsertStmt) apperror.Error {
+	obj := s.RawValue()
+	newItem := reflector.R(obj)
+
+	for id, existing := range b.data[info.Collection()] {
+		item := reflector.R(existing)
+
+		matches := true
+		for _, field := range s.ConflictFields() {
+			attr := info.FindAttribute(field)
+			if attr == nil {
+				return apperror.New("unknown_field", fmt.Sprintf("The collection %v has no field %v", info.Collection(), field))
+			}
+
+			val, err := itemValue(info, item, attr)
+			if err != nil {
+				return err
+			}
+			newVal, err := itemValue(info, newItem, attr)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(val, newVal) {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
 
-				for key, val := range data {
-					if err := s.Field(key).SetValue(val); err != nil {
-						return nil, apperror.Wrap(err, "struct_field_update_error")
+		values := s.UpdateValues()
+		expressions := s.UpdateExpressions()
+		if len(values) > 0 || len(expressions) > 0 {
+			// Update a copy of the existing item, since items may be shared
+			// with the parent of a transaction.
+			var updated interface{}
+			if info.HasStruct() {
+				copied := reflect.New(reflect.TypeOf(existing).Elem())
+				copied.Elem().Set(reflect.ValueOf(existing).Elem())
+				for _, val := range values {
+					if attr := info.FindAttribute(val.Field().(*IdentifierExpr).Identifier()); attr != nil {
+						copied.Elem().FieldByName(attr.Name()).Set(reflect.ValueOf(obj).Elem().FieldByName(attr.Name()))
 					}
 				}
-			} else if item.IsMap() {
-				for key, val := range data {
-					if err := item.SetStrMapKeyValue(key, val, true); err != nil {
-						return nil, apperror.Wrap(err, "struct_field_update_error")
+				updated = copied.Interface()
+			} else {
+				copied := make(map[string]interface{})
+				for key, val := range existing.(map[string]interface{}) {
+					copied[key] = val
+				}
+				for _, val := range values {
+					if attr := info.FindAttribute(val.Field().(*IdentifierExpr).Identifier()); attr != nil {
+						copied[attr.BackendName()] = obj.(map[string]interface{})[attr.BackendName()]
 					}
 				}
+				updated = copied
 			}
-		}
 
-	case *DeleteStmt:
-		info := b.ModelInfos().Find(s.Collection())
-
-		// Execute select query to find items.
-		items, err := b.exec(s.SelectStmt())
-		if err != nil {
-			return nil, err
-		}
-
-		slice := reflector.R(items).MustSlice()
-		for _, item := range slice.Items() {
-			id, err := info.DetermineModelStrId(item.Interface())
-			if err != nil {
-				return nil, err
+			// Update expressions are evaluated with the existing item.
+			for _, expr := range expressions {
+				attr := info.FindAttribute(expr.Field().(*IdentifierExpr).Identifier())
+				if attr == nil {
+					continue
+				}
+				val, err := evalValue(info, item, expr.Value())
+				if err != nil {
+					return err
+				}
+				if info.HasStruct() {
+					field := reflect.ValueOf(updated).Elem().FieldByName(attr.Name())
+					newVal := reflect.ValueOf(val)
+					if val == nil {
+						newVal = reflect.Zero(field.Type())
+					} else if !newVal.Type().AssignableTo(field.Type()) {
+						if !newVal.Type().ConvertibleTo(field.Type()) {
+							return apperror.New("struct_field_update_error",
+								fmt.Sprintf("Can not set %v of type %v to a %v", attr.Name(), field.Type(), newVal.Type()))
+						}
+						newVal = newVal.Convert(field.Type())
+					}
+					field.Set(newVal)
+				} else {
+					updated.(map[string]interface{})[attr.BackendName()] = val
+				}
 			}
 
-			delete(b.data[info.Collection()], id)
+			b.data[info.Collection()][id] = updated
+			b.recordChange(info.Collection(), id)
 		}
 
-	default:
-		panic(fmt.Sprintf("Unhandled statement type: %v", reflect.TypeOf(statement)))
+		if info.HasStruct() {
+			return info.SetModelId(obj, id)
+		}
+		obj.(map[string]interface{})[info.PkAttribute().BackendName()] = id
+		return nil
 	}
 
-	return nil, nil
+	return b.createItem(info, obj)
 }
 
 func (b *Backend) Exec(statement Expression) apperror.Error {
-	_, err := b.exec(statement)
+	_, err := b.lockedExec(statement)
 	return err
 }
 
+// ExecAffected executes a statement and returns the number of updated or
+// deleted items. It returns -1 for other statements.
+func (b *Backend) ExecAffected(statement Expression) (int, apperror.Error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch s := statement.(type) {
+	case *UpdateStmt:
+		return b.execUpdate(s)
+	case *DeleteStmt:
+		return b.execDelete(s)
+	}
+
+	_, err := b.exec(statement)
+	return -1, err
+}
+
 func (b *Backend) ExecQuery(statement FieldedExpression) ([]interface{}, apperror.Error) {
-	return b.exec(statement)
+	return b.lockedExec(statement)
+}
+
+// CreateBatchSize implements db.BatchCreateBackend.
+// The memory backend has no parameter limits, so batches only have to respect
+// db.CREATE_BATCH_SIZE.
+func (b *Backend) CreateBatchSize(info *db.ModelInfo, fieldCount int) int {
+	return db.CREATE_BATCH_SIZE
 }
 
 func (b *Backend) Count(q *db.Query) (int, apperror.Error) {
@@ -2,7 +2,11 @@ package memory_test
 
 import (
 	. "github.com/onsi/ginkgo"
-	//. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega"
+
+	"fmt"
+	"runtime"
+	"sync"
 
 	"github.com/theduke/go-apperror"
 	db "github.com/theduke/go-dukedb"
@@ -16,3 +20,310 @@ var _ = Describe("Memory", func() {
 		return New(), nil
 	})
 })
+
+// retryBackend reports all errors with the code "retry" as retryable.
+type retryBackend struct {
+	*Backend
+}
+
+func (retryBackend) IsRetryableError(err error) bool {
+	appErr, ok := err.(apperror.Error)
+	return ok && appErr.GetCode() == "retry"
+}
+
+var _ = Describe("Memory sorting", func() {
+	It("Should sort by multiple fields", func() {
+		backend := New()
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+
+		for _, val := range []struct {
+			str string
+			i   int64
+		}{{"b", 1}, {"a", 2}, {"b", 2}, {"a", 1}} {
+			m := tests.NewTestModel(0)
+			m.StrVal = val.str
+			m.IntVal = val.i
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
+		}
+
+		res, err := backend.Q("test_models").Sort("str_val", true).Sort("int_val", false).Find()
+		Expect(err).ToNot(HaveOccurred())
+
+		sorted := make([]string, 0)
+		for _, item := range res {
+			m := item.(*tests.TestModel)
+			sorted = append(sorted, fmt.Sprintf("%v%v", m.StrVal, m.IntVal))
+		}
+		Expect(sorted).To(Equal([]string{"a2", "a1", "b2", "b1"}))
+	})
+})
+
+var _ = Describe("Memory transaction retry", func() {
+	var backend retryBackend
+
+	BeforeEach(func() {
+		backend = retryBackend{New()}
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+	})
+
+	It("Should retry retryable errors", func() {
+		calls := 0
+		err := db.WithTransactionRetry(backend, 3, func(tx db.Transaction) error {
+			calls++
+			m := tests.NewTestModel(calls)
+			if err := tx.Create(&m); err != nil {
+				return err
+			}
+			if calls < 3 {
+				return apperror.New("retry")
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal(3))
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+	})
+
+	It("Should give up after the maximum number of retries", func() {
+		calls := 0
+		err := db.WithTransactionRetry(backend, 2, func(tx db.Transaction) error {
+			calls++
+			return apperror.New("retry")
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.GetCode()).To(Equal("retry"))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("Should not retry other errors", func() {
+		calls := 0
+		err := db.WithTransactionRetry(backend, 2, func(tx db.Transaction) error {
+			calls++
+			return apperror.New("other")
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+})
+
+var _ = Describe("Memory transactions", func() {
+	var backend *Backend
+
+	BeforeEach(func() {
+		backend = New()
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+	})
+
+	It("Should discard items created in a rolled back transaction", func() {
+		tx := backend.MustBegin()
+		m := tests.NewTestModel(1)
+		Expect(tx.Create(&m)).ToNot(HaveOccurred())
+		Expect(tx.Q("test_models").Count()).To(Equal(1))
+		Expect(backend.Q("test_models").Count()).To(Equal(0))
+
+		Expect(tx.Rollback()).ToNot(HaveOccurred())
+		Expect(backend.Q("test_models").Count()).To(Equal(0))
+	})
+
+	It("Should allocate distinct ids in concurrent transactions", func() {
+		tx1 := backend.MustBegin()
+		tx2 := backend.MustBegin()
+
+		m1 := tests.NewTestModel(1)
+		Expect(tx1.Create(&m1)).ToNot(HaveOccurred())
+		m2 := tests.NewTestModel(2)
+		Expect(tx2.Create(&m2)).ToNot(HaveOccurred())
+		m3 := tests.NewTestModel(3)
+		Expect(backend.Create(&m3)).ToNot(HaveOccurred())
+
+		Expect(m1.Id).ToNot(Equal(m2.Id))
+		Expect(m3.Id).ToNot(Equal(m1.Id))
+		Expect(m3.Id).ToNot(Equal(m2.Id))
+
+		Expect(tx1.Commit()).ToNot(HaveOccurred())
+		Expect(tx2.Commit()).ToNot(HaveOccurred())
+		Expect(backend.Q("test_models").Count()).To(Equal(3))
+	})
+
+	It("Should fail to commit items changed after the transaction started", func() {
+		m := tests.NewTestModel(1)
+		Expect(backend.Create(&m)).ToNot(HaveOccurred())
+
+		tx := backend.MustBegin()
+		txModel := m
+		txModel.StrVal = "tx"
+		Expect(tx.Update(&txModel)).ToNot(HaveOccurred())
+
+		m.StrVal = "direct"
+		Expect(backend.Update(&m)).ToNot(HaveOccurred())
+
+		err := tx.Commit()
+		Expect(err).To(HaveOccurred())
+		Expect(err.GetCode()).To(Equal("transaction_conflict"))
+
+		dbModel, err := backend.FindOne("test_models", m.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dbModel.(*tests.TestModel).StrVal).To(Equal("direct"))
+	})
+})
+
+var _ = Describe("Memory concurrency", func() {
+	// Run with go test -race to detect unsynchronized access.
+
+	goroutines := 20
+	iterations := 25
+
+	var backend *Backend
+
+	BeforeEach(func() {
+		backend = New()
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+	})
+
+	// hammer runs fn concurrently and collects all errors.
+	hammer := func(fn func(routine, iteration int) error) []error {
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		errs := make([]error, 0)
+
+		for routine := 0; routine < goroutines; routine++ {
+			wg.Add(1)
+			go func(routine int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				for i := 0; i < iterations; i++ {
+					if err := fn(routine, i); err != nil {
+						lock.Lock()
+						errs = append(errs, err)
+						lock.Unlock()
+					}
+				}
+			}(routine)
+		}
+
+		wg.Wait()
+		return errs
+	}
+
+	It("Should create concurrently", func() {
+		errs := hammer(func(routine, i int) error {
+			m := tests.NewTestModel(routine*iterations + i)
+			return backend.Create(&m)
+		})
+		Expect(errs).To(BeEmpty())
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(goroutines * iterations))
+	})
+
+	It("Should handle concurrent reads and writes", func() {
+		errs := hammer(func(routine, i int) error {
+			m := tests.NewTestModel(routine*iterations + i)
+			if err := backend.Create(&m); err != nil {
+				return err
+			}
+
+			if _, err := backend.Q("test_models").Filter("int_val", m.IntVal).First(); err != nil {
+				return err
+			}
+			if _, err := backend.Q("test_models").Sort("int_val", true).Limit(10).Find(); err != nil {
+				return err
+			}
+
+			query := backend.Q("test_models").Filter("id", m.Id)
+			if err := backend.UpdateByMap(query, map[string]interface{}{"str_val": fmt.Sprintf("updated%v", m.Id)}); err != nil {
+				return err
+			}
+
+			if i%2 == 0 {
+				return backend.Delete(&m)
+			}
+			return nil
+		})
+		Expect(errs).To(BeEmpty())
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(goroutines * (iterations / 2)))
+	})
+
+	It("Should handle concurrent transactions", func() {
+		var committedLock sync.Mutex
+		committed := 0
+
+		errs := hammer(func(routine, i int) error {
+			tx, err := backend.Begin()
+			if err != nil {
+				return err
+			}
+
+			m := tests.NewTestModel(routine*iterations + i)
+			if err := tx.Create(&m); err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			if i%2 == 0 {
+				return tx.Rollback()
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+
+			committedLock.Lock()
+			committed++
+			committedLock.Unlock()
+			return nil
+		})
+		Expect(errs).To(BeEmpty())
+		Expect(committed).To(Equal(goroutines * (iterations / 2)))
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(committed))
+	})
+
+	It("Should retry conflicting concurrent transactions", func() {
+		counter := tests.NewTestModel(0)
+		Expect(backend.Create(&counter)).ToNot(HaveOccurred())
+
+		var attemptsLock sync.Mutex
+		attempts := 0
+
+		errs := hammer(func(routine, i int) error {
+			return db.WithTransactionRetry(backend, 1000, func(tx db.Transaction) error {
+				attemptsLock.Lock()
+				attempts++
+				attemptsLock.Unlock()
+
+				m, err := tx.FindOne("test_models", counter.Id)
+				if err != nil {
+					return err
+				}
+				// Give other transactions the chance to commit in between.
+				runtime.Gosched()
+
+				m.(*tests.TestModel).IntVal++
+				return tx.Update(m)
+			})
+		})
+		Expect(errs).To(BeEmpty())
+
+		// Every conflict on commit must have been retried.
+		retries := attempts - goroutines*iterations
+		Expect(retries).To(BeNumerically(">", 0))
+
+		m, err := backend.FindOne("test_models", counter.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.(*tests.TestModel).IntVal).To(Equal(int64(goroutines * iterations)))
+	})
+})
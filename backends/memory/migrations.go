@@ -15,15 +15,42 @@ func (b Backend) GetMigrationHandler() *db.MigrationHandler {
 }
 
 func (b Backend) MigrationsSetup() apperror.Error {
+	b.lock.RLock()
+	_, ok := b.data["migration_attempts"]
+	b.lock.RUnlock()
+
+	if !ok {
+		return b.CreateCollection("migration_attempts")
+	}
 	return nil
 }
 
 func (b Backend) IsMigrationLocked() (bool, apperror.Error) {
-	return false, nil
+	model, err := b.Q("migration_attempts").Last()
+	if err != nil {
+		return true, apperror.Wrap(err, "db_error")
+	}
+	if model == nil {
+		return false, nil
+	}
+
+	// If the last attempt was not finished, it was aborted. DB is locked.
+	return model.(*MigrationAttempt).FinishedAt.IsZero(), nil
 }
 
+// DetermineMigrationVersion returns the version of the last complete
+// migration attempt.
+// If no attempts exist, MigrationVersion is returned.
 func (b Backend) DetermineMigrationVersion() (int, apperror.Error) {
-	return b.MigrationVersion, nil
+	model, err := b.Q("migration_attempts").Filter("complete", true).Last()
+	if err != nil {
+		return -1, apperror.Wrap(err, "db_error")
+	}
+	if model == nil {
+		return b.MigrationVersion, nil
+	}
+
+	return model.(*MigrationAttempt).Version, nil
 }
 
 type MigrationAttempt struct {
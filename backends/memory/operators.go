@@ -0,0 +1,295 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Operators.
+ */
+
+// compare compares a value with a clause value.
+// It adds support for operators not handled by the reflector.
+func compare(r *reflector.Reflector, clauseValue interface{}, operator string) (bool, error) {
+	switch operator {
+	case OPERATOR_NULL:
+		return isNull(r.Value()), nil
+
+	case OPERATOR_NOT_NULL:
+		return !isNull(r.Value()), nil
+
+	case OPERATOR_NIN:
+		flag, err := r.CompareTo(clauseValue, OPERATOR_IN)
+		return !flag, err
+
+	case OPERATOR_NLIKE:
+		flag, err := r.CompareTo(clauseValue, OPERATOR_LIKE)
+		return !flag, err
+
+	case OPERATOR_ILIKE:
+		if isNull(r.Value()) {
+			return false, nil
+		}
+		return matchLike(fmt.Sprint(r.Interface()), fmt.Sprint(clauseValue), true)
+
+	case OPERATOR_REGEX:
+		if isNull(r.Value()) {
+			return false, nil
+		}
+		pattern, ok := clauseValue.(string)
+		if !ok {
+			return false, fmt.Errorf("Regex filters need a string pattern, got %T", clauseValue)
+		}
+		return regexp.MatchString(pattern, fmt.Sprint(r.Interface()))
+
+	case OPERATOR_SIZE:
+		val := r.Value()
+		if isNull(val) {
+			return false, nil
+		}
+		if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+			return false, fmt.Errorf("Size filters need an array field")
+		}
+		return reflector.R(val.Len()).CompareTo(clauseValue, OPERATOR_EQ)
+
+	case OPERATOR_BETWEEN:
+		bounds, err := reflector.R(clauseValue).Slice()
+		if err != nil || bounds.Len() != 2 {
+			return false, fmt.Errorf("Between filters need a slice with two values")
+		}
+		items := bounds.Items()
+		flag, err := r.CompareTo(items[0].Interface(), OPERATOR_GTE)
+		if err != nil || !flag {
+			return false, err
+		}
+		return r.CompareTo(items[1].Interface(), OPERATOR_LTE)
+	}
+
+	return r.CompareTo(clauseValue, operator)
+}
+
+// isNull returns true for invalid values and nil pointers, interfaces, maps
+// and slices.
+func isNull(val reflect.Value) bool {
+	if !val.IsValid() {
+		return true
+	}
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return val.IsNil()
+	}
+	return false
+}
+
+// matchLike matches a value against a SQL LIKE pattern, where % matches any
+// number of characters and _ matches a single character.
+// It is used for ILIKE, plain LIKE is handled by the reflector.
+func matchLike(value, pattern string, caseInsensitive bool) (bool, error) {
+	expr := ""
+	for _, char := range pattern {
+		switch char {
+		case '%':
+			expr += ".*"
+		case '_':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(char))
+		}
+	}
+
+	expr = "(?s)^" + expr + "$"
+	if caseInsensitive {
+		expr = "(?i)" + expr
+	}
+
+	return regexp.MatchString(expr, value)
+}
+
+// matchElement checks if an array element matches the filter of an
+// ElemMatchExpr.
+func matchElement(elem interface{}, filter Expression) (bool, error) {
+	switch f := filter.(type) {
+	case *AndExpr:
+		for _, expr := range f.Expressions() {
+			if flag, err := matchElement(elem, expr); err != nil || !flag {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case *OrExpr:
+		for _, expr := range f.Expressions() {
+			if flag, err := matchElement(elem, expr); err != nil || flag {
+				return flag, err
+			}
+		}
+		return false, nil
+
+	case *NotExpr:
+		flag, err := matchElement(elem, f.Not())
+		return !flag, err
+
+	case FilterExpression:
+		id, ok := f.Field().(*IdentifierExpr)
+		if !ok {
+			return false, fmt.Errorf("Element match filters only support identifiers")
+		}
+
+		var val interface{}
+		if id.Identifier() == ELEM_MATCH_SELF {
+			val = elem
+		} else {
+			r := reflector.R(elem)
+			if r.IsMap() {
+				mapVal := reflect.Indirect(reflect.ValueOf(elem)).MapIndex(reflect.ValueOf(id.Identifier()))
+				if mapVal.IsValid() {
+					val = mapVal.Interface()
+				}
+			} else {
+				s, err := r.Struct()
+				if err != nil {
+					return false, err
+				}
+				val = s.Field(id.Identifier()).Interface()
+			}
+		}
+
+		var clauseValue interface{}
+		if valExpr, ok := f.Clause().(*ValueExpr); ok {
+			clauseValue = valExpr.Value()
+		} else if f.Clause() != nil {
+			return false, fmt.Errorf("Element match filters only support value clauses")
+		}
+
+		return compare(reflector.R(val), clauseValue, f.Operator())
+	}
+
+	return false, fmt.Errorf("Unsupported element match filter %v", reflect.TypeOf(filter))
+}
+
+/**
+ * Value expressions.
+ */
+
+// evalValue evaluates an update value expression for an item.
+// Field references are resolved with the current values of the item.
+func evalValue(info *db.ModelInfo, item *reflector.Reflector, expr Expression) (interface{}, apperror.Error) {
+	switch e := expr.(type) {
+	case *ValueExpr:
+		return e.Value(), nil
+
+	case *NowExpr:
+		return time.Now(), nil
+
+	case *IdentifierExpr:
+		attr := info.FindAttribute(e.Identifier())
+		if attr == nil {
+			return nil, apperror.New("unknown_field",
+				fmt.Sprintf("The collection %v does not have a field %v", info.Collection(), e.Identifier()))
+		}
+		return itemValue(info, item, attr)
+
+	case *ColFieldIdentifierExpr:
+		return evalValue(info, item, NewIdExpr(e.Field()))
+
+	case *ArithmeticExpr:
+		left, err := evalValue(info, item, e.Left())
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalValue(info, item, e.Right())
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(left, right, e.Operator())
+	}
+
+	return nil, apperror.New("unsupported_field_value_expression",
+		fmt.Sprintf("The memory backend does not support value expressions of type %v", reflect.TypeOf(expr)))
+}
+
+// arithmetic applies an arithmetic operator to two numbers.
+// Integers result in an int64, all other numbers in a float64.
+func arithmetic(left, right interface{}, operator string) (interface{}, apperror.Error) {
+	l := reflect.Indirect(reflect.ValueOf(left))
+	r := reflect.Indirect(reflect.ValueOf(right))
+	if isNull(l) || isNull(r) {
+		// Like in SQL, arithmetic with NULL results in NULL.
+		return nil, nil
+	}
+
+	if isInteger(l) && isInteger(r) {
+		a, b := toInt64(l), toInt64(r)
+		switch operator {
+		case ARITHMETIC_ADD:
+			return a + b, nil
+		case ARITHMETIC_SUB:
+			return a - b, nil
+		case ARITHMETIC_MUL:
+			return a * b, nil
+		case ARITHMETIC_DIV:
+			if b == 0 {
+				return nil, apperror.New("division_by_zero")
+			}
+			return a / b, nil
+		}
+	} else if isNumber(l) && isNumber(r) {
+		a, b := toFloat64(l), toFloat64(r)
+		switch operator {
+		case ARITHMETIC_ADD:
+			return a + b, nil
+		case ARITHMETIC_SUB:
+			return a - b, nil
+		case ARITHMETIC_MUL:
+			return a * b, nil
+		case ARITHMETIC_DIV:
+			if b == 0 {
+				return nil, apperror.New("division_by_zero")
+			}
+			return a / b, nil
+		}
+	} else {
+		return nil, apperror.New("invalid_arithmetic_operand",
+			fmt.Sprintf("Arithmetic needs numbers, got %v and %v", l.Type(), r.Type()))
+	}
+
+	return nil, apperror.New("invalid_arithmetic_operator", fmt.Sprintf("Unknown arithmetic operator %v", operator))
+}
+
+func isInteger(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isNumber(v reflect.Value) bool {
+	return isInteger(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func toInt64(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return v.Int()
+}
+
+func toFloat64(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return float64(toInt64(v))
+}
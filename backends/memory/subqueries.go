@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Sub queries.
+ */
+
+// filterSubquery checks if the value of attr is in the result of a sub query.
+func (b *Backend) filterSubquery(info *db.ModelInfo, item *reflector.Reflector, attr *db.Attribute, operator string, stmt *SelectStmt) (bool, apperror.Error) {
+	if operator != OPERATOR_IN && operator != OPERATOR_NIN {
+		return false, apperror.New("unsupported_filter_clause", fmt.Sprintf("The memory backend only supports sub queries with the in and not in operators"))
+	}
+
+	values, err := b.subqueryValues(info, item, stmt)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := itemValue(info, item, attr)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for _, subVal := range values {
+		flag, err := reflector.R(val).CompareTo(subVal, OPERATOR_EQ)
+		if err != nil {
+			return false, apperror.Wrap(err, "compare_error")
+		}
+		if flag {
+			found = true
+			break
+		}
+	}
+
+	if operator == OPERATOR_NIN {
+		return !found, nil
+	}
+	return found, nil
+}
+
+// subqueryValues executes a sub query that selects a single field, and
+// returns the values of that field.
+func (b *Backend) subqueryValues(info *db.ModelInfo, item *reflector.Reflector, stmt *SelectStmt) ([]interface{}, apperror.Error) {
+	if len(stmt.Fields()) != 1 {
+		return nil, apperror.New("invalid_in_filter_subquery", "Sub queries for in filters must select exactly one field")
+	}
+
+	subInfo := b.ModelInfos().Find(stmt.Collection())
+	if subInfo == nil {
+		return nil, apperror.New("unknown_collection", fmt.Sprintf("Collection %v was not registered with backend", stmt.Collection()))
+	}
+
+	bound, err := b.bindOuterFields(info, item, stmt)
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.exec(bound)
+	if err != nil {
+		return nil, err
+	}
+
+	field := stmt.Fields()[0]
+	if stmt.IsAggregate() {
+		// Aggregate queries return maps.
+		sel, ok := field.(*FieldSelectorExpr)
+		if !ok {
+			return nil, apperror.New("invalid_in_filter_subquery", "Aggregate sub queries must select a named field")
+		}
+		values := make([]interface{}, 0)
+		for _, row := range result {
+			values = append(values, row.(map[string]interface{})[sel.Name()])
+		}
+		return values, nil
+	}
+
+	if sel, ok := field.(*FieldSelectorExpr); ok {
+		field = sel.Expression()
+	}
+	attr, err := b.aggregateAttribute(subInfo, field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0)
+	for _, subItem := range result {
+		val, err := itemValue(subInfo, reflector.R(subItem), attr)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// bindOuterFields returns a copy of a sub query where filter clauses that
+// reference a field of the outer collection are replaced with the value
+// of the current item.
+func (b *Backend) bindOuterFields(info *db.ModelInfo, item *reflector.Reflector, stmt *SelectStmt) (*SelectStmt, apperror.Error) {
+	if stmt.Filter() == nil {
+		return stmt, nil
+	}
+
+	filter, err := b.bindFilter(info, item, stmt.Collection(), stmt.Filter())
+	if err != nil {
+		return nil, err
+	}
+
+	bound := stmt.Copy()
+	bound.SetFilter(filter)
+	return bound, nil
+}
+
+func (b *Backend) bindFilter(info *db.ModelInfo, item *reflector.Reflector, collection string, filter Expression) (Expression, apperror.Error) {
+	switch f := filter.(type) {
+	case *AndExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			bound, err := b.bindFilter(info, item, collection, expr)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, bound)
+		}
+		return NewAndExpr(exprs...), nil
+
+	case *OrExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			bound, err := b.bindFilter(info, item, collection, expr)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, bound)
+		}
+		return NewOrExpr(exprs...), nil
+
+	case *NotExpr:
+		bound, err := b.bindFilter(info, item, collection, f.Not())
+		if err != nil {
+			return nil, err
+		}
+		return NewNotExpr(bound), nil
+
+	case FilterExpression:
+		id, ok := f.Clause().(*ColFieldIdentifierExpr)
+		if !ok || id.Collection() == "" || id.Collection() == collection {
+			return filter, nil
+		}
+		if id.Collection() != info.Collection() && id.Collection() != info.BackendName() {
+			return nil, apperror.New("unsupported_filter", "The memory backend only supports sub queries that reference the parent collection")
+		}
+
+		attr := info.FindAttribute(id.Field())
+		if attr == nil {
+			return nil, apperror.New("invalid_filter", fmt.Sprintf("Invalid filter for inexistant field %v", id.Field()))
+		}
+		val, err := itemValue(info, item, attr)
+		if err != nil {
+			return nil, err
+		}
+		return NewFilter(f.Field(), f.Operator(), NewValueExpr(val)), nil
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,259 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/theduke/go-apperror"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Transactions.
+ */
+
+// dataState holds the id counters and the change tracking of the data of
+// a backend.
+type dataState struct {
+	// ids holds the last allocated id per collection.
+	ids map[string]int
+
+	// seq is incremented for each change of an item.
+	seq int64
+	// changed holds the seq of the last change per collection and id.
+	changed map[string]map[string]int64
+}
+
+func newDataState() *dataState {
+	return &dataState{
+		ids:     make(map[string]int),
+		changed: make(map[string]map[string]int64),
+	}
+}
+
+// recordChange remembers the change of an item.
+func (s *dataState) recordChange(collection, id string) {
+	s.seq++
+	if _, ok := s.changed[collection]; !ok {
+		s.changed[collection] = make(map[string]int64)
+	}
+	s.changed[collection][id] = s.seq
+}
+
+// transaction holds the state of a copy-on-write transaction.
+//
+// Begin() snapshots the data of the parent backend. All writes inside the
+// transaction only modify the snapshot, so other users of the parent backend
+// only see committed data. Commit() applies the recorded changes to the
+// parent backend.
+type transaction struct {
+	// parent is the backend that started the transaction.
+	parent *Backend
+
+	// schema holds all collection statements executed in the transaction,
+	// in order.
+	schema []Expression
+
+	// changed holds the ids of all created, updated and deleted items
+	// per collection.
+	changed map[string]map[string]bool
+
+	// startSeq is the change seq of the parent when the transaction started.
+	// Items the parent changed later conflict with the transaction.
+	startSeq int64
+
+	finished bool
+}
+
+func (b *Backend) Begin() (db.Transaction, apperror.Error) {
+	if b.tx != nil {
+		panic("Can't call .Begin() on a transaction.")
+	}
+
+	copied := b.Clone().(*Backend)
+	b.lock.RLock()
+	copied.data = copyData(b.data)
+	startSeq := b.state.seq
+	b.lock.RUnlock()
+	copied.lock = &sync.RWMutex{}
+	copied.tx = &transaction{
+		parent:   b,
+		changed:  make(map[string]map[string]bool),
+		startSeq: startSeq,
+	}
+
+	return copied, nil
+}
+
+func (b *Backend) MustBegin() db.Transaction {
+	tx, err := b.Begin()
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// IsTransaction returns true if the backend is a transaction.
+func (b *Backend) IsTransaction() bool {
+	return b.tx != nil
+}
+
+// Rollback discards all changes made in the transaction.
+func (b *Backend) Rollback() apperror.Error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.finishTransaction()
+}
+
+// Commit applies all changes made in the transaction to the parent backend.
+// If the parent changed an item after the transaction started that was
+// changed in the transaction as well, a transaction_conflict error is
+// returned and nothing is applied.
+func (b *Backend) Commit() apperror.Error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.finishTransaction(); err != nil {
+		return err
+	}
+
+	parent := b.tx.parent
+	parent.lock.Lock()
+	defer parent.lock.Unlock()
+
+	// Check for write conflicts before anything is applied.
+	for collection, ids := range b.tx.changed {
+		for id := range ids {
+			if parent.state.changed[collection][id] > b.tx.startSeq {
+				return &apperror.Err{
+					Code:    "transaction_conflict",
+					Message: fmt.Sprintf("The item %v of %v was changed after the transaction started", id, collection),
+					Public:  true,
+				}
+			}
+		}
+	}
+
+	// Replay collection statements first, so that all collections exist.
+	for _, stmt := range b.tx.schema {
+		if _, err := parent.exec(stmt); err != nil {
+			return apperror.Wrap(err, "transaction_commit_failed")
+		}
+	}
+
+	for collection, ids := range b.tx.changed {
+		items, ok := b.data[collection]
+		if !ok {
+			// Collection was dropped or renamed later in the transaction.
+			continue
+		}
+		if _, ok := parent.data[collection]; !ok {
+			parent.data[collection] = make(map[string]interface{})
+		}
+
+		for id := range ids {
+			if item, ok := items[id]; ok {
+				parent.data[collection][id] = item
+			} else {
+				delete(parent.data[collection], id)
+			}
+			parent.state.recordChange(collection, id)
+		}
+	}
+
+	return nil
+}
+
+// IsRetryableError returns true if the error was caused by a conflict
+// detected on commit.
+func (b *Backend) IsRetryableError(err error) bool {
+	appErr, ok := err.(apperror.Error)
+	return ok && appErr.GetCode() == "transaction_conflict"
+}
+
+// finishTransaction marks the transaction as finished, and returns an error
+// if the backend is not a transaction or the transaction already finished.
+func (b *Backend) finishTransaction() apperror.Error {
+	if b.tx == nil {
+		return apperror.New("no_transaction", "The backend is not a transaction")
+	}
+	if b.tx.finished {
+		return apperror.New("transaction_finished", "The transaction was already committed or rolled back")
+	}
+	b.tx.finished = true
+	return nil
+}
+
+// recordChange remembers a created, updated or deleted item.
+// Transactions record it for the commit, other backends for the detection
+// of conflicts with transactions.
+func (b *Backend) recordChange(collection, id string) {
+	if b.tx == nil {
+		b.state.recordChange(collection, id)
+		return
+	}
+	if _, ok := b.tx.changed[collection]; !ok {
+		b.tx.changed[collection] = make(map[string]bool)
+	}
+	b.tx.changed[collection][id] = true
+}
+
+// recordSchemaChange remembers a collection statement if the backend
+// is a transaction.
+func (b *Backend) recordSchemaChange(stmt Expression) {
+	if b.tx == nil {
+		return
+	}
+	b.tx.schema = append(b.tx.schema, stmt)
+
+	if rename, ok := stmt.(*RenameCollectionStmt); ok {
+		// Changes to the renamed collection must be applied to the new name.
+		if ids, ok := b.tx.changed[rename.Collection()]; ok {
+			b.tx.changed[rename.NewName()] = ids
+			delete(b.tx.changed, rename.Collection())
+		}
+	}
+}
+
+// copyData returns a copy of the collection maps.
+// The items themselves are not copied.
+func copyData(data map[string]map[string]interface{}) map[string]map[string]interface{} {
+	copied := make(map[string]map[string]interface{}, len(data))
+	for collection, items := range data {
+		copiedItems := make(map[string]interface{}, len(items))
+		for id, item := range items {
+			copiedItems[id] = item
+		}
+		copied[collection] = copiedItems
+	}
+	return copied
+}
+
+// copyItem returns a shallow copy of a struct pointer or a map.
+// Other values are returned unchanged.
+func copyItem(item interface{}) interface{} {
+	val := reflect.ValueOf(item)
+
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() || val.Elem().Kind() != reflect.Struct {
+			return item
+		}
+		copied := reflect.New(val.Elem().Type())
+		copied.Elem().Set(val.Elem())
+		return copied.Interface()
+
+	case reflect.Map:
+		copied := reflect.MakeMap(val.Type())
+		for _, key := range val.MapKeys() {
+			copied.SetMapIndex(key, val.MapIndex(key))
+		}
+		return copied.Interface()
+	}
+
+	return item
+}
@@ -146,12 +146,20 @@ func (b *Backend) SqlExec(query string, args ...interface{}) orient.Results {
 }
 
 func (b *Backend) Exec(statement Expression) apperror.Error {
+	_, err := b.ExecAffected(statement)
+	return err
+}
+
+// ExecAffected executes a statement like Exec and returns the number of
+// affected records, or -1 if the number is not known.
+// OrientDB returns the count of affected records for UPDATE and DELETE.
+func (b *Backend) ExecAffected(statement Expression) (int, apperror.Error) {
 	translator := b.translator.New()
 	if err := translator.PrepareExpression(statement); err != nil {
-		return err
+		return -1, err
 	}
 	if err := translator.Translate(statement); err != nil {
-		return err
+		return -1, err
 	}
 
 	sql := translator.String()
@@ -159,13 +167,23 @@ func (b *Backend) Exec(statement Expression) apperror.Error {
 
 	res := b.SqlExec(sql, args...)
 	if res.Err() != nil {
-		return apperror.Wrap(res.Err(), "orient_error")
+		return -1, apperror.Wrap(res.Err(), "orient_error")
 	}
+
+	affected := -1
+	switch statement.(type) {
+	case *UpdateStmt, *DeleteStmt:
+		var count int
+		if err := res.All(&count); err == nil {
+			affected = count
+		}
+	}
+
 	if err := res.Close(); err != nil {
-		return apperror.Wrap(err, "orient_close_result_error")
+		return -1, apperror.Wrap(err, "orient_close_result_error")
 	}
 
-	return nil
+	return affected, nil
 }
 
 func (b *Backend) ExecQuery(statement FieldedExpression) ([]interface{}, apperror.Error) {
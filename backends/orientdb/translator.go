@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/theduke/go-apperror"
 	"github.com/theduke/go-reflector"
@@ -45,6 +46,13 @@ type OrientTranslator struct {
 
 	backend   *Backend
 	modelInfo db.ModelInfos
+
+	// collections holds the collections of the select statements that are
+	// being translated, the innermost last.
+	collections []string
+	// exists maps the EXISTS filters of the translated select statements to
+	// the LET variables holding their result.
+	exists map[*ExistsExpr]string
 }
 
 func NewTranslator(b *Backend) *OrientTranslator {
@@ -117,6 +125,151 @@ func (OrientTranslator) DetermineColumnType(attr *db.Attribute) (string, apperro
 		fmt.Sprintf("Field %v has unsupported type %v (orientdb)", attr.Name(), attr.Type()))
 }
 
+// collectExists returns the EXISTS filters contained in a filter expression.
+// EXISTS filters of sub queries are not included.
+func collectExists(expr Expression) []*ExistsExpr {
+	switch e := expr.(type) {
+	case *ExistsExpr:
+		return []*ExistsExpr{e}
+	case *NotExpr:
+		return collectExists(e.Not())
+	case MultiExpression:
+		exists := make([]*ExistsExpr, 0)
+		for _, nested := range e.Expressions() {
+			exists = append(exists, collectExists(nested)...)
+		}
+		return exists
+	}
+	return nil
+}
+
+// translateExistsLets writes a LET clause for the EXISTS filters of a select
+// statement, since OrientDB has no EXISTS operator.
+// The filters then check the size of the LET variables.
+func (t *OrientTranslator) translateExistsLets(filter Expression) apperror.Error {
+	exists := collectExists(filter)
+	if len(exists) == 0 {
+		return nil
+	}
+	if t.exists == nil {
+		t.exists = make(map[*ExistsExpr]string)
+	}
+
+	t.W(" LET ")
+	for i, e := range exists {
+		name := fmt.Sprintf("$exists%v", len(t.exists)+1)
+		t.exists[e] = name
+
+		if i > 0 {
+			t.W(", ")
+		}
+		t.W(name, " = ")
+		// Make sure the sub query is wrapped in parantheses.
+		t.TranslationCounter++
+		if err := t.Translate(e.SelectStmt()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *OrientTranslator) translateFilter(e FilterExpression) apperror.Error {
+	switch e.Operator() {
+	case OPERATOR_NLIKE:
+		// OrientDB has no NOT LIKE.
+		t.W("NOT (")
+		if err := t.translateFilter(NewFilter(e.Field(), OPERATOR_LIKE, e.Clause())); err != nil {
+			return err
+		}
+		t.W(")")
+		return nil
+
+	case OPERATOR_ILIKE:
+		// Compare lower case values.
+		if err := t.Translate(e.Field()); err != nil {
+			return err
+		}
+		t.W(".toLowerCase() LIKE ")
+		clause := e.Clause()
+		if val, ok := clause.(*ValueExpr); ok {
+			if str, ok := val.Value().(string); ok {
+				clause = NewValueExpr(strings.ToLower(str))
+			}
+		}
+		return t.Translate(clause)
+	}
+
+	if err := t.Translate(e.Field()); err != nil {
+		return err
+	}
+
+	switch e.Operator() {
+	case OPERATOR_NULL, OPERATOR_NOT_NULL:
+		t.W(" ", strings.ToUpper(e.Operator()))
+		return nil
+
+	case OPERATOR_SIZE:
+		t.W(".size() = ")
+		return t.Translate(e.Clause())
+
+	case OPERATOR_REGEX:
+		t.W(" MATCHES ")
+		return t.Translate(e.Clause())
+
+	case OPERATOR_BETWEEN:
+		values, err := BetweenValues(e.Clause())
+		if err != nil {
+			return err
+		}
+		t.W(" BETWEEN ")
+		if err := t.Translate(values[0]); err != nil {
+			return err
+		}
+		t.W(" AND ")
+		return t.Translate(values[1])
+	}
+
+	t.W(" ", e.Operator(), " ")
+
+	if stmt, ok := e.Clause().(*SelectStmt); ok && (e.Operator() == OPERATOR_IN || e.Operator() == OPERATOR_NIN) {
+		// Sub query.
+		if len(stmt.Fields()) != 1 {
+			return apperror.New("invalid_in_filter_subquery", "Sub queries for IN filters must select exactly one field")
+		}
+		return t.Translate(stmt)
+	} else if e.Operator() != OPERATOR_IN && e.Operator() != OPERATOR_NIN {
+		return t.Translate(e.Clause())
+	}
+
+	val, ok := e.Clause().(*ValueExpr)
+	if !ok {
+		return apperror.New("invalid_in_filter")
+	}
+
+	r, err := reflector.Reflect(val.Value()).Slice()
+	if err != nil {
+		return apperror.New("invalid_in_filter_value")
+	}
+
+	if r.Len() < 1 {
+		return apperror.New("invalid_in_filter_no_values")
+	}
+
+	t.W("[")
+
+	lastIndex := r.Len() - 1
+	for i, item := range r.Items() {
+		t.W(t.Placeholder())
+		if i < lastIndex {
+			t.W(",")
+		}
+
+		t.Arg(NewValueExpr(item.Interface(), item.Type()))
+	}
+	t.W("]")
+	return nil
+}
+
 func (t *OrientTranslator) Translate(expression Expression) apperror.Error {
 	if validator, ok := expression.(ValidatableExpression); ok {
 		if err := validator.Validate(); err != nil {
@@ -135,44 +288,55 @@ func (t *OrientTranslator) Translate(expression Expression) apperror.Error {
 		return nil
 
 	case FilterExpression:
+		return t.translateFilter(e)
+
+	case *IdentifierExpr:
+		if e.Identifier() == ELEM_MATCH_SELF {
+			// Element of an array in an element match filter.
+			t.W("@this")
+			return nil
+		}
+
+	case *ElemMatchExpr:
 		if err := t.Translate(e.Field()); err != nil {
 			return err
 		}
-		t.W(" ", e.Operator(), " ")
-
-		if e.Operator() != OPERATOR_IN {
-			if err := t.Translate(e.Clause()); err != nil {
-				return err
-			}
-		} else {
-			val, ok := e.Clause().(*ValueExpr)
-			if !ok {
-				return apperror.New("invalid_in_filter")
-			}
+		t.W(" CONTAINS (")
+		if err := t.Translate(e.Filter()); err != nil {
+			return err
+		}
+		t.W(")")
+		return nil
 
-			r, err := reflector.Reflect(val.Value()).Slice()
-			if err != nil {
-				return apperror.New("invalid_in_filter_value")
-			}
+	case *ExistsExpr:
+		// OrientDB has no EXISTS operator, so the sub query is assigned to a
+		// LET variable of the select statement by translateExistsLets.
+		name := t.exists[e]
+		if name == "" {
+			return apperror.New("unsupported_expression", "OrientDB only supports EXISTS sub queries in select statements", true)
+		}
+		t.W(name, ".size() > 0")
+		return nil
 
-			if r.Len() < 1 {
-				return apperror.New("invalid_in_filter_no_values")
+	case *ColFieldIdentifierExpr:
+		// In sub queries, fields of the outer collections are referenced
+		// through $parent.
+		if depth := len(t.collections); depth > 1 {
+			if e.Collection() == t.collections[depth-1] {
+				t.WQ(e.Field())
+				return nil
 			}
-
-			t.W("[")
-
-			lastIndex := r.Len() - 1
-			for i, item := range r.Items() {
-				t.W(t.Placeholder())
-				if i < lastIndex {
-					t.W(",")
+			for i := depth - 2; i >= 0; i-- {
+				if e.Collection() == t.collections[i] {
+					t.W(strings.Repeat("$parent.", depth-1-i), "$current.")
+					t.WQ(e.Field())
+					return nil
 				}
-
-				t.Arg(NewValueExpr(item.Interface(), item.Type()))
 			}
-			t.W("]")
 		}
-		return nil
+
+	case *UpsertStmt:
+		return apperror.New("unsupported_expression", "Upserts are not supported by the OrientDB backend", true)
 
 	case *CreateCollectionStmt:
 		t.W("CREATE CLASS ")
@@ -263,6 +427,12 @@ func (t *OrientTranslator) Translate(expression Expression) apperror.Error {
 			t.W("(")
 		}
 
+		// OrientDB has no HAVING clause, so the grouped query is used
+		// as a subquery that is filtered by the having expression.
+		if e.Having() != nil {
+			t.W("SELECT FROM (")
+		}
+
 		t.W("SELECT ")
 
 		// Field expressions.
@@ -297,13 +467,42 @@ func (t *OrientTranslator) Translate(expression Expression) apperror.Error {
 		t.W(" FROM ")
 		t.WQ(e.Collection())
 
+		t.collections = append(t.collections, e.Collection())
+		defer func() {
+			t.collections = t.collections[:len(t.collections)-1]
+		}()
+
 		if e.Filter() != nil {
+			if err := t.translateExistsLets(e.Filter()); err != nil {
+				return err
+			}
+
 			t.W(" WHERE ")
 			if err := t.Translate(e.Filter()); err != nil {
 				return err
 			}
 		}
 
+		if len(e.GroupBy()) > 0 {
+			t.W(" GROUP BY ")
+			lastIndex := len(e.GroupBy()) - 1
+			for i, expr := range e.GroupBy() {
+				if err := t.Translate(expr); err != nil {
+					return err
+				}
+				if i < lastIndex {
+					t.W(", ")
+				}
+			}
+		}
+
+		if e.Having() != nil {
+			t.W(") WHERE ")
+			if err := t.Translate(e.Having()); err != nil {
+				return err
+			}
+		}
+
 		if len(e.Sorts()) > 0 {
 			t.W(" ORDER BY ")
 			lastIndex := len(e.Sorts()) - 1
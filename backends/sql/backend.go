@@ -2,7 +2,8 @@ package sql
 
 import (
 	"database/sql"
-	"reflect"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -20,12 +21,20 @@ type Backend struct {
 	Db *sql.DB
 	Tx *sql.Tx
 
+	// savepoint is the name of the savepoint if the backend is a nested
+	// transaction.
+	savepoint string
+	// savepointCount is shared by all nested transactions of a transaction,
+	// and is used to generate unique savepoint names.
+	savepointCount *int
+
 	migrationHandler *db.MigrationHandler
 }
 
 // Ensure Backend implements dukedb.Backend.
 var _ db.Backend = (*Backend)(nil)
 var _ db.TransactionBackend = (*Backend)(nil)
+var _ db.RetryableErrorBackend = (*Backend)(nil)
 var _ db.MigrationBackend = (*Backend)(nil)
 
 func New(driver, driverOptions string) (*Backend, apperror.Error) {
@@ -37,9 +46,17 @@ func New(driver, driverOptions string) (*Backend, apperror.Error) {
 	case "postgres":
 		b.dialect = NewPostgresDialect(b)
 	case "mysql":
-		b.dialect = &MysqlDialect{}
+		b.dialect = NewMysqlDialect(b)
 	case "sqlite3":
-		b.dialect = &SqliteDialect{}
+		b.dialect = NewSqliteDialect(b)
+		// SQLite only enforces foreign keys if enabled on each connection.
+		if !strings.Contains(driverOptions, "_foreign_keys=") {
+			if strings.Contains(driverOptions, "?") {
+				driverOptions += "&_foreign_keys=1"
+			} else {
+				driverOptions += "?_foreign_keys=1"
+			}
+		}
 	default:
 		panic("Unsupported sql driver: " + driver)
 	}
@@ -49,6 +66,13 @@ func New(driver, driverOptions string) (*Backend, apperror.Error) {
 		return nil, apperror.Wrap(err, "sql_connection_error")
 	}
 
+	if driver == "sqlite3" {
+		// Connections are opened with the functions the dialect relies on.
+		connector := &sqliteConnector{driver: DB.Driver(), dsn: driverOptions}
+		DB.Close()
+		DB = sql.OpenDB(connector)
+	}
+
 	b.Db = DB
 
 	b.migrationHandler = db.NewMigrationHandler(b)
@@ -81,23 +105,33 @@ func (b *Backend) DisableSqlProfiling() {
 
 func (b *Backend) Clone() db.Backend {
 	base := b.BaseBackend.Clone()
-	return &Backend{
+	copied := &Backend{
 		BaseBackend:         *base,
 		dialect:             b.dialect,
 		Db:                  b.Db,
 		Tx:                  b.Tx,
+		savepoint:           b.savepoint,
+		savepointCount:      b.savepointCount,
 		migrationHandler:    b.migrationHandler,
 		sqlProfilingEnabled: b.sqlProfilingEnabled,
 	}
+	copied.BaseBackend.SetBackend(copied)
+
+	return copied
 }
 
 /**
  * Transactions.
  */
 
+// Begin starts a new transaction.
+// If the backend already is a transaction, a nested transaction is started
+// by creating a savepoint. Rollback() and Commit() of the nested transaction
+// then roll back to and release the savepoint, without affecting the outer
+// transaction.
 func (b *Backend) Begin() (db.Transaction, apperror.Error) {
 	if b.Tx != nil {
-		panic("Can't call .Begin() on a transaction.")
+		return b.beginSavepoint()
 	}
 
 	copied := b.Clone().(*Backend)
@@ -108,10 +142,37 @@ func (b *Backend) Begin() (db.Transaction, apperror.Error) {
 
 	copied.Tx = tx
 	copied.Db = nil
+	copied.savepoint = ""
+	copied.savepointCount = new(int)
 
 	return copied, nil
 }
 
+func (b *Backend) beginSavepoint() (db.Transaction, apperror.Error) {
+	*b.savepointCount++
+	name := fmt.Sprintf("dukedb_savepoint_%v", *b.savepointCount)
+
+	if _, err := b.SqlExec("SAVEPOINT " + name); err != nil {
+		return nil, apperror.Wrap(err, "begin_transaction_failed")
+	}
+
+	copied := b.Clone().(*Backend)
+	copied.savepoint = name
+
+	return copied, nil
+}
+
+// IsTransaction returns true if the backend is a transaction.
+func (b *Backend) IsTransaction() bool {
+	return b.Tx != nil
+}
+
+// IsNestedTransaction returns true if the backend is a transaction started
+// inside another transaction.
+func (b *Backend) IsNestedTransaction() bool {
+	return b.savepoint != ""
+}
+
 func (b *Backend) MustBegin() db.Transaction {
 	tx, err := b.Begin()
 	if err != nil {
@@ -120,7 +181,24 @@ func (b *Backend) MustBegin() db.Transaction {
 	return tx
 }
 
+// IsRetryableError returns true if the error was caused by a serialization
+// failure or a deadlock, as detected by the dialect.
+func (b *Backend) IsRetryableError(err error) bool {
+	return b.dialect.IsRetryableError(err)
+}
+
 func (b *Backend) Rollback() apperror.Error {
+	if b.savepoint != "" {
+		if _, err := b.SqlExec("ROLLBACK TO SAVEPOINT " + b.savepoint); err != nil {
+			return apperror.Wrap(err, "transaction_rollback_failed")
+		}
+		// The savepoint still exists after a rollback, so release it.
+		if _, err := b.SqlExec("RELEASE SAVEPOINT " + b.savepoint); err != nil {
+			return apperror.Wrap(err, "transaction_rollback_failed")
+		}
+		return nil
+	}
+
 	if err := b.Tx.Rollback(); err != nil {
 		return apperror.Wrap(err, "transaction_rollback_failed")
 	}
@@ -128,6 +206,13 @@ func (b *Backend) Rollback() apperror.Error {
 }
 
 func (b *Backend) Commit() apperror.Error {
+	if b.savepoint != "" {
+		if _, err := b.SqlExec("RELEASE SAVEPOINT " + b.savepoint); err != nil {
+			return apperror.Wrap(err, "transaction_commit_failed")
+		}
+		return nil
+	}
+
 	if err := b.Tx.Commit(); err != nil {
 		return apperror.Wrap(err, "transaction_commit_failed")
 	}
@@ -211,26 +296,161 @@ func (b *Backend) SqlQuery(query string, args ...interface{}) (*sql.Rows, error)
 }
 
 func (b *Backend) Exec(statement Expression) apperror.Error {
+	if create, ok := statement.(*CreateCollectionStmt); ok && !b.dialect.SupportsForwardReferences() {
+		return b.execCreateCollection(create)
+	}
+
+	return b.exec(statement)
+}
+
+func (b *Backend) exec(statement Expression) apperror.Error {
+	_, err := b.execAffected(statement)
+	return err
+}
+
+// ExecAffected executes a statement like Exec and returns the number of
+// affected rows, or -1 if the number is not known.
+func (b *Backend) ExecAffected(statement Expression) (int, apperror.Error) {
+	if create, ok := statement.(*CreateCollectionStmt); ok && !b.dialect.SupportsForwardReferences() {
+		return -1, b.execCreateCollection(create)
+	}
+
+	return b.execAffected(statement)
+}
+
+func (b *Backend) execAffected(statement Expression) (int, apperror.Error) {
+	if handled, err := b.dialect.ExecStatement(b, statement); err != nil {
+		return -1, err
+	} else if handled {
+		return -1, nil
+	}
+
 	dialect := b.dialect.New()
 	if err := dialect.PrepareExpression(statement); err != nil {
-		return err
+		return -1, err
 	}
 	if err := dialect.Translate(statement); err != nil {
-		return err
+		return -1, err
 	}
 
 	sql := dialect.String()
 	args := dialect.RawArguments()
 
-	_, err := b.SqlExec(sql, args...)
+	res, err := b.SqlExec(sql, args...)
 	if err != nil {
-		return apperror.Wrap(err, "sql_error")
+		return -1, apperror.Wrap(err, "sql_error")
 	}
 
-	return nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		// Not all drivers support RowsAffected().
+		return -1, nil
+	}
+
+	return int(affected), nil
 }
 
 func (b *Backend) ExecQuery(statement FieldedExpression) ([]interface{}, apperror.Error) {
+	if create, ok := statement.(*CreateStmt); ok && !b.dialect.SupportsReturning() {
+		return b.execInsert(create)
+	}
+	if _, ok := statement.(*UpsertStmt); ok && !b.dialect.SupportsReturning() {
+		// The id of an updated row can not be determined with LastInsertId(),
+		// so no data is returned.
+		return nil, b.exec(statement)
+	}
+
+	iter, err := b.ExecQueryIterator(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	result := make([]interface{}, 0)
+	for {
+		row, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		result = append(result, row)
+	}
+
+	return result, nil
+}
+
+// execInsert executes a CreateStmt for dialects that do not support
+// returning data from an INSERT.
+// If the collection has an auto incrementing primary key, its values are
+// determined with LastInsertId() and returned as the result rows.
+// For multi-row inserts, the ids are assumed to be consecutive, starting at
+// the id returned by Dialect.FirstInsertId().
+func (b *Backend) execInsert(statement *CreateStmt) ([]interface{}, apperror.Error) {
+	dialect := b.dialect.New()
+	if err := dialect.PrepareExpression(statement); err != nil {
+		return nil, err
+	}
+	if err := dialect.Translate(statement); err != nil {
+		return nil, err
+	}
+
+	res, err := b.SqlExec(dialect.String(), dialect.RawArguments()...)
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
+	}
+
+	info := b.ModelInfos().Find(statement.Collection())
+	if info == nil {
+		return nil, nil
+	}
+	pk := info.PkAttribute()
+	if pk == nil || !pk.AutoIncrement() {
+		return nil, nil
+	}
+	if statement.IsMultiRow() && statement.RowValue(statement.Values(), NewIdExpr(pk.BackendName())) != nil {
+		// The ids were set explicitly.
+		return nil, nil
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_last_insert_id_error")
+	}
+
+	rowCount := len(statement.Rows())
+	if rowCount == 1 {
+		return []interface{}{map[string]interface{}{pk.Name(): id}}, nil
+	}
+
+	firstId := b.dialect.FirstInsertId(id, rowCount)
+	result := make([]interface{}, rowCount)
+	for i := range result {
+		result[i] = map[string]interface{}{
+			pk.Name(): firstId + int64(i),
+		}
+	}
+	return result, nil
+}
+
+// CreateBatchSize implements db.BatchCreateBackend.
+// Batches are limited by the maximum number of query parameters of the
+// dialect. For dialects that can not return data from an INSERT, the
+// generated ids are determined by execInsert.
+func (b *Backend) CreateBatchSize(info *db.ModelInfo, fieldCount int) int {
+	if fieldCount < 1 {
+		return 1
+	}
+	return b.dialect.MaxParameters() / fieldCount
+}
+
+// ExecQueryIterator executes a select statement and returns an iterator
+// that scans the result rows one by one.
+// Note that the iterator holds a connection until it is closed.
+// When a transaction is used, no other queries can be executed
+// in the transaction while the iterator is open.
+func (b *Backend) ExecQueryIterator(statement FieldedExpression) (db.RowIterator, apperror.Error) {
 	dialect := b.dialect.New()
 	if err := dialect.PrepareExpression(statement); err != nil {
 		return nil, err
@@ -242,67 +462,148 @@ func (b *Backend) ExecQuery(statement FieldedExpression) ([]interface{}, apperro
 	sql := dialect.String()
 	args := dialect.RawArguments()
 
-	rows, err2 := b.SqlQuery(sql, args...)
-	if err2 != nil {
-		return nil, apperror.Wrap(err2, "sql_error")
+	rows, err := b.SqlQuery(sql, args...)
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
 	}
-	defer rows.Close()
 
 	cols, err := rows.Columns()
 	if err != nil {
+		rows.Close()
 		return nil, apperror.Wrap(err, "sql_rows_error")
 	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, apperror.Wrap(err, "sql_rows_error")
+	}
+
+	return &rowIterator{rows: rows, cols: cols, colTypes: colTypes, dialect: b.dialect}, nil
+}
+
+/**
+ * rowIterator.
+ */
+
+// rowIterator implements db.RowIterator for sql rows.
+// Each row is returned as a map[string]interface{}.
+type rowIterator struct {
+	rows     *sql.Rows
+	cols     []string
+	colTypes []*sql.ColumnType
+	dialect  Dialect
+	closed   bool
+}
 
-	colMap := make(map[string]int)
-	for i, col := range cols {
-		colMap[col] = i
+func (i *rowIterator) Next() (interface{}, apperror.Error) {
+	if i.closed {
+		return nil, nil
 	}
 
-	fieldMap := make(map[string]reflect.Type)
-	for _, field := range statement.Fields() {
-		continue
-		if e, ok := field.(NamedTypedExpression); ok && e.Type() != nil && e.Name() != "" {
-			if _, ok := colMap[e.Name()]; ok {
-				fieldMap[e.Name()] = e.Type()
-			}
+	if !i.rows.Next() {
+		err := i.rows.Err()
+		i.Close()
+		if err != nil {
+			return nil, apperror.Wrap(err, "sql_rows_error")
 		}
+		return nil, nil
 	}
 
-	result := make([]interface{}, 0)
-	for rows.Next() {
-		//values := make([]reflect.Value, len(cols), len(cols))
-		values := make([]interface{}, len(cols), len(cols))
-		pointers := make([]interface{}, len(cols), len(cols))
-
-		for i, col := range cols {
-			if typ, ok := fieldMap[col]; ok {
-				r := reflect.New(typ)
-				values[i] = r
-				pointers[i] = r.Interface()
-			} else {
-				//r := reflect.ValueOf(pointers[i]).Addr()
-				//pointers[i] = r.Interface()
-				pointers[i] = &values[i]
+	values := make([]interface{}, len(i.cols), len(i.cols))
+	pointers := make([]interface{}, len(i.cols), len(i.cols))
+	for index := range i.cols {
+		pointers[index] = &values[index]
+	}
+
+	if err := i.rows.Scan(pointers...); err != nil {
+		return nil, apperror.Wrap(err, "sql_scan_error")
+	}
+
+	m := make(map[string]interface{})
+	for index, col := range i.cols {
+		m[col] = i.dialect.ConvertScannedValue(values[index], i.colTypes[index])
+	}
+
+	return m, nil
+}
+
+func (i *rowIterator) Close() apperror.Error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	if err := i.rows.Close(); err != nil {
+		return apperror.Wrap(err, "sql_rows_close_error")
+	}
+	return nil
+}
+
+// execCreateCollection creates a collection for dialects that do not support
+// foreign keys to collections that do not exist yet.
+//
+// Foreign keys to missing collections are left out. They are added once the
+// referenced collection is created, which allows to create collections in
+// any order.
+func (b *Backend) execCreateCollection(stmt *CreateCollectionStmt) apperror.Error {
+	collection := stmt.Collection()
+
+	constraints := make([]Expression, 0)
+	for _, constraint := range stmt.Constraints() {
+		if fk, ok := constraint.(*ForeignKeyConstraint); ok {
+			refCollection := fk.Reference().ForeignKey().Collection()
+			if refCollection != collection {
+				exists, err := b.dialect.CollectionExists(b, refCollection)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					continue
+				}
 			}
 		}
+		constraints = append(constraints, constraint)
+	}
 
-		if err := rows.Scan(pointers...); err != nil {
-			return nil, apperror.Wrap(err, "sql_scan_error")
-		}
+	created := NewCreateColStmt(collection, stmt.IfNotExists(), stmt.Fields(), constraints)
+	if err := b.exec(created); err != nil {
+		return err
+	}
 
-		m := make(map[string]interface{})
-		for i, col := range cols {
-			//m[col] = values[i].Elem().Interface()
-			m[col] = values[i]
+	// Add missing foreign keys of other collections that reference the
+	// new collection.
+	for _, info := range b.ModelInfos() {
+		if info.BackendName() == collection {
+			continue
 		}
 
-		result = append(result, m)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, apperror.Wrap(err, "sql_rows_error")
+		for _, fk := range info.ForeignKeys() {
+			if fk.Reference().ForeignKey().Collection() != collection {
+				continue
+			}
+
+			exists, err := b.dialect.CollectionExists(b, info.BackendName())
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+
+			exists, err = b.dialect.ConstraintExists(b, info.BackendName(), fk.Name())
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+
+			if err := b.exec(NewAddConstraintStmt(info.BackendName(), fk)); err != nil {
+				return err
+			}
+		}
 	}
 
-	return result, nil
+	return nil
 }
 
 func (b *Backend) CreateCollection(collections ...string) apperror.Error {
@@ -321,3 +622,21 @@ func (b *Backend) CreateCollection(collections ...string) apperror.Error {
 
 	return nil
 }
+
+// queryString returns the first column of the first result row, or an empty
+// string if no row was found.
+func (b *Backend) queryString(query string, args ...interface{}) (string, apperror.Error) {
+	rows, err := b.SqlQuery(query, args...)
+	if err != nil {
+		return "", apperror.Wrap(err, "sql_error")
+	}
+	defer rows.Close()
+
+	var val string
+	if rows.Next() {
+		if err := rows.Scan(&val); err != nil {
+			return "", apperror.Wrap(err, "sql_scan_error")
+		}
+	}
+	return val, nil
+}
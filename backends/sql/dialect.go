@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
 	"strings"
@@ -17,6 +18,47 @@ type Dialect interface {
 	DetermineColumnType(attr *db.Attribute) (string, apperror.Error)
 
 	AfterCollectionCreate(info *db.ModelInfo) apperror.Error
+
+	// SupportsReturning returns true if the dialect can return data from
+	// an INSERT. If not, the primary key of a created model is determined
+	// with sql.Result.LastInsertId().
+	SupportsReturning() bool
+
+	// MaxParameters returns the maximum number of arguments of a single
+	// query. Multi-row inserts are chunked to stay below the limit.
+	MaxParameters() int
+
+	// FirstInsertId returns the id generated for the first row of a
+	// multi-row insert, given the result of sql.Result.LastInsertId().
+	// Only used if SupportsReturning() returns false.
+	FirstInsertId(lastInsertId int64, rowCount int) int64
+
+	// ExecStatement allows a dialect to execute statements that can not be
+	// translated to a single query.
+	// It returns false if the statement should be executed regularily.
+	ExecStatement(b *Backend, statement Expression) (bool, apperror.Error)
+
+	// ConvertScannedValue converts a value scanned from a column of a result
+	// row before it is used to build models.
+	ConvertScannedValue(value interface{}, column *sql.ColumnType) interface{}
+
+	// IsRetryableError returns true if the error was caused by a
+	// serialization failure or a deadlock, and the transaction can be
+	// retried.
+	IsRetryableError(err error) bool
+
+	// SupportsForwardReferences returns true if a foreign key constraint can
+	// reference a collection that does not exist yet.
+	// If not, foreign keys to missing collections are added once the
+	// referenced collection is created.
+	SupportsForwardReferences() bool
+
+	// CollectionExists returns true if the collection exists in the database.
+	CollectionExists(b *Backend, collection string) (bool, apperror.Error)
+
+	// ConstraintExists returns true if the named constraint exists on the
+	// collection.
+	ConstraintExists(b *Backend, collection, name string) (bool, apperror.Error)
 }
 
 type baseDialect struct {
@@ -29,6 +71,45 @@ func (baseDialect) AfterCollectionCreate(info *db.ModelInfo) apperror.Error {
 	return nil
 }
 
+func (baseDialect) SupportsReturning() bool {
+	return false
+}
+
+// MaxParameters defaults to 999, the limit of older SQLite versions.
+func (baseDialect) MaxParameters() int {
+	return 999
+}
+
+// FirstInsertId defaults to the MySQL behaviour, where LastInsertId() returns
+// the id of the first row.
+func (baseDialect) FirstInsertId(lastInsertId int64, rowCount int) int64 {
+	return lastInsertId
+}
+
+func (baseDialect) ExecStatement(b *Backend, statement Expression) (bool, apperror.Error) {
+	return false, nil
+}
+
+func (baseDialect) IsRetryableError(err error) bool {
+	return false
+}
+
+func (baseDialect) SupportsForwardReferences() bool {
+	return false
+}
+
+func (baseDialect) CollectionExists(b *Backend, collection string) (bool, apperror.Error) {
+	return false, apperror.New("unsupported", "The dialect can not determine if a collection exists")
+}
+
+func (baseDialect) ConstraintExists(b *Backend, collection, name string) (bool, apperror.Error) {
+	return false, apperror.New("unsupported", "The dialect can not determine if a constraint exists")
+}
+
+func (baseDialect) ConvertScannedValue(value interface{}, column *sql.ColumnType) interface{} {
+	return value
+}
+
 func (baseDialect) DetermineColumnType(attr *db.Attribute) (string, apperror.Error) {
 	if attr.BackendType() != "" {
 		return attr.BackendType(), nil
@@ -91,19 +172,3 @@ func (d *baseDialect) PrepareExpression(e Expression) apperror.Error {
 	d.SqlTranslator.PrepareExpression(e)
 	return nil
 }
-
-type MysqlDialect struct {
-	baseDialect
-}
-
-func (MysqlDialect) New() Dialect {
-	return &MysqlDialect{}
-}
-
-type SqliteDialect struct {
-	baseDialect
-}
-
-func (SqliteDialect) New() Dialect {
-	return &SqliteDialect{}
-}
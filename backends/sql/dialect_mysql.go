@@ -0,0 +1,306 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/theduke/go-apperror"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+type MysqlDialect struct {
+	baseDialect
+}
+
+// Ensure MysqlDialect implements Dialect.
+var _ Dialect = (*MysqlDialect)(nil)
+
+func NewMysqlDialect(b *Backend) Dialect {
+	d := &MysqlDialect{}
+	d.SqlTranslator = NewSqlTranslator(d)
+	d.backend = b
+	d.modelInfo = b.ModelInfos()
+	return d
+}
+
+func (d *MysqlDialect) New() Dialect {
+	return NewMysqlDialect(d.backend)
+}
+
+func (MysqlDialect) QuoteIdentifier(id string) string {
+	return "`" + strings.Replace(id, "`", "``", -1) + "`"
+}
+
+// ConvertScannedValue converts []byte values of non-binary columns to
+// strings, since the mysql driver returns text columns as []byte.
+func (MysqlDialect) ConvertScannedValue(value interface{}, column *sql.ColumnType) interface{} {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+
+	switch column.DatabaseTypeName() {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB":
+		return bytes
+	}
+	return string(bytes)
+}
+
+func (MysqlDialect) MaxParameters() int {
+	return 65535
+}
+
+// FirstInsertId returns the id of the first row, which is returned by
+// LastInsertId() for MySQL.
+// The ids of a multi-row insert are only consecutive with an
+// innodb_autoinc_lock_mode below 2 and an auto_increment_increment of 1.
+func (MysqlDialect) FirstInsertId(lastInsertId int64, rowCount int) int64 {
+	return lastInsertId
+}
+
+// IsRetryableError detects deadlocks (1213) and lock wait timeouts (1205).
+func (MysqlDialect) IsRetryableError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && (mysqlErr.Number == 1213 || mysqlErr.Number == 1205)
+}
+
+func (MysqlDialect) DetermineColumnType(attr *db.Attribute) (string, apperror.Error) {
+	if attr.BackendType() != "" {
+		return attr.BackendType(), nil
+	}
+	if attr.BackendMarshal() {
+		return "JSON", nil
+	}
+
+	if attr.BackendEmbed() {
+		return "", apperror.New("unsupported_embed", "The SQL backend does not support embedding. Use marshalling instead.")
+	}
+
+	switch attr.Type().Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return "INT", nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uintptr:
+		return "INT UNSIGNED", nil
+
+	case reflect.Int64:
+		return "BIGINT", nil
+
+	case reflect.Uint64:
+		return "BIGINT UNSIGNED", nil
+
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE", nil
+
+	case reflect.String:
+		if attr.Max() > 0 && attr.Max() < 16384 {
+			return fmt.Sprintf("VARCHAR(%v)", attr.Max()), nil
+		}
+		// TEXT columns can not be indexed without a prefix length.
+		if attr.IsPrimaryKey() || attr.IsUnique() || len(attr.IsUniqueWith()) > 0 || attr.IsIndex() {
+			return "VARCHAR(255)", nil
+		}
+		return "TEXT", nil
+
+	case reflect.Struct, reflect.Ptr:
+		if attr.StructName() == "time.Time" {
+			return "DATETIME", nil
+		}
+
+	case reflect.Map:
+		return "JSON", nil
+
+	case reflect.Slice:
+		if attr.StructName() == "byte" {
+			return "BLOB", nil
+		}
+	}
+	return "", apperror.New("unsupported_column_type",
+		fmt.Sprintf("Field %v has unsupported type %v (mysql)", attr.Name(), attr.Type()))
+}
+
+func (d *MysqlDialect) Translate(expression Expression) apperror.Error {
+	switch e := expression.(type) {
+	case *DropIndexStmt:
+		// IF EXISTS is handled in ExecStatement().
+		d.W("DROP INDEX ")
+		d.WQ(e.IndexName())
+		d.W(" ON ")
+		d.WQ(e.Collection())
+		return nil
+
+	case *DropFieldStmt:
+		// IF EXISTS is handled in ExecStatement().
+		d.W("ALTER TABLE ")
+		d.WQ(e.Collection())
+		d.W(" DROP COLUMN ")
+		d.WQ(e.Field())
+		return nil
+
+	case *CreateIndexStmt:
+		if e.Method() == "" {
+			break
+		}
+
+		// MySQL expects the method after the column list.
+		d.W("CREATE ")
+		if e.Unique() {
+			d.W("UNIQUE ")
+		}
+		d.W("INDEX ")
+		d.WQ(e.IndexName())
+		d.W(" ON ")
+		if err := d.Translate(e.IndexExpression()); err != nil {
+			return err
+		}
+		d.W(" (")
+		lastIndex := len(e.Expressions()) - 1
+		for index, expr := range e.Expressions() {
+			if err := d.Translate(expr); err != nil {
+				return err
+			}
+			if index < lastIndex {
+				d.W(", ")
+			}
+		}
+		d.W(") USING ", e.Method())
+		return nil
+
+	case *UpsertStmt:
+		// MySQL detects conflicts on any unique index, so the conflict fields
+		// are not rendered.
+		if err := e.Validate(); err != nil {
+			return apperror.Wrap(err, "invalid_expression_upsert")
+		}
+		if err := d.SqlTranslator.Translate(e.CreateStmt()); err != nil {
+			return err
+		}
+
+		d.W(" ON DUPLICATE KEY UPDATE ")
+		values := e.UpdateValues()
+		expressions := e.UpdateExpressions()
+		if len(values) < 1 && len(expressions) < 1 {
+			// A no-op update keeps the existing row.
+			d.WQ(e.ConflictFields()[0])
+			d.W(" = ")
+			d.WQ(e.ConflictFields()[0])
+			return nil
+		}
+
+		for i, val := range values {
+			if i > 0 {
+				d.W(", ")
+			}
+			if err := d.Translate(val.Field()); err != nil {
+				return err
+			}
+			d.W(" = VALUES(")
+			if err := d.Translate(val.Field()); err != nil {
+				return err
+			}
+			d.W(")")
+		}
+		for i, val := range expressions {
+			if i > 0 || len(values) > 0 {
+				d.W(", ")
+			}
+			if err := d.Translate(val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return d.SqlTranslator.Translate(expression)
+}
+
+func (d *MysqlDialect) CollectionExists(b *Backend, collection string) (bool, apperror.Error) {
+	name, err := b.queryString("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?", collection)
+	return name != "", err
+}
+
+func (d *MysqlDialect) ConstraintExists(b *Backend, collection, constraint string) (bool, apperror.Error) {
+	name, err := b.queryString("SELECT constraint_name FROM information_schema.table_constraints WHERE table_schema = DATABASE() AND table_name = ? AND constraint_name = ?", collection, constraint)
+	return name != "", err
+}
+
+// ExecStatement emulates IF EXISTS for DropIndexStmt and DropFieldStmt,
+// which MySQL does not support.
+// The table of an index to drop is determined if not set.
+// Since MySQL ignores CASCADE when dropping a table, foreign keys that
+// reference the table are dropped first.
+func (d *MysqlDialect) ExecStatement(b *Backend, statement Expression) (bool, apperror.Error) {
+	switch e := statement.(type) {
+	case *DropIndexStmt:
+		table, err := b.queryString("SELECT table_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND index_name = ? LIMIT 1", e.IndexName())
+		if err != nil {
+			return true, err
+		}
+		if table == "" {
+			if e.IfExists() {
+				return true, nil
+			}
+			return true, apperror.New("unknown_index", fmt.Sprintf("Index %v does not exist", e.IndexName()))
+		}
+		if e.Collection() == "" {
+			e.SetCollection(table)
+		}
+
+	case *DropFieldStmt:
+		if !e.IfExists() {
+			break
+		}
+		col, err := b.queryString("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", e.Collection(), e.Field())
+		if err != nil {
+			return true, err
+		}
+		if col == "" {
+			return true, nil
+		}
+
+	case *DropCollectionStmt:
+		if !e.Cascade() {
+			break
+		}
+		if err := d.dropReferencingForeignKeys(b, e.Collection()); err != nil {
+			return true, err
+		}
+	}
+
+	return false, nil
+}
+
+// dropReferencingForeignKeys drops all foreign keys of other tables that
+// reference the table.
+func (d *MysqlDialect) dropReferencingForeignKeys(b *Backend, table string) apperror.Error {
+	rows, err := b.SqlQuery("SELECT table_name, constraint_name FROM information_schema.referential_constraints WHERE constraint_schema = DATABASE() AND referenced_table_name = ? AND table_name != ?", table, table)
+	if err != nil {
+		return apperror.Wrap(err, "sql_error")
+	}
+
+	keys := make([][2]string, 0)
+	for rows.Next() {
+		var key [2]string
+		if err := rows.Scan(&key[0], &key[1]); err != nil {
+			rows.Close()
+			return apperror.Wrap(err, "sql_scan_error")
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		if _, err := b.SqlExec(fmt.Sprintf("ALTER TABLE %v DROP FOREIGN KEY %v", d.QuoteIdentifier(key[0]), d.QuoteIdentifier(key[1]))); err != nil {
+			return apperror.Wrap(err, "sql_error")
+		}
+	}
+	return nil
+}
@@ -1,11 +1,13 @@
 package sql
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
 
 	db "github.com/theduke/go-dukedb"
 	. "github.com/theduke/go-dukedb/expressions"
@@ -13,6 +15,10 @@ import (
 
 type PostgresDialect struct {
 	baseDialect
+
+	// elemAliases holds the aliases of the array elements of the element
+	// match filters that are currently translated, innermost last.
+	elemAliases []string
 }
 
 // Ensure PostgresDialect implements Dialect.
@@ -30,6 +36,38 @@ func (d *PostgresDialect) New() Dialect {
 	return NewPostgresDialect(d.backend)
 }
 
+// IsRetryableError detects serialization failures (SQLSTATE 40001) and
+// deadlocks (SQLSTATE 40P01).
+func (PostgresDialect) IsRetryableError(err error) bool {
+	// pq.Error exposes the fields of the error with Get().
+	pqErr, ok := err.(interface {
+		Get(byte) string
+	})
+	if !ok {
+		return false
+	}
+	code := pqErr.Get('C')
+	return code == "40001" || code == "40P01"
+}
+
+func (d *PostgresDialect) CollectionExists(b *Backend, collection string) (bool, apperror.Error) {
+	name, err := b.queryString("SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1", collection)
+	return name != "", err
+}
+
+func (d *PostgresDialect) ConstraintExists(b *Backend, collection, constraint string) (bool, apperror.Error) {
+	name, err := b.queryString("SELECT constraint_name FROM information_schema.table_constraints WHERE table_schema = current_schema() AND table_name = $1 AND constraint_name = $2", collection, constraint)
+	return name != "", err
+}
+
+func (PostgresDialect) SupportsReturning() bool {
+	return true
+}
+
+func (PostgresDialect) MaxParameters() int {
+	return 65535
+}
+
 func (d *PostgresDialect) AfterCollectionCreate(info *db.ModelInfo) apperror.Error {
 	for _, attr := range info.Attributes() {
 		// Alter sequences to start at 1 instead of 0.
@@ -48,13 +86,10 @@ func (d *PostgresDialect) PrepareExpression(expression Expression) apperror.Erro
 	switch e := expression.(type) {
 	case *CreateStmt:
 		// Add RETURNING clause for id.
-		info := d.modelInfo.Find(e.Collection())
-		if info != nil {
-			pk := info.PkAttribute()
-			if pk != nil && pk.AutoIncrement() {
-				e.AddField(NewFieldSelector(pk.Name(), info.BackendName(), pk.BackendName(), pk.Type()))
-			}
-		}
+		d.addReturningPk(e, e.Collection())
+
+	case *UpsertStmt:
+		d.addReturningPk(e, e.Collection())
 
 	case *SelectStmt:
 		if len(e.Fields()) == 0 {
@@ -73,6 +108,18 @@ func (d *PostgresDialect) PrepareExpression(expression Expression) apperror.Erro
 	return nil
 }
 
+// addReturningPk adds the auto incremented primary key of the collection
+// as a RETURNING field.
+func (d *PostgresDialect) addReturningPk(e FieldedExpression, collection string) {
+	info := d.modelInfo.Find(collection)
+	if info != nil {
+		pk := info.PkAttribute()
+		if pk != nil && pk.AutoIncrement() {
+			e.AddField(NewFieldSelector(pk.Name(), info.BackendName(), pk.BackendName(), pk.Type()))
+		}
+	}
+}
+
 func (d *PostgresDialect) Translate(expression Expression) apperror.Error {
 	switch e := expression.(type) {
 	case *ConstraintExpr:
@@ -98,11 +145,229 @@ func (d *PostgresDialect) Translate(expression Expression) apperror.Error {
 		}
 
 		return nil
+
+	case *UpsertStmt:
+		if err := d.SqlTranslator.Translate(e); err != nil {
+			return err
+		}
+
+		// Add returning fields.
+		// Rows that are left unchanged with DO NOTHING are not returned.
+		fields := e.Fields()
+		if len(fields) > 0 {
+			d.W(" RETURNING ")
+			for _, f := range fields {
+				d.SqlTranslator.Translate(f)
+			}
+		}
+
+		return nil
+
+	case *IdentifierExpr:
+		if len(d.elemAliases) > 0 {
+			d.translateElementField(e)
+			return nil
+		}
+
+	case *ElemMatchExpr:
+		return d.translateElemMatch(e)
+
+	case FilterExpression:
+		if e.Operator() == OPERATOR_SIZE {
+			d.W("jsonb_array_length(")
+			if err := d.translateJsonArray(e.Field()); err != nil {
+				return err
+			}
+			d.W(") = ")
+			return d.Translate(e.Clause())
+		}
+
+		if _, ok := e.Field().(*IdentifierExpr); ok && len(d.elemAliases) > 0 {
+			return d.translateElementFilter(e)
+		}
+
+		// Postgres has native case insensitive LIKE and regex operators.
+		operator := ""
+		switch e.Operator() {
+		case OPERATOR_ILIKE:
+			operator = " ILIKE "
+		case OPERATOR_REGEX:
+			operator = " ~ "
+		default:
+			return d.SqlTranslator.TranslateFilter(e)
+		}
+
+		if err := d.Translate(e.Field()); err != nil {
+			return err
+		}
+		d.W(operator)
+		return d.Translate(e.Clause())
 	}
 
 	return d.SqlTranslator.Translate(expression)
 }
 
+/**
+ * Array filters.
+ *
+ * Arrays are stored marshalled as json in text columns, so array filters
+ * cast them to jsonb.
+ */
+
+// translateJsonArray writes the field as a jsonb array, or NULL if it does
+// not hold an array.
+func (d *PostgresDialect) translateJsonArray(field Expression) apperror.Error {
+	d.W("CASE WHEN jsonb_typeof((")
+	if err := d.Translate(field); err != nil {
+		return err
+	}
+	d.W(")::jsonb) = 'array' THEN (")
+	if err := d.Translate(field); err != nil {
+		return err
+	}
+	d.W(")::jsonb END")
+	return nil
+}
+
+// translateElemMatch translates an element match filter to an EXISTS sub
+// query over the elements of the array.
+func (d *PostgresDialect) translateElemMatch(e *ElemMatchExpr) apperror.Error {
+	alias := "elem" + strconv.Itoa(len(d.elemAliases))
+
+	d.W("EXISTS (SELECT 1 FROM jsonb_array_elements(")
+	if err := d.translateJsonArray(e.Field()); err != nil {
+		return err
+	}
+	d.W(") AS ")
+	d.WQ(alias)
+	d.W(" WHERE ")
+
+	d.elemAliases = append(d.elemAliases, alias)
+	err := d.Translate(e.Filter())
+	d.elemAliases = d.elemAliases[:len(d.elemAliases)-1]
+	if err != nil {
+		return err
+	}
+
+	d.W(")")
+	return nil
+}
+
+// translateElementField writes the jsonb value of an identifier in an
+// element match filter, which is either the element itself or a field of
+// the element.
+func (d *PostgresDialect) translateElementField(e *IdentifierExpr) {
+	alias := d.elemAliases[len(d.elemAliases)-1]
+	if e.Identifier() == ELEM_MATCH_SELF {
+		d.WQ(alias)
+		return
+	}
+
+	d.W("(")
+	d.WQ(alias)
+	d.W(" -> ", d.Placeholder(), "::text)")
+	d.Arg(NewValueExpr(e.Identifier()))
+}
+
+// translateElementFilter translates a filter on an array element.
+// Comparisons are done on jsonb values, pattern matches on the text of the
+// value.
+func (d *PostgresDialect) translateElementFilter(e FilterExpression) apperror.Error {
+	switch e.Operator() {
+	case OPERATOR_NULL, OPERATOR_NOT_NULL:
+		// Missing fields are NULL, json null values are 'null'.
+		d.W("COALESCE(")
+		if err := d.Translate(e.Field()); err != nil {
+			return err
+		}
+		if e.Operator() == OPERATOR_NULL {
+			d.W(", 'null'::jsonb) = 'null'::jsonb")
+		} else {
+			d.W(", 'null'::jsonb) != 'null'::jsonb")
+		}
+		return nil
+
+	case OPERATOR_LIKE, OPERATOR_NLIKE, OPERATOR_ILIKE, OPERATOR_REGEX:
+		operators := map[string]string{
+			OPERATOR_LIKE:  " LIKE ",
+			OPERATOR_NLIKE: " NOT LIKE ",
+			OPERATOR_ILIKE: " ILIKE ",
+			OPERATOR_REGEX: " ~ ",
+		}
+
+		d.W("(")
+		if err := d.Translate(e.Field()); err != nil {
+			return err
+		}
+		d.W(" #>> '{}')", operators[e.Operator()])
+		return d.Translate(e.Clause())
+
+	case OPERATOR_EQ, OPERATOR_NEQ, OPERATOR_GT, OPERATOR_GTE, OPERATOR_LT, OPERATOR_LTE, OPERATOR_BETWEEN, OPERATOR_IN, OPERATOR_NIN:
+		// Handled below.
+
+	default:
+		return apperror.New("unsupported_operator", fmt.Sprintf("The operator %v is not supported in element match filters", e.Operator()), true)
+	}
+
+	if err := d.Translate(e.Field()); err != nil {
+		return err
+	}
+
+	val, ok := e.Clause().(*ValueExpr)
+	if !ok {
+		return apperror.New("unsupported_expression", "Element match filters only support value clauses", true)
+	}
+
+	switch e.Operator() {
+	case OPERATOR_BETWEEN:
+		values, err := BetweenValues(val)
+		if err != nil {
+			return err
+		}
+		d.W(" BETWEEN ")
+		if err := d.jsonbArg(values[0].Value()); err != nil {
+			return err
+		}
+		d.W(" AND ")
+		return d.jsonbArg(values[1].Value())
+
+	case OPERATOR_IN, OPERATOR_NIN:
+		r, err := reflector.Reflect(val.Value()).Slice()
+		if err != nil {
+			return apperror.New("invalid_in_filter_value")
+		} else if r.Len() < 1 {
+			return apperror.New("invalid_in_filter_no_values")
+		}
+
+		d.W(" ", e.Operator(), " (")
+		for i, item := range r.Items() {
+			if i > 0 {
+				d.W(",")
+			}
+			if err := d.jsonbArg(item.Interface()); err != nil {
+				return err
+			}
+		}
+		d.W(")")
+		return nil
+	}
+
+	d.W(" ", e.Operator(), " ")
+	return d.jsonbArg(val.Value())
+}
+
+// jsonbArg adds a value as a jsonb argument.
+func (d *PostgresDialect) jsonbArg(value interface{}) apperror.Error {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return apperror.Wrap(err, "json_marshal_error", "Could not marshal the element match value to json")
+	}
+
+	d.W(d.Placeholder(), "::jsonb")
+	d.Arg(NewValueExpr(string(js)))
+	return nil
+}
+
 func (PostgresDialect) Placeholder() string {
 	return "${}$"
 }
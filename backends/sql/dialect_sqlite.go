@@ -0,0 +1,703 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/theduke/go-apperror"
+
+	db "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+// sqliteFuncRegisterer is implemented by the connections of the go-sqlite3
+// driver, which is not imported to avoid depending on cgo.
+type sqliteFuncRegisterer interface {
+	RegisterFunc(name string, impl interface{}, pure bool) error
+}
+
+// sqliteConnector opens connections with the sqlite3 driver, and registers
+// the regexp() function used by the REGEXP operator on each of them.
+type sqliteConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if registerer, ok := conn.(sqliteFuncRegisterer); ok {
+		if err := registerer.RegisterFunc("regexp", sqliteRegexp, true); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqliteRegexp implements "value REGEXP pattern", which SQLite calls as
+// regexp(pattern, value).
+// NULL values never match.
+func sqliteRegexp(pattern string, value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case []byte:
+		return regexp.Match(pattern, v)
+	case string:
+		return regexp.MatchString(pattern, v)
+	default:
+		return regexp.MatchString(pattern, fmt.Sprintf("%v", v))
+	}
+}
+
+type SqliteDialect struct {
+	baseDialect
+}
+
+// Ensure SqliteDialect implements Dialect.
+var _ Dialect = (*SqliteDialect)(nil)
+
+func NewSqliteDialect(b *Backend) Dialect {
+	d := &SqliteDialect{}
+	d.SqlTranslator = NewSqlTranslator(d)
+	d.backend = b
+	d.modelInfo = b.ModelInfos()
+	return d
+}
+
+func (d *SqliteDialect) New() Dialect {
+	return NewSqliteDialect(d.backend)
+}
+
+// DetermineColumnType maps attributes to the type affinities of SQLite.
+// Declared types are chosen so that the go-sqlite3 driver converts
+// booleans and times back to their go types.
+func (SqliteDialect) DetermineColumnType(attr *db.Attribute) (string, apperror.Error) {
+	if attr.BackendType() != "" {
+		return attr.BackendType(), nil
+	}
+	if attr.BackendMarshal() {
+		return "TEXT", nil
+	}
+
+	if attr.BackendEmbed() {
+		return "", apperror.New("unsupported_embed", "The SQL backend does not support embedding. Use marshalling instead.")
+	}
+
+	switch attr.Type().Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Auto incrementing primary keys must be declared as INTEGER.
+		return "INTEGER", nil
+
+	case reflect.Float32, reflect.Float64:
+		return "REAL", nil
+
+	case reflect.String:
+		return "TEXT", nil
+
+	case reflect.Struct, reflect.Ptr:
+		if attr.StructName() == "time.Time" {
+			return "DATETIME", nil
+		}
+
+	case reflect.Slice:
+		if attr.StructName() == "byte" {
+			return "BLOB", nil
+		}
+	}
+	return "", apperror.New("unsupported_column_type",
+		fmt.Sprintf("Field %v has unsupported type %v (sqlite)", attr.Name(), attr.Type()))
+}
+
+// SupportsForwardReferences returns true, since SQLite only checks foreign
+// keys when data is modified.
+func (SqliteDialect) SupportsForwardReferences() bool {
+	return true
+}
+
+// FirstInsertId calculates the first id from the id of the last row, which is
+// returned by LastInsertId() for SQLite. The rows of a single statement get
+// consecutive ids, since SQLite allows only one writer at a time.
+func (SqliteDialect) FirstInsertId(lastInsertId int64, rowCount int) int64 {
+	return lastInsertId - int64(rowCount) + 1
+}
+
+func (d *SqliteDialect) CollectionExists(b *Backend, collection string) (bool, apperror.Error) {
+	createSql, err := d.masterSql(b, "table", collection)
+	return createSql != "", err
+}
+
+// IsRetryableError detects errors caused by a locked database.
+func (SqliteDialect) IsRetryableError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// Translate handles SQLite specific expressions.
+// Regex filters use the REGEXP operator, which calls the regexp() function
+// registered by the sqliteConnector.
+// Upserts use the ON CONFLICT clause of the SqlTranslator, which requires
+// SQLite 3.24 or later.
+func (d *SqliteDialect) Translate(expression Expression) apperror.Error {
+	switch e := expression.(type) {
+	case *ConstraintExpr:
+		// Results in INTEGER PRIMARY KEY AUTOINCREMENT, since the primary key
+		// constraint is always added before auto increment.
+		if e.Constraint() == CONSTRAINT_AUTO_INCREMENT {
+			d.W("AUTOINCREMENT")
+			return nil
+		}
+
+	case *DropCollectionStmt:
+		// SQLite does not support CASCADE.
+		d.W("DROP TABLE ")
+		if e.IfExists() {
+			d.W("IF EXISTS ")
+		}
+		d.WQ(e.Collection())
+		return nil
+
+	case *DropIndexStmt:
+		// SQLite does not support CASCADE.
+		d.W("DROP INDEX ")
+		if e.IfExists() {
+			d.W("IF EXISTS ")
+		}
+		d.WQ(e.IndexName())
+		return nil
+
+	case *CreateIndexStmt:
+		if e.Method() != "" {
+			return apperror.New("unsupported_index_method", "SQLite does not support index methods")
+		}
+	}
+
+	return d.SqlTranslator.Translate(expression)
+}
+
+// ExecStatement handles DropFieldStmt and RenameFieldStmt, since
+// ALTER TABLE in SQLite does not support dropping or renaming columns.
+// The table is rebuilt instead.
+func (d *SqliteDialect) ExecStatement(b *Backend, statement Expression) (bool, apperror.Error) {
+	switch e := statement.(type) {
+	case *DropFieldStmt:
+		return true, d.rebuildTable(b, e.Collection(), e.Field(), "", e.IfExists())
+	case *RenameFieldStmt:
+		return true, d.rebuildTable(b, e.Collection(), e.Field(), e.NewName(), false)
+	}
+	return false, nil
+}
+
+type sqliteColumn struct {
+	name         string
+	typ          string
+	notNull      bool
+	defaultValue interface{}
+	pk           int
+}
+
+type sqliteForeignKey struct {
+	table    string
+	from     []string
+	to       []string
+	onUpdate string
+	onDelete string
+}
+
+type sqliteIndex struct {
+	name   string
+	unique bool
+	origin string
+	sql    string
+}
+
+// rebuildTable recreates a table without the given field, or with the field
+// renamed to newName if it is not empty.
+//
+// Columns, the primary key, unique constraints, foreign keys and indexes are
+// restored. The data is copied to the new table.
+//
+// Dropping the old table runs the ON DELETE actions of the foreign keys that
+// reference it, so outside of a transaction, the table is rebuilt on a
+// connection with foreign keys disabled. Inside of a transaction, they
+// can not be disabled, and tables that are referenced by foreign keys can
+// not be rebuilt.
+func (d *SqliteDialect) rebuildTable(b *Backend, table, field, newName string, ifExists bool) apperror.Error {
+	if b.Tx == nil {
+		return d.rebuildTableWithoutForeignKeys(b, table, field, newName, ifExists)
+	}
+
+	enabled, err := b.queryString("PRAGMA foreign_keys")
+	if err != nil {
+		return err
+	}
+	if enabled == "1" {
+		referenced, err := d.isReferenced(b, table)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			return apperror.New("referenced_table_rebuild",
+				fmt.Sprintf("Table %v is referenced by foreign keys, and can only be rebuilt outside of a transaction", table))
+		}
+	}
+
+	return d.copyTable(b, table, field, newName, ifExists)
+}
+
+// rebuildTableWithoutForeignKeys rebuilds a table in a new transaction on a
+// connection with foreign keys disabled.
+// The foreign keys of the table are checked before the commit.
+func (d *SqliteDialect) rebuildTableWithoutForeignKeys(b *Backend, table, field, newName string, ifExists bool) apperror.Error {
+	ctx := context.Background()
+
+	conn, err := b.Db.Conn(ctx)
+	if err != nil {
+		return apperror.Wrap(err, "sql_connection_error")
+	}
+	defer conn.Close()
+
+	// The connection returns to the pool afterwards, so the setting is
+	// restored.
+	var enabled int
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		return apperror.Wrap(err, "sql_error")
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return apperror.Wrap(err, "sql_error")
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %v", enabled))
+
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return apperror.Wrap(err, "begin_transaction_failed")
+	}
+
+	tx := b.Clone().(*Backend)
+	tx.Tx = sqlTx
+	tx.Db = nil
+	tx.savepoint = ""
+	tx.savepointCount = new(int)
+
+	if err := d.copyTable(tx, table, field, newName, ifExists); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.SqlQuery(fmt.Sprintf("PRAGMA foreign_key_check(%v)", d.QuoteIdentifier(table)))
+	if err != nil {
+		tx.Rollback()
+		return apperror.Wrap(err, "sql_error")
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		tx.Rollback()
+		return apperror.New("foreign_key_violation", fmt.Sprintf("Rebuilding table %v violates its foreign keys", table))
+	}
+
+	return tx.Commit()
+}
+
+// isReferenced returns true if foreign keys of other tables reference the
+// table.
+func (d *SqliteDialect) isReferenced(b *Backend, table string) (bool, apperror.Error) {
+	tables := make([]string, 0)
+	rows, err := b.SqlQuery("SELECT name FROM sqlite_master WHERE type = 'table' AND name != ?", table)
+	if err != nil {
+		return false, apperror.Wrap(err, "sql_error")
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return false, apperror.Wrap(err, "sql_scan_error")
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, name := range tables {
+		keys, err := d.tableForeignKeys(b, name)
+		if err != nil {
+			return false, err
+		}
+		for _, key := range keys {
+			if key.table == table {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// copyTable copies a table to a new table without the given field, or with
+// the field renamed, and replaces the old table with it.
+func (d *SqliteDialect) copyTable(b *Backend, table, field, newName string, ifExists bool) apperror.Error {
+	columns, err := d.tableColumns(b, table)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, col := range columns {
+		if col.name == field {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if ifExists {
+			return nil
+		}
+		return apperror.New("unknown_field", fmt.Sprintf("Table %v does not have a column %v", table, field))
+	}
+
+	indexes, err := d.tableIndexes(b, table)
+	if err != nil {
+		return err
+	}
+
+	createSql, err := d.masterSql(b, "table", table)
+	if err != nil {
+		return err
+	}
+	hasAutoIncrement := strings.Contains(strings.ToUpper(createSql), "AUTOINCREMENT")
+
+	// Maps the old column name to the new one.
+	columnName := func(name string) string {
+		if name == field {
+			return newName
+		}
+		return name
+	}
+
+	tmpTable := table + "__rebuild"
+
+	oldCols := make([]string, 0)
+	newCols := make([]string, 0)
+	defs := make([]string, 0)
+	pks := make([]string, 0)
+
+	for _, col := range columns {
+		name := columnName(col.name)
+		if name == "" {
+			// Dropped column.
+			continue
+		}
+		oldCols = append(oldCols, d.QuoteIdentifier(col.name))
+		newCols = append(newCols, d.QuoteIdentifier(name))
+
+		if col.pk > 0 {
+			pks = append(pks, d.QuoteIdentifier(name))
+		}
+	}
+
+	for _, col := range columns {
+		name := columnName(col.name)
+		if name == "" {
+			continue
+		}
+
+		def := d.QuoteIdentifier(name) + " " + col.typ
+		if col.pk > 0 && len(pks) == 1 {
+			def += " PRIMARY KEY"
+			if hasAutoIncrement && strings.ToUpper(col.typ) == "INTEGER" {
+				def += " AUTOINCREMENT"
+			}
+		}
+		if col.notNull {
+			def += " NOT NULL"
+		}
+		if col.defaultValue != nil {
+			def += fmt.Sprintf(" DEFAULT %s", col.defaultValue)
+		}
+		defs = append(defs, def)
+	}
+
+	if len(pks) > 1 {
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(pks, ", ")+")")
+	}
+
+	// Restore unique constraints.
+	for _, index := range indexes {
+		if index.origin != "u" {
+			continue
+		}
+
+		cols, err := d.indexColumns(b, index.name)
+		if err != nil {
+			return err
+		}
+
+		quoted := make([]string, 0)
+		for _, col := range cols {
+			name := columnName(col)
+			if name == "" {
+				// Constraint on the dropped column, so drop it as well.
+				quoted = nil
+				break
+			}
+			quoted = append(quoted, d.QuoteIdentifier(name))
+		}
+
+		if len(quoted) > 0 {
+			defs = append(defs, "UNIQUE ("+strings.Join(quoted, ", ")+")")
+		}
+	}
+
+	// Restore foreign keys.
+	foreignKeys, err := d.tableForeignKeys(b, table)
+	if err != nil {
+		return err
+	}
+	for _, key := range foreignKeys {
+		from := make([]string, 0)
+		to := make([]string, 0)
+		for i, col := range key.from {
+			name := columnName(col)
+			if name == "" {
+				// Foreign key on the dropped column, so drop it as well.
+				from = nil
+				break
+			}
+			from = append(from, d.QuoteIdentifier(name))
+
+			refCol := key.to[i]
+			if key.table == table {
+				refCol = columnName(refCol)
+			}
+			if refCol != "" {
+				to = append(to, d.QuoteIdentifier(refCol))
+			}
+		}
+		if len(from) == 0 {
+			continue
+		}
+
+		def := "FOREIGN KEY (" + strings.Join(from, ", ") + ") REFERENCES " + d.QuoteIdentifier(key.table)
+		if len(to) == len(from) {
+			def += " (" + strings.Join(to, ", ") + ")"
+		}
+		if key.onUpdate != "" && key.onUpdate != "NO ACTION" {
+			def += " ON UPDATE " + key.onUpdate
+		}
+		if key.onDelete != "" && key.onDelete != "NO ACTION" {
+			def += " ON DELETE " + key.onDelete
+		}
+		defs = append(defs, def)
+	}
+
+	queries := []string{
+		fmt.Sprintf("CREATE TABLE %v (%v)", d.QuoteIdentifier(tmpTable), strings.Join(defs, ", ")),
+		fmt.Sprintf("INSERT INTO %v (%v) SELECT %v FROM %v",
+			d.QuoteIdentifier(tmpTable), strings.Join(newCols, ", "), strings.Join(oldCols, ", "), d.QuoteIdentifier(table)),
+		fmt.Sprintf("DROP TABLE %v", d.QuoteIdentifier(table)),
+		fmt.Sprintf("ALTER TABLE %v RENAME TO %v", d.QuoteIdentifier(tmpTable), d.QuoteIdentifier(table)),
+	}
+
+	// Restore indexes that were created with CREATE INDEX.
+	quotedField := d.QuoteIdentifier(field)
+	for _, index := range indexes {
+		if index.origin != "c" || index.sql == "" {
+			continue
+		}
+		if strings.Contains(index.sql, quotedField) {
+			if newName == "" {
+				// Index on the dropped column.
+				continue
+			}
+			index.sql = strings.Replace(index.sql, quotedField, d.QuoteIdentifier(newName), -1)
+		}
+		queries = append(queries, index.sql)
+	}
+
+	for _, query := range queries {
+		if _, err := b.SqlExec(query); err != nil {
+			return apperror.Wrap(err, "sql_error")
+		}
+	}
+
+	return nil
+}
+
+func (d *SqliteDialect) tableColumns(b *Backend, table string) ([]*sqliteColumn, apperror.Error) {
+	rows, err := b.SqlQuery(fmt.Sprintf("PRAGMA table_info(%v)", d.QuoteIdentifier(table)))
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
+	}
+	defer rows.Close()
+
+	columns := make([]*sqliteColumn, 0)
+	for rows.Next() {
+		var cid int
+		col := &sqliteColumn{}
+		if err := rows.Scan(&cid, &col.name, &col.typ, &col.notNull, &col.defaultValue, &col.pk); err != nil {
+			return nil, apperror.Wrap(err, "sql_scan_error")
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperror.Wrap(err, "sql_rows_error")
+	}
+
+	return columns, nil
+}
+
+// tableForeignKeys returns the foreign keys of a table.
+// Referenced columns are empty if the key references the primary key
+// implicitly.
+func (d *SqliteDialect) tableForeignKeys(b *Backend, table string) ([]*sqliteForeignKey, apperror.Error) {
+	rows, err := b.SqlQuery(fmt.Sprintf("PRAGMA foreign_key_list(%v)", d.QuoteIdentifier(table)))
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
+	}
+	defer rows.Close()
+
+	keys := make([]*sqliteForeignKey, 0)
+	keysById := make(map[int]*sqliteForeignKey)
+	for rows.Next() {
+		var id, seq int
+		var refTable, from, onUpdate, onDelete, match string
+		var to *string
+		if err := rows.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, apperror.Wrap(err, "sql_scan_error")
+		}
+
+		key := keysById[id]
+		if key == nil {
+			key = &sqliteForeignKey{
+				table:    refTable,
+				onUpdate: onUpdate,
+				onDelete: onDelete,
+			}
+			keysById[id] = key
+			keys = append(keys, key)
+		}
+
+		key.from = append(key.from, from)
+		refCol := ""
+		if to != nil {
+			refCol = *to
+		}
+		key.to = append(key.to, refCol)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperror.Wrap(err, "sql_rows_error")
+	}
+
+	return keys, nil
+}
+
+func (d *SqliteDialect) tableIndexes(b *Backend, table string) ([]*sqliteIndex, apperror.Error) {
+	rows, err := b.SqlQuery(fmt.Sprintf("PRAGMA index_list(%v)", d.QuoteIdentifier(table)))
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, apperror.Wrap(err, "sql_rows_error")
+	}
+
+	indexes := make([]*sqliteIndex, 0)
+	for rows.Next() {
+		// The number of columns differs between SQLite versions, so scan
+		// into a map.
+		values := make([]interface{}, len(cols))
+		pointers := make([]interface{}, len(cols))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			rows.Close()
+			return nil, apperror.Wrap(err, "sql_scan_error")
+		}
+
+		index := &sqliteIndex{}
+		for i, col := range cols {
+			switch col {
+			case "name":
+				index.name = fmt.Sprintf("%s", values[i])
+			case "unique":
+				index.unique = fmt.Sprintf("%v", values[i]) == "1"
+			case "origin":
+				index.origin = fmt.Sprintf("%s", values[i])
+			}
+		}
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+
+	for _, index := range indexes {
+		if index.origin != "c" {
+			continue
+		}
+		indexSql, err := d.masterSql(b, "index", index.name)
+		if err != nil {
+			return nil, err
+		}
+		index.sql = indexSql
+	}
+
+	return indexes, nil
+}
+
+func (d *SqliteDialect) indexColumns(b *Backend, index string) ([]string, apperror.Error) {
+	rows, err := b.SqlQuery(fmt.Sprintf("PRAGMA index_info(%v)", d.QuoteIdentifier(index)))
+	if err != nil {
+		return nil, apperror.Wrap(err, "sql_error")
+	}
+	defer rows.Close()
+
+	columns := make([]string, 0)
+	for rows.Next() {
+		var seqno, cid int
+		var name string
+		if err := rows.Scan(&seqno, &cid, &name); err != nil {
+			return nil, apperror.Wrap(err, "sql_scan_error")
+		}
+		columns = append(columns, name)
+	}
+
+	return columns, nil
+}
+
+// masterSql returns the sql used to create a table or index.
+// It returns an empty string for automatically created indexes.
+func (d *SqliteDialect) masterSql(b *Backend, typ, name string) (string, apperror.Error) {
+	rows, err := b.SqlQuery("SELECT sql FROM sqlite_master WHERE type = ? AND name = ?", typ, name)
+	if err != nil {
+		return "", apperror.Wrap(err, "sql_error")
+	}
+	defer rows.Close()
+
+	var createSql *string
+	if rows.Next() {
+		if err := rows.Scan(&createSql); err != nil {
+			return "", apperror.Wrap(err, "sql_scan_error")
+		}
+	}
+	if createSql == nil {
+		return "", nil
+	}
+	return *createSql, nil
+}
@@ -17,15 +17,17 @@ import (
 	"github.com/theduke/go-dukedb/backends/sql"
 )
 
-func TestPostgres(t *testing.T) {
+func TestMysql(t *testing.T) {
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "Postgres Suite")
+	RunSpecs(t, "Mysql Suite")
 }
 
 var serverCmd *exec.Cmd
 var tmpDir string
 var finishedChannel chan bool
 
+var setupFailed bool = true
+
 var _ = BeforeSuite(func() {
 	tmpDir = path.Join(os.TempDir(), "dukedb_backend_mysql_test")
 	// Ensure that tmp dir is deleted.
@@ -62,9 +64,13 @@ var _ = BeforeSuite(func() {
 
 	_, err = backend.SqlExec("CREATE DATABASE test")
 	Expect(err).ToNot(HaveOccurred())
+
+	setupFailed = false
 })
 
 var _ = AfterSuite(func() {
-	serverCmd.Process.Kill()
+	if serverCmd != nil {
+		serverCmd.Process.Kill()
+	}
 	os.RemoveAll(tmpDir)
 })
@@ -2,13 +2,44 @@ package mysql_test
 
 import (
 	. "github.com/onsi/ginkgo"
-	//. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega"
 
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/theduke/go-apperror"
+	db "github.com/theduke/go-dukedb"
 	"github.com/theduke/go-dukedb/backends/sql"
 	"github.com/theduke/go-dukedb/backends/tests"
 )
 
+func builder() (db.Backend, apperror.Error) {
+	return sql.New("mysql", "root@tcp(127.0.0.1:10002)/test?charset=utf8&parseTime=True&loc=Local")
+}
+
 var _ = Describe("Mysql", func() {
-	backend, _ := sql.New("mysql", "root@tcp(127.0.0.1:10002)/test?charset=utf8&parseTime=True&loc=Local")
-	tests.TestBackend(backend)
+	tests.TestBackend(&setupFailed, builder)
+})
+
+var _ = Describe("Mysql retryable errors", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		b, err := builder()
+		Expect(err).ToNot(HaveOccurred())
+		backend = b.(*sql.Backend)
+	})
+
+	It("Should detect deadlocks and lock wait timeouts", func() {
+		Expect(backend.IsRetryableError(&mysql.MySQLError{Number: 1213})).To(BeTrue())
+		Expect(backend.IsRetryableError(&mysql.MySQLError{Number: 1205})).To(BeTrue())
+	})
+
+	It("Should not detect other errors", func() {
+		Expect(backend.IsRetryableError(&mysql.MySQLError{Number: 1062})).To(BeFalse())
+		Expect(backend.IsRetryableError(apperror.New("Error 1213: deadlock"))).To(BeFalse())
+	})
 })
@@ -2,7 +2,7 @@ package postgres_test
 
 import (
 	. "github.com/onsi/ginkgo"
-	//. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega"
 
 	_ "github.com/lib/pq"
 
@@ -19,3 +19,90 @@ func builder() (db.Backend, apperror.Error) {
 var _ = Describe("Postgres", func() {
 	tests.TestBackend(&setupFailed, builder)
 })
+
+var _ = Describe("Postgres array filters", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		b, err := builder()
+		Expect(err).ToNot(HaveOccurred())
+		backend = b.(*sql.Backend)
+
+		backend.RegisterModel(&tests.ArrayModel{})
+		backend.Build()
+
+		Expect(backend.DropCollection("array_models", true, false)).ToNot(HaveOccurred())
+		Expect(backend.CreateCollection("array_models")).ToNot(HaveOccurred())
+
+		Expect(backend.Create(&tests.ArrayModel{Name: "a", Scores: []int{1, 2, 3}, Tags: []map[string]interface{}{{"name": "x", "weight": 1}}})).ToNot(HaveOccurred())
+		Expect(backend.Create(&tests.ArrayModel{Name: "b", Scores: []int{5, 9}, Tags: []map[string]interface{}{{"name": "x", "weight": 5}, {"name": "y"}}})).ToNot(HaveOccurred())
+		Expect(backend.Create(&tests.ArrayModel{Name: "c", Scores: []int{}})).ToNot(HaveOccurred())
+	})
+
+	find := func(filters map[string]interface{}) []string {
+		q, err := db.ParseQuery(backend, map[string]interface{}{
+			"collection": "array_models",
+			"filters":    filters,
+			"order":      "name",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		res, err := q.Find()
+		Expect(err).ToNot(HaveOccurred())
+
+		names := make([]string, 0)
+		for _, item := range res {
+			names = append(names, item.(*tests.ArrayModel).Name)
+		}
+		return names
+	}
+
+	It("Should filter with $size", func() {
+		Expect(find(map[string]interface{}{"scores": map[string]interface{}{"$size": 2}})).To(Equal([]string{"b"}))
+		Expect(find(map[string]interface{}{"scores": map[string]interface{}{"$size": 0}})).To(Equal([]string{"c"}))
+		// The nil tags of c are not an array.
+		Expect(find(map[string]interface{}{"tags": map[string]interface{}{"$size": 0}})).To(BeEmpty())
+	})
+
+	It("Should filter with $elemMatch on scalars", func() {
+		names := find(map[string]interface{}{
+			"scores": map[string]interface{}{
+				"$elemMatch": map[string]interface{}{"$gt": 2, "$lt": 6},
+			},
+		})
+		Expect(names).To(Equal([]string{"a", "b"}))
+
+		names = find(map[string]interface{}{
+			"scores": map[string]interface{}{
+				"$elemMatch": map[string]interface{}{"$in": []interface{}{9, 10}},
+			},
+		})
+		Expect(names).To(Equal([]string{"b"}))
+	})
+
+	It("Should filter with $elemMatch on documents", func() {
+		names := find(map[string]interface{}{
+			"tags": map[string]interface{}{
+				"$elemMatch": map[string]interface{}{
+					"name":   "x",
+					"weight": map[string]interface{}{"$gte": 3},
+				},
+			},
+		})
+		Expect(names).To(Equal([]string{"b"}))
+
+		names = find(map[string]interface{}{
+			"tags": map[string]interface{}{
+				"$elemMatch": map[string]interface{}{
+					"name":   map[string]interface{}{"$like": "y%"},
+					"weight": map[string]interface{}{"$exists": false},
+				},
+			},
+		})
+		Expect(names).To(Equal([]string{"b"}))
+	})
+})
@@ -0,0 +1,33 @@
+package sqlite_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSqlite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sqlite Suite")
+}
+
+var tmpDir string
+
+var setupFailed bool = true
+
+var _ = BeforeSuite(func() {
+	tmpDir = path.Join(os.TempDir(), "dukedb_backend_sqlite_test")
+	// Ensure that tmp dir is deleted.
+	os.RemoveAll(tmpDir)
+	err := os.MkdirAll(tmpDir, 0700)
+	Expect(err).ToNot(HaveOccurred())
+
+	setupFailed = false
+})
+
+var _ = AfterSuite(func() {
+	os.RemoveAll(tmpDir)
+})
@@ -0,0 +1,357 @@
+package sqlite_test
+
+import (
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/theduke/go-apperror"
+	db "github.com/theduke/go-dukedb"
+	"github.com/theduke/go-dukedb/backends/sql"
+	"github.com/theduke/go-dukedb/backends/tests"
+	"github.com/theduke/go-dukedb/expressions"
+)
+
+func builder() (db.Backend, apperror.Error) {
+	return sql.New("sqlite3", path.Join(tmpDir, "test.db"))
+}
+
+var _ = Describe("Sqlite", func() {
+	tests.TestBackend(&setupFailed, builder)
+})
+
+var _ = Describe("Sqlite table rebuild", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		var err apperror.Error
+		backend, err = sql.New("sqlite3", path.Join(tmpDir, "rebuild.db"))
+		Expect(err).ToNot(HaveOccurred())
+
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+
+		Expect(backend.DropCollection("test_models", true, false)).ToNot(HaveOccurred())
+		Expect(backend.CreateCollection("test_models")).ToNot(HaveOccurred())
+		Expect(backend.CreateIndex("test_models", "test_models_int_val", false, "int_val")).ToNot(HaveOccurred())
+
+		model := tests.NewTestModel(1)
+		Expect(backend.Create(&model)).ToNot(HaveOccurred())
+	})
+
+	It("Should drop a field", func() {
+		Expect(backend.DropField("test_models", "str_val")).ToNot(HaveOccurred())
+
+		res, err := backend.SqlQuery("SELECT * FROM test_models")
+		Expect(err).ToNot(HaveOccurred())
+		cols, err := res.Columns()
+		res.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cols).ToNot(ContainElement("str_val"))
+		Expect(cols).To(ContainElement("int_val"))
+	})
+
+	It("Should rename a field and keep data and auto increment", func() {
+		Expect(backend.Exec(expressions.NewRenameFieldStmt("test_models", "str_val", "renamed_val"))).ToNot(HaveOccurred())
+
+		var val string
+		Expect(backend.Db.QueryRow("SELECT renamed_val FROM test_models").Scan(&val)).ToNot(HaveOccurred())
+		Expect(val).To(Equal("str1"))
+
+		_, err := backend.SqlExec("INSERT INTO test_models (renamed_val, int_val) VALUES ('x', 2)")
+		Expect(err).ToNot(HaveOccurred())
+
+		var id int
+		Expect(backend.Db.QueryRow("SELECT id FROM test_models WHERE int_val = 2").Scan(&id)).ToNot(HaveOccurred())
+		Expect(id).To(Equal(2))
+	})
+})
+
+var _ = Describe("Sqlite transactions", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		var err apperror.Error
+		backend, err = sql.New("sqlite3", path.Join(tmpDir, "nested.db"))
+		Expect(err).ToNot(HaveOccurred())
+
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+
+		Expect(backend.DropCollection("test_models", true, false)).ToNot(HaveOccurred())
+		Expect(backend.CreateCollection("test_models")).ToNot(HaveOccurred())
+	})
+
+	It("Should execute statements of a transaction in the transaction", func() {
+		tx := backend.MustBegin()
+
+		model := tests.NewTestModel(1)
+		Expect(tx.Create(&model)).ToNot(HaveOccurred())
+		Expect(tx.Rollback()).ToNot(HaveOccurred())
+
+		var count int
+		Expect(backend.Db.QueryRow("SELECT COUNT(*) FROM test_models").Scan(&count)).ToNot(HaveOccurred())
+		Expect(count).To(Equal(0))
+	})
+
+	It("Should roll back a nested transaction without affecting the outer one", func() {
+		tx := backend.MustBegin()
+
+		outer := tests.NewTestModel(1)
+		Expect(tx.Create(&outer)).ToNot(HaveOccurred())
+
+		nested, err := tx.(db.TransactionBackend).Begin()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nested.(*sql.Backend).IsNestedTransaction()).To(BeTrue())
+
+		inner := tests.NewTestModel(2)
+		Expect(nested.Create(&inner)).ToNot(HaveOccurred())
+		Expect(nested.Rollback()).ToNot(HaveOccurred())
+
+		Expect(tx.Commit()).ToNot(HaveOccurred())
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+
+		m, err := backend.FindOne("test_models", outer.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).ToNot(BeNil())
+	})
+
+	It("Should execute statements of a nested transaction in the savepoint", func() {
+		tx := backend.MustBegin()
+		defer tx.Rollback()
+
+		outer := tests.NewTestModel(1)
+		Expect(tx.Create(&outer)).ToNot(HaveOccurred())
+
+		nested := tx.(db.TransactionBackend).MustBegin()
+		inner := tests.NewTestModel(2)
+		Expect(nested.Create(&inner)).ToNot(HaveOccurred())
+		Expect(nested.Rollback()).ToNot(HaveOccurred())
+
+		m, err := tx.FindOne("test_models", inner.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).To(BeNil())
+
+		m, err = tx.FindOne("test_models", outer.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).ToNot(BeNil())
+	})
+
+	It("Should keep released nested changes when the outer transaction commits", func() {
+		tx := backend.MustBegin()
+
+		nested := tx.(db.TransactionBackend).MustBegin()
+		inner := tests.NewTestModel(1)
+		Expect(nested.Create(&inner)).ToNot(HaveOccurred())
+		Expect(nested.Commit()).ToNot(HaveOccurred())
+
+		Expect(tx.Commit()).ToNot(HaveOccurred())
+
+		m, err := backend.FindOne("test_models", inner.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).ToNot(BeNil())
+	})
+
+	It("Should discard released nested changes when the outer transaction rolls back", func() {
+		tx := backend.MustBegin()
+
+		nested := tx.(db.TransactionBackend).MustBegin()
+		inner := tests.NewTestModel(1)
+		Expect(nested.Create(&inner)).ToNot(HaveOccurred())
+		Expect(nested.Commit()).ToNot(HaveOccurred())
+
+		Expect(tx.Rollback()).ToNot(HaveOccurred())
+
+		count, err := backend.Q("test_models").Count()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(0))
+	})
+})
+
+type SqliteAuthor struct {
+	Id   uint64
+	Name string
+}
+
+type SqliteBook struct {
+	Id       uint64
+	Title    string
+	Author   *SqliteAuthor `db:"has-one:AuthorId:Id;on-delete:set-null"`
+	AuthorId uint64
+}
+
+var _ = Describe("Sqlite foreign keys", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		var err apperror.Error
+		backend, err = sql.New("sqlite3", path.Join(tmpDir, "foreign_keys.db"))
+		Expect(err).ToNot(HaveOccurred())
+
+		backend.RegisterModel(&tests.TestModel{})
+		backend.RegisterModel(&tests.TestParent{})
+		backend.RegisterModel(&SqliteAuthor{})
+		backend.RegisterModel(&SqliteBook{})
+		backend.Build()
+
+		Expect(backend.DropAllCollections()).ToNot(HaveOccurred())
+		Expect(backend.CreateCollection("test_parents", "test_models", "sqlite_authors", "sqlite_books")).ToNot(HaveOccurred())
+	})
+
+	// createJoinedItems creates a parent and a model that are joined by the m2m
+	// collection.
+	createJoinedItems := func() (*tests.TestParent, *tests.TestModel) {
+		model := tests.NewTestModel(1)
+		Expect(backend.Create(&model)).ToNot(HaveOccurred())
+		parent := &tests.TestParent{TestModel: tests.NewTestModel(2)}
+		Expect(backend.Create(parent)).ToNot(HaveOccurred())
+
+		_, err := backend.SqlExec(`INSERT INTO test_parents_test_models ("test_parents.id", "test_models.id") VALUES (?, ?)`, parent.Id, model.Id)
+		Expect(err).ToNot(HaveOccurred())
+
+		return parent, &model
+	}
+
+	countJoins := func() int {
+		var count int
+		Expect(backend.Db.QueryRow("SELECT COUNT(*) FROM test_parents_test_models").Scan(&count)).ToNot(HaveOccurred())
+		return count
+	}
+
+	It("Should create foreign keys for m2m collections", func() {
+		var createSql string
+		Expect(backend.Db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'test_parents_test_models'").Scan(&createSql)).ToNot(HaveOccurred())
+		Expect(createSql).To(ContainSubstring(`FOREIGN KEY ("test_parents.id") REFERENCES "test_parents" ("id") ON DELETE CASCADE`))
+		Expect(createSql).To(ContainSubstring(`FOREIGN KEY ("test_models.id") REFERENCES "test_models" ("id") ON DELETE CASCADE`))
+	})
+
+	It("Should enforce foreign keys", func() {
+		_, err := backend.SqlExec(`INSERT INTO test_parents_test_models ("test_parents.id", "test_models.id") VALUES (100, 200)`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should cascade deletes", func() {
+		_, model := createJoinedItems()
+		Expect(countJoins()).To(Equal(1))
+
+		Expect(backend.Delete(model)).ToNot(HaveOccurred())
+		Expect(countJoins()).To(Equal(0))
+	})
+
+	It("Should not create foreign keys for relations without a tag", func() {
+		var createSql string
+		Expect(backend.Db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'test_parents'").Scan(&createSql)).ToNot(HaveOccurred())
+		Expect(createSql).ToNot(ContainSubstring("FOREIGN KEY"))
+	})
+
+	It("Should set tagged keys to NULL when the referenced item is deleted", func() {
+		author := &SqliteAuthor{Name: "author"}
+		Expect(backend.Create(author)).ToNot(HaveOccurred())
+		book := &SqliteBook{Title: "book", AuthorId: author.Id}
+		Expect(backend.Create(book)).ToNot(HaveOccurred())
+
+		_, err := backend.SqlExec("DELETE FROM sqlite_authors WHERE id = ?", author.Id)
+		Expect(err).ToNot(HaveOccurred())
+
+		var authorId *int64
+		Expect(backend.Db.QueryRow("SELECT author_id FROM sqlite_books WHERE id = ?", book.Id).Scan(&authorId)).ToNot(HaveOccurred())
+		Expect(authorId).To(BeNil())
+	})
+
+	It("Should keep foreign keys and referencing rows when rebuilding a table", func() {
+		createJoinedItems()
+
+		Expect(backend.DropField("test_parents", "str_val")).ToNot(HaveOccurred())
+
+		// The join referencing the rebuilt table was not deleted.
+		Expect(countJoins()).To(Equal(1))
+
+		author := &SqliteAuthor{Name: "author"}
+		Expect(backend.Create(author)).ToNot(HaveOccurred())
+		book := &SqliteBook{Title: "book", AuthorId: author.Id}
+		Expect(backend.Create(book)).ToNot(HaveOccurred())
+
+		Expect(backend.DropField("sqlite_books", "title")).ToNot(HaveOccurred())
+
+		var createSql string
+		Expect(backend.Db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_books'").Scan(&createSql)).ToNot(HaveOccurred())
+		Expect(createSql).To(ContainSubstring(`FOREIGN KEY ("author_id") REFERENCES "sqlite_authors" ("id") ON DELETE SET NULL`))
+
+		// The constraint is still enforced.
+		_, err := backend.SqlExec("UPDATE sqlite_books SET author_id = 1000")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should not rebuild a referenced table in a transaction", func() {
+		tx := backend.MustBegin()
+		defer tx.Rollback()
+
+		err := tx.DropField("test_parents", "str_val")
+		Expect(err).To(HaveOccurred())
+		Expect(err.GetCode()).To(Equal("referenced_table_rebuild"))
+	})
+})
+
+var _ = Describe("Sqlite bulk create", func() {
+	var backend *sql.Backend
+
+	BeforeEach(func() {
+		if setupFailed {
+			Skip("Skipping due to previous error.")
+		}
+
+		var err apperror.Error
+		backend, err = sql.New("sqlite3", path.Join(tmpDir, "bulk.db"))
+		Expect(err).ToNot(HaveOccurred())
+
+		backend.RegisterModel(&tests.TestModel{})
+		backend.Build()
+
+		Expect(backend.DropCollection("test_models", true, false)).ToNot(HaveOccurred())
+		Expect(backend.CreateCollection("test_models")).ToNot(HaveOccurred())
+	})
+
+	It("Should use multi-row inserts for auto incremented ids", func() {
+		info := backend.ModelInfo("test_models")
+		Expect(backend.CreateBatchSize(info, len(info.Attributes()))).To(BeNumerically(">", 1))
+	})
+
+	It("Should assign the generated ids of multi-row inserts", func() {
+		first := tests.NewTestModel(1)
+		Expect(backend.Create(&first)).ToNot(HaveOccurred())
+
+		models := make([]interface{}, 0)
+		for i := 2; i <= 5; i++ {
+			m := tests.NewTestModel(i)
+			models = append(models, &m)
+		}
+		Expect(backend.Create(models...)).ToNot(HaveOccurred())
+
+		for _, m := range models {
+			model := m.(*tests.TestModel)
+			Expect(model.Id).To(Equal(first.Id + uint64(model.IntVal) - 1))
+
+			dbModel, err := backend.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*tests.TestModel).IntVal).To(Equal(model.IntVal))
+		}
+	})
+})
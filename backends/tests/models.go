@@ -92,6 +92,14 @@ type MarshalledModel struct {
 	StructPtrVal *MarshalledData        `db:"marshal"`
 }
 
+// ArrayModel holds marshalled arrays for array filters.
+type ArrayModel struct {
+	Id     uint64
+	Name   string
+	Scores []int                    `db:"marshal"`
+	Tags   []map[string]interface{} `db:"marshal"`
+}
+
 type HooksModel struct {
 	TestModel
 	CalledHooks []string `db:"-"`
@@ -151,12 +159,69 @@ type ValidationsModel struct {
 
 	ValidatedString string `db:"min:5;max:10"`
 	ValidatedInt    int    `db:"min:5;max:10"`
+
+	Email   string `db:"email"`
+	Website string `db:"url"`
+	Uuid    string `db:"uuid"`
+	Code    string `db:"pattern:^[A-Z]{3}$"`
+	Status  string `db:"enum:active,inactive"`
 }
 
 func (m *ValidationsModel) Collection() string {
 	return "validations_models"
 }
 
+// VersionedModel uses optimistic locking.
+type VersionedModel struct {
+	Id      uint64
+	Name    string
+	Version int `db:"version"`
+
+	Items []VersionedItem `db:"belongs-to:Id:VersionedModelId;auto-delete"`
+}
+
+// VersionedItem belongs to a VersionedModel.
+type VersionedItem struct {
+	Id               uint64
+	Name             string
+	VersionedModelId uint64
+}
+
+// UniqueModel has a unique field for upserts without an id.
+type UniqueModel struct {
+	Id    uint64
+	Email string `db:"unique"`
+	Name  string
+}
+
+// SoftDeletedModel is only marked as deleted by Delete().
+type SoftDeletedModel struct {
+	SoftDeleteModel
+
+	Id   uint64
+	Name string
+}
+
+// SoftDeletedRelationModel has a soft deletable parent.
+type SoftDeletedRelationModel struct {
+	Id   uint64
+	Name string
+
+	Parent   *SoftDeletedModel
+	ParentId uint64
+}
+
+// DirtyModel only writes changed fields on update.
+type DirtyModel struct {
+	DirtyTrackingModel
+
+	Id    uint64
+	Name  string
+	Count int
+	Data  []byte
+	Note  string `db:"ignore-zero"`
+}
+
 type TestParent struct {
 	TestModel
 
@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -9,10 +11,21 @@ import (
 	"github.com/theduke/go-apperror"
 
 	db "github.com/theduke/go-dukedb"
+	"github.com/theduke/go-dukedb/expressions"
 )
 
 var _ = fmt.Printf
 
+// createTestModels creates a TestModel with the given str_val for each of the
+// int values.
+func createTestModels(backend db.Backend, strVal string, intVals ...int) {
+	for _, intVal := range intVals {
+		m := NewTestModel(intVal)
+		m.StrVal = strVal
+		Expect(backend.Create(&m)).ToNot(HaveOccurred())
+	}
+}
+
 func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Error)) {
 	doSkip := false
 	var backend db.Backend
@@ -38,6 +51,13 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 		backend.RegisterModel(&HooksModel{})
 		backend.RegisterModel(&ValidationsModel{})
 		backend.RegisterModel(&MarshalledModel{})
+		backend.RegisterModel(&ArrayModel{})
+		backend.RegisterModel(&VersionedModel{})
+		backend.RegisterModel(&VersionedItem{})
+		backend.RegisterModel(&UniqueModel{})
+		backend.RegisterModel(&SoftDeletedModel{})
+		backend.RegisterModel(&SoftDeletedRelationModel{})
+		backend.RegisterModel(&DirtyModel{})
 		backend.Build()
 	})
 
@@ -56,6 +76,13 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 			"hooks_models",
 			"validations_models",
 			"marshalled_models",
+			"array_models",
+			"versioned_models",
+			"versioned_items",
+			"unique_models",
+			"soft_deleted_models",
+			"soft_deleted_relation_models",
+			"dirty_models",
 			"tags",
 			"projects",
 			"tasks",
@@ -421,896 +448,2426 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 		})
 	})
 
-	Describe("Relationships", func() {
+	Describe("Aggregation", func() {
+		It("Should compute aggregates without group by", func() {
+			createTestModels(backend, "agg1_low", 1, 2, 3)
+			createTestModels(backend, "agg1_high", 10, 20)
+
+			res, err := backend.Q("test_models").
+				Filter("str_val", "agg1_low").
+				Aggregate("total", expressions.Sum("int_val")).
+				Aggregate("count", expressions.Count("*")).
+				Aggregate("min", expressions.Min("int_val")).
+				Aggregate("max", expressions.Max("int_val")).
+				Pluck()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0]["total"]).To(BeEquivalentTo(6))
+			Expect(res[0]["count"]).To(BeEquivalentTo(3))
+			Expect(res[0]["min"]).To(BeEquivalentTo(1))
+			Expect(res[0]["max"]).To(BeEquivalentTo(3))
+		})
 
-		BeforeEach(func() {
-			// Clear models.
-			Expect(backend.Q("tags").Delete()).ToNot(HaveOccurred())
-			Expect(backend.Q("projects").Delete()).ToNot(HaveOccurred())
-			Expect(backend.Q("tasks").Delete()).ToNot(HaveOccurred())
-			Expect(backend.Q("files").Delete()).ToNot(HaveOccurred())
-			Expect(backend.Q("tasks_tags").Delete()).ToNot(HaveOccurred())
+		It("Should group by field", func() {
+			createTestModels(backend, "agg2_low", 1, 2, 3)
+			createTestModels(backend, "agg2_high", 10, 20)
+
+			res, err := backend.Q("test_models").
+				Filter("str_val", "agg2_low").
+				Or("str_val", "agg2_high").
+				GroupBy("str_val").
+				Aggregate("total", expressions.Sum("int_val")).
+				Aggregate("average", expressions.Avg("int_val")).
+				Sort("str_val", true).
+				Pluck()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
 
-			// Rebuild relation info.
-			backend.Build()
+			Expect(res[0]["str_val"]).To(BeEquivalentTo("agg2_high"))
+			Expect(res[0]["total"]).To(BeEquivalentTo(30))
+			Expect(res[0]["average"]).To(BeEquivalentTo(15))
+
+			Expect(res[1]["str_val"]).To(BeEquivalentTo("agg2_low"))
+			Expect(res[1]["total"]).To(BeEquivalentTo(6))
+			Expect(res[1]["average"]).To(BeEquivalentTo(2))
 		})
 
-		Describe("Has one", func() {
-			It("Should ignore unpersisted has-one", func() {
-				t := &Task{
-					Name:    "P1",
-					Project: Project{Name: "x"},
-				}
+		It("Should filter groups with having", func() {
+			createTestModels(backend, "agg3_low", 1, 2, 3)
+			createTestModels(backend, "agg3_high", 10, 20)
+
+			res, err := backend.Q("test_models").
+				Filter("str_val", "agg3_low").
+				Or("str_val", "agg3_high").
+				GroupBy("str_val").
+				Aggregate("total", expressions.Sum("int_val")).
+				HavingCond("total", ">", 10).
+				Pluck()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0]["str_val"]).To(BeEquivalentTo("agg3_high"))
+		})
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(backend.Q("projects").Count()).To(Equal(0))
-			})
+		It("Should sort by aggregate", func() {
+			createTestModels(backend, "agg4_low", 1, 2, 3)
+			createTestModels(backend, "agg4_high", 10, 20)
+
+			res, err := backend.Q("test_models").
+				Filter("str_val", "agg4_low").
+				Or("str_val", "agg4_high").
+				GroupBy("str_val").
+				Aggregate("count", expressions.Count("*")).
+				Sort("count", false).
+				Pluck()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+			Expect(res[0]["str_val"]).To(BeEquivalentTo("agg4_low"))
+			Expect(res[0]["count"]).To(BeEquivalentTo(3))
+		})
+	})
 
-			It("Should set key for has-one relationship", func() {
-				p := &Project{Name: "P1"}
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+	Describe("Subqueries", func() {
+		It("Should filter with IN sub query", func() {
+			createTestModels(backend, "sub1_in", 401)
+			createTestModels(backend, "sub1_other", 401, 402)
 
-				t := &Task{
-					Name:    "T1",
-					Project: *p,
-				}
+			sub := backend.Q("test_models").Filter("str_val", "sub1_in").Field("int_val")
+			res, err := backend.Q("test_models").FilterCond("int_val", "in", sub).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+			Expect(res[0].(*TestModel).IntVal).To(Equal(int64(401)))
+			Expect(res[1].(*TestModel).IntVal).To(Equal(int64(401)))
+		})
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(t.ProjectId).To(Equal(p.Id))
+		It("Should filter with NOT IN sub query", func() {
+			createTestModels(backend, "sub2_in", 403)
+			createTestModels(backend, "sub2_other", 403, 404)
 
-				dbTask, err := backend.FindOne("tasks", t.Id)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(dbTask.(*Task).ProjectId).To(Equal(p.Id))
-			})
+			sub := backend.Q("test_models").Filter("str_val", "sub2_in").Field("int_val")
+			res, err := backend.Q("test_models").Filter("str_val", "sub2_other").FilterCond("int_val", "not in", sub).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*TestModel).IntVal).To(Equal(int64(404)))
+		})
 
-			It("Should auto-persist has-one", func() {
-				// Enable auto-create.
-				backend.ModelInfo("tasks").Relation("Project").SetAutoCreate(true)
+		It("Should filter with correlated EXISTS sub query", func() {
+			p1 := &Project{Name: "sub_p1"}
+			p2 := &Project{Name: "sub_p2"}
+			Expect(backend.Create(p1)).ToNot(HaveOccurred())
+			Expect(backend.Create(p2)).ToNot(HaveOccurred())
+			Expect(backend.Create(&Task{Name: "sub_t1", ProjectId: p1.Id})).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+			sub := backend.Q("tasks").FilterExpr(expressions.NewFilter(
+				expressions.NewColFieldIdExpr("tasks", "project_id"),
+				expressions.OPERATOR_EQ,
+				expressions.NewColFieldIdExpr("projects", "id")))
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(t.Project.Id).ToNot(BeZero())
+			res, err := backend.Q("projects").Filter("name", "sub_p1").Or("name", "sub_p2").FilterExists(sub).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*Project).Id).To(Equal(p1.Id))
 
-				m, err := backend.FindOne("projects", t.Project.Id)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(m).ToNot(BeNil())
+			sub = backend.Q("tasks").FilterExpr(expressions.NewFilter(
+				expressions.NewColFieldIdExpr("tasks", "project_id"),
+				expressions.OPERATOR_EQ,
+				expressions.NewColFieldIdExpr("projects", "id")))
 
-				tm, err := backend.FindOne("tasks", t.Id)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(tm).ToNot(BeNil())
-				Expect(tm.(*Task).ProjectId).To(Equal(t.Project.Id))
-			})
+			res, err = backend.Q("projects").Filter("name", "sub_p1").Or("name", "sub_p2").FilterNotExists(sub).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*Project).Id).To(Equal(p2.Id))
+		})
 
-			It("Should auto-update has-one", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
-				rel.SetAutoUpdate(true)
+		It("Should combine EXISTS sub queries", func() {
+			createTestModels(backend, "sub3", 405, 406)
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+			exists := backend.Q("test_models").Filter("str_val", "sub3").Filter("int_val", 405)
+			missing := backend.Q("test_models").Filter("str_val", "sub3").Filter("int_val", 407)
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			res, err := backend.Q("test_models").Filter("str_val", "sub3").FilterExists(exists).FilterNotExists(missing).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
 
-				t.Project.Name = "NewName"
-				Expect(backend.Update(t)).ToNot(HaveOccurred())
+			missing = backend.Q("test_models").Filter("str_val", "sub3").Filter("int_val", 407)
+			res, err = backend.Q("test_models").Filter("str_val", "sub3").FilterExists(missing).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(0))
+		})
+	})
 
-				m, _ := backend.FindOne("projects", t.Project.Id)
-				Expect(m.(*Project).Name).To(Equal("NewName"))
-			})
+	Describe("Filter operators", func() {
+		It("Should filter with between", func() {
+			createTestModels(backend, "op_between", 501, 502, 503, 504)
 
-			It("Should auto-delete has-one", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
-				rel.SetAutoDelete(true)
+			res, err := backend.Q("test_models").FilterCond("int_val", "between", []interface{}{502, 503}).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+		})
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+		It("Should filter with not like", func() {
+			createTestModels(backend, "op_nlike_Val0", 511, 513, 514)
+			createTestModels(backend, "op_nlike_Val1", 512)
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+			res, err := backend.Q("test_models").
+				FilterCond("int_val", "between", []interface{}{511, 514}).
+				FilterCond("str_val", "not like", "op_nlike_Val1").
+				Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(3))
+		})
 
-				Expect(backend.FindOne("projects", t.Project.Id)).To(BeNil())
-			})
+		It("Should filter with ilike", func() {
+			createTestModels(backend, "op_ilike_Val", 521, 522, 523, 524)
 
-			It("Should not delete with auto-delete disabled", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
+			res, err := backend.Q("test_models").FilterCond("str_val", "ilike", "OP_ILIKE_val%").Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(4))
+		})
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+		It("Should filter with regex", func() {
+			createTestModels(backend, "op_regex_Val0", 491, 494)
+			createTestModels(backend, "op_regex_Val1", 492)
+			createTestModels(backend, "op_regex_Val2", 493)
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+			res, err := backend.Q("test_models").FilterCond("str_val", "regex", "^op_regex_Val[12]$").Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+		})
 
-				Expect(backend.FindOne("projects", t.Project.Id)).ToNot(BeNil())
-			})
+		It("Should filter with is null and is not null", func() {
+			now := time.Now()
+			Expect(backend.Create(&Project{Name: "op_null", Description: "op_null"})).ToNot(HaveOccurred())
+			Expect(backend.Create(&Project{Name: "op_not_null", Description: "op_null", UpdatedAt: &now})).ToNot(HaveOccurred())
 
-			It("Should join has-one", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
+			res, err := backend.Q("projects").Filter("description", "op_null").FilterCond("updated_at", "is null", nil).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*Project).Name).To(Equal("op_null"))
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+			res, err = backend.Q("projects").Filter("description", "op_null").FilterCond("updated_at", "is not null", nil).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*Project).Name).To(Equal("op_not_null"))
+		})
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+		It("Should parse $between and $exists", func() {
+			createTestModels(backend, "op_parse", 531, 532, 533, 534)
 
-				m, err := backend.Q("tasks").Filter("id", t.Id).Join("Project").First()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*Task).Project.Id).To(Equal(t.Project.Id))
+			q, err := db.ParseQuery(backend, map[string]interface{}{
+				"collection": "test_models",
+				"filters": map[string]interface{}{
+					"int_val": map[string]interface{}{"$between": []interface{}{531, 532}},
+					"str_val": map[string]interface{}{"$exists": true},
+				},
 			})
+			Expect(err).ToNot(HaveOccurred())
 
-			It("Should .Related() with model", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
+			res, err := q.Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+		})
+	})
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
-					},
-				}
+	Describe("Array filters", func() {
+		// find returns the names of the matching models of a prefix, or false
+		// if the backend does not support array filters.
+		var find = func(prefix string, filters map[string]interface{}) ([]string, bool) {
+			filters["name"] = map[string]interface{}{"$in": []interface{}{prefix + "_a", prefix + "_b", prefix + "_c"}}
+			q, err := db.ParseQuery(backend, map[string]interface{}{
+				"collection": "array_models",
+				"filters":    filters,
+				"order":      "name",
+			})
+			Expect(err).ToNot(HaveOccurred())
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			res, err := q.Find()
+			if err != nil {
+				// Backends that do not support array filters must reject
+				// them with a public error.
+				Expect(err.GetCode()).To(Equal("unsupported_operator"))
+				Expect(err.(*apperror.Err).Public).To(BeTrue())
+				return nil, false
+			}
 
-				m, err := backend.Q(t).Related("Project").First()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*Project).Id).To(Equal(t.Project.Id))
+			names := make([]string, 0)
+			for _, item := range res {
+				names = append(names, item.(*ArrayModel).Name)
+			}
+			return names, true
+		}
+
+		It("Should filter with $size", func() {
+			Expect(backend.Create(&ArrayModel{Name: "size_a", Scores: []int{1, 2, 3}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "size_b", Scores: []int{5, 9}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "size_c", Scores: []int{}})).ToNot(HaveOccurred())
+
+			names, ok := find("size", map[string]interface{}{"scores": map[string]interface{}{"$size": 2}})
+			if !ok {
+				return
+			}
+			Expect(names).To(Equal([]string{"size_b"}))
+
+			names, _ = find("size", map[string]interface{}{"scores": map[string]interface{}{"$size": 0}})
+			Expect(names).To(Equal([]string{"size_c"}))
+		})
+
+		It("Should filter with $elemMatch on scalars", func() {
+			Expect(backend.Create(&ArrayModel{Name: "elem_scalar_a", Scores: []int{1, 2, 3}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "elem_scalar_b", Scores: []int{5, 9}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "elem_scalar_c", Scores: []int{}})).ToNot(HaveOccurred())
+
+			names, ok := find("elem_scalar", map[string]interface{}{
+				"scores": map[string]interface{}{
+					"$elemMatch": map[string]interface{}{"$gt": 2, "$lt": 6},
+				},
 			})
+			if ok {
+				Expect(names).To(Equal([]string{"elem_scalar_a", "elem_scalar_b"}))
+			}
+		})
 
-			It("Should .Related() with id", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("Project")
-				rel.SetAutoCreate(true)
+		It("Should filter with $elemMatch on documents", func() {
+			Expect(backend.Create(&ArrayModel{Name: "elem_doc_a", Tags: []map[string]interface{}{{"name": "x", "weight": 1}}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "elem_doc_b", Tags: []map[string]interface{}{{"name": "x", "weight": 5}, {"name": "y", "weight": 2}}})).ToNot(HaveOccurred())
+			Expect(backend.Create(&ArrayModel{Name: "elem_doc_c"})).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "T1",
-					Project: Project{
-						Name: "test",
+			names, ok := find("elem_doc", map[string]interface{}{
+				"tags": map[string]interface{}{
+					"$elemMatch": map[string]interface{}{
+						"name":   "x",
+						"weight": map[string]interface{}{"$gte": 3},
 					},
-				}
+				},
+			})
+			if ok {
+				Expect(names).To(Equal([]string{"elem_doc_b"}))
+			}
+		})
+	})
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+	Describe("Query parser operators", func() {
+		var find = func(filters map[string]interface{}) ([]interface{}, apperror.Error) {
+			q, err := db.ParseQuery(backend, map[string]interface{}{
+				"collection": "test_models",
+				"filters":    filters,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return q.Find()
+		}
 
-				m, err := backend.Q("tasks", t.Id).Related("Project").First()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*Project).Id).To(Equal(t.Project.Id))
+		It("Should parse $and", func() {
+			createTestModels(backend, "parse_and", 541, 542, 543, 544)
+
+			res, err := find(map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"int_val": map[string]interface{}{"$gte": 542}},
+					map[string]interface{}{"int_val": map[string]interface{}{"$lte": 543}},
+				},
 			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
 		})
 
-		Describe("Belongs to", func() {
-			It("Should ignore unpersisted belongs-to", func() {
-				t := &Task{
-					Name: "P1",
-					File: &File{Filename: "file.txt"},
-				}
+		It("Should parse $nor", func() {
+			createTestModels(backend, "parse_nor_val0", 551, 552, 554)
+			createTestModels(backend, "parse_nor_val2", 553)
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(backend.Q("files").Count()).To(Equal(0))
+			res, err := find(map[string]interface{}{
+				"int_val": map[string]interface{}{"$between": []interface{}{551, 554}},
+				"$nor": []interface{}{
+					map[string]interface{}{"int_val": 551},
+					map[string]interface{}{"str_val": "parse_nor_val2"},
+				},
 			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
+		})
 
-			It("Should set key for belongs-to relationship", func() {
-				f := &File{Filename: "file.txt"}
-				Expect(backend.Create(f)).ToNot(HaveOccurred())
+		It("Should parse $not", func() {
+			createTestModels(backend, "parse_not_val0", 561)
+			createTestModels(backend, "parse_not_val1", 562, 563, 564)
 
-				t := &Task{
-					Name: "T1",
-					File: f,
-				}
+			res, err := find(map[string]interface{}{
+				"int_val": map[string]interface{}{
+					"$between": []interface{}{561, 564},
+					"$not":     map[string]interface{}{"$gt": 562},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(2))
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(f.TaskId).To(Equal(t.Id))
+			res, err = find(map[string]interface{}{
+				"int_val": map[string]interface{}{"$between": []interface{}{561, 564}},
+				"$not":    map[string]interface{}{"str_val": "parse_not_val0"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(3))
+		})
 
-				dbFile, err := backend.FindOne("files", f.Id)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(dbFile.(*File).TaskId).To(Equal(t.Id))
+		It("Should return errors naming the invalid path", func() {
+			_, err := find(map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"int_val": map[string]interface{}{"$size": "x"}},
+				},
 			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_size_value"))
+			Expect(err.Error()).To(ContainSubstring("filters.$or[0].int_val.$size"))
+			Expect(err.(*apperror.Err).Public).To(BeTrue())
 
-			It("Should auto-persist belongs-to", func() {
-				// Enable auto-create.
-				backend.ModelInfo("tasks").Relation("File").SetAutoCreate(true)
+			_, err = find(map[string]interface{}{"int_val": map[string]interface{}{"$foo": 1}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("unknown_filter_operator"))
 
-				t := &Task{
-					Name: "T1",
-					File: &File{
-						Filename: "test",
-					},
-				}
+			_, err = find(map[string]interface{}{"$nor": map[string]interface{}{}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_nor_data"))
+		})
 
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(t.File.Id).ToNot(BeZero())
+		It("Should reject joined fields in $or, $nor and $not", func() {
+			for _, filters := range []map[string]interface{}{
+				{"$not": map[string]interface{}{"Todos.name": "x"}},
+				{"$nor": []interface{}{map[string]interface{}{"Todos.name": "x"}}},
+				{"$or": []interface{}{map[string]interface{}{"name": "x"}, map[string]interface{}{"Todos.name": "x"}}},
+			} {
+				_, err := db.ParseQuery(backend, map[string]interface{}{
+					"collection": "projects",
+					"joins":      []interface{}{"Todos"},
+					"filters":    filters,
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.GetCode()).To(Equal("invalid_join_filter"))
+			}
 
-				m, err := backend.FindOne("files", t.File.Id)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(m).ToNot(BeNil())
-				Expect(m.(*File).TaskId).To(Equal(t.Id))
+			q, err := db.ParseQuery(backend, map[string]interface{}{
+				"collection": "projects",
+				"joins":      []interface{}{"Todos"},
+				"filters":    map[string]interface{}{"$and": []interface{}{map[string]interface{}{"Todos.name": "x"}}},
 			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(q.GetJoin("Todos")).ToNot(BeNil())
+		})
+	})
 
-			It("Should auto-update belongs-to", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
-				rel.SetAutoCreate(true)
-				rel.SetAutoUpdate(true)
+	Describe("Query serialization", func() {
+		// roundTrip serializes the query to json, parses it and checks that
+		// the parsed query serializes to the same json.
+		var roundTrip = func(q *db.Query) *db.Query {
+			js, err := q.ToJson()
+			Expect(err).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "T1",
-					File: &File{
-						Filename: "test",
+			parsed, err := db.ParseJsonQuery(backend, js)
+			Expect(err).ToNot(HaveOccurred())
+
+			parsedJs, err := parsed.ToJson()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(parsedJs)).To(Equal(string(js)))
+
+			return parsed
+		}
+
+		It("Should serialize to the query format", func() {
+			q := backend.Q("test_models").
+				Filter("str_val", "x").
+				FilterCond("int_val", ">", 5).
+				Field("int_val", "str_val").
+				Sort("int_val", false).
+				Limit(10).
+				Offset(2)
+
+			data, err := db.QueryToMap(q)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(map[string]interface{}{
+				"collection": "test_models",
+				"filters": map[string]interface{}{
+					"str_val": "x",
+					"int_val": map[string]interface{}{"$gt": 5},
+				},
+				"fields": []interface{}{"int_val", "str_val"},
+				"order":  []interface{}{"-int_val"},
+				"limit":  10,
+				"offset": 2,
+			}))
+		})
+
+		It("Should round trip filters", func() {
+			for i := 0; i < 4; i++ {
+				m := NewTestModel(571 + i)
+				m.StrVal = fmt.Sprintf("serialize_val%v", i)
+				Expect(backend.Create(&m)).ToNot(HaveOccurred())
+			}
+
+			q := backend.Q("test_models").
+				FilterCond("int_val", "between", []interface{}{571, 574}).
+				FilterCond("str_val", "not like", "%val3").
+				OrCond("int_val", "=", 571).
+				OrCond("int_val", "in", []interface{}{572, 573}).
+				NotCond("str_val", "=", "serialize_val2").
+				FilterCond("str_val", "is not null", nil).
+				Sort("int_val", true)
+
+			parsed := roundTrip(q)
+
+			expected, err := q.Find()
+			Expect(err).ToNot(HaveOccurred())
+			res, err := parsed.Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(len(expected)))
+		})
+
+		It("Should round trip joins", func() {
+			q := backend.Q("projects").
+				Filter("name", "x").
+				Join("Todos").
+				FilterCond("Todos.priority", ">=", 2)
+
+			parsed := roundTrip(q)
+			Expect(parsed.GetJoin("Todos")).ToNot(BeNil())
+
+			data, err := db.QueryToMap(parsed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data["joins"]).To(Equal([]interface{}{"Todos"}))
+			Expect(data["filters"]).To(HaveKey("Todos.priority"))
+		})
+
+		It("Should round trip soft delete flags", func() {
+			m := &SoftDeletedModel{Name: "serialize_deleted"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
+
+			data, err := db.QueryToMap(backend.Q("soft_deleted_models").WithDeleted())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data["withDeleted"]).To(Equal(true))
+
+			parsed := roundTrip(backend.Q("soft_deleted_models").Filter("name", "serialize_deleted").OnlyDeleted())
+			Expect(parsed.Count()).To(Equal(1))
+
+			parsed = roundTrip(backend.Q("soft_deleted_models").Filter("name", "serialize_deleted").WithDeleted())
+			Expect(parsed.Count()).To(Equal(1))
+
+			parsed = roundTrip(backend.Q("soft_deleted_models").Filter("name", "serialize_deleted"))
+			Expect(parsed.Count()).To(Equal(0))
+
+			_, err = db.ParseQuery(backend, map[string]interface{}{
+				"collection":  "soft_deleted_models",
+				"withDeleted": true,
+				"onlyDeleted": true,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_deleted_flags"))
+		})
+
+		It("Should fail for expressions that can not be represented", func() {
+			sub := backend.Q("test_models").Field("int_val")
+			_, err := db.QueryToMap(backend.Q("test_models").FilterCond("int_val", "in", sub))
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("unsupported_expression"))
+
+			_, err = db.QueryToMap(backend.Q("test_models").Aggregate("total", expressions.Count("")))
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("unsupported_expression"))
+
+			q := backend.Q("soft_deleted_relation_models").Join("Parent")
+			q.GetJoin("Parent").WithDeleted()
+			_, err = db.QueryToMap(q)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("unsupported_expression"))
+		})
+	})
+
+	Describe("Keyset pagination", func() {
+		var ids = func(items []interface{}) []uint64 {
+			ids := make([]uint64, 0)
+			for _, item := range items {
+				ids = append(ids, item.(*TestModel).Id)
+			}
+			return ids
+		}
+
+		var query = func(base int) *db.Query {
+			return backend.Q("test_models").
+				FilterCond("int_val", "between", []interface{}{base, base + 3}).
+				Sort("int_val", false).
+				Sort("str_val", true)
+		}
+
+		It("Should paginate forwards and backwards", func() {
+			// Duplicate values test the primary key tie-breaker.
+			createTestModels(backend, "page_0", 581, 582, 583, 584)
+			createTestModels(backend, "page_1", 582, 583, 583)
+
+			all, err := query(581).Sort("id", true).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(all).To(HaveLen(7))
+
+			pages := make([][]uint64, 0)
+			cursor := ""
+			for {
+				q := query(581)
+				if cursor != "" {
+					q.After(cursor)
+				}
+				page, err := q.Paginate(3)
+				Expect(err).ToNot(HaveOccurred())
+				pages = append(pages, ids(page.Items))
+
+				if len(pages) == 1 {
+					Expect(page.PrevCursor).To(BeEmpty())
+				} else {
+					Expect(page.PrevCursor).ToNot(BeEmpty())
+				}
+
+				if !page.HasMore {
+					Expect(page.NextCursor).To(BeEmpty())
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			Expect(pages).To(HaveLen(3))
+			Expect(append(append(pages[0], pages[1]...), pages[2]...)).To(Equal(ids(all)))
+
+			// Go back from the last page.
+			lastQ := query(581).After(cursor)
+			last, err := lastQ.Paginate(3)
+			Expect(err).ToNot(HaveOccurred())
+
+			prev, err := query(581).Before(last.PrevCursor).Paginate(3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids(prev.Items)).To(Equal(pages[1]))
+			Expect(prev.HasMore).To(BeTrue())
+			Expect(prev.NextCursor).ToNot(BeEmpty())
+
+			first, err := query(581).Before(prev.PrevCursor).Paginate(3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids(first.Items)).To(Equal(pages[0]))
+			Expect(first.HasMore).To(BeFalse())
+			Expect(first.PrevCursor).To(BeEmpty())
+		})
+
+		It("Should reject invalid cursors", func() {
+			createTestModels(backend, "page_0", 591, 592, 593, 594)
+			createTestModels(backend, "page_1", 592, 593, 593)
+
+			_, err := query(591).After("invalid").Paginate(3)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_cursor"))
+
+			page, err := query(591).Paginate(3)
+			Expect(err).ToNot(HaveOccurred())
+
+			// A cursor for different sorts can't be used.
+			_, err = backend.Q("test_models").Sort("str_val", true).After(page.NextCursor).Paginate(3)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_cursor"))
+		})
+	})
+
+	Describe("Query policy", func() {
+		var policy *db.QueryPolicy
+
+		BeforeEach(func() {
+			policy = &db.QueryPolicy{
+				Collections: map[string]*db.CollectionPolicy{
+					"projects": &db.CollectionPolicy{
+						Fields:          []string{"id", "name", "description"},
+						SensitiveFields: []string{"description"},
+						Joins:           []string{"Todos"},
+						Filters:         map[string]interface{}{"description": "policy_tenant"},
 					},
+					"tasks": &db.CollectionPolicy{},
+				},
+				MaxLimit:     10,
+				MaxJoinDepth: 1,
+			}
+		})
+
+		var parse = func(data map[string]interface{}) (*db.Query, apperror.Error) {
+			return db.ParseQueryWithPolicy(backend, data, policy)
+		}
+
+		var expectViolation = func(code string, data map[string]interface{}) {
+			_, err := parse(data)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal(code))
+			Expect(err.(*apperror.Err).Public).To(BeTrue())
+		}
+
+		It("Should reject collections that are not whitelisted", func() {
+			expectViolation("collection_not_allowed", map[string]interface{}{"collection": "test_models"})
+		})
+
+		It("Should reject fields that are not whitelisted", func() {
+			expectViolation("field_not_allowed", map[string]interface{}{
+				"collection": "projects",
+				"fields":     []interface{}{"name", "created_at"},
+			})
+			expectViolation("field_not_allowed", map[string]interface{}{
+				"collection": "projects",
+				"filters":    map[string]interface{}{"$or": []interface{}{map[string]interface{}{"created_at": 1}}},
+			})
+		})
+
+		It("Should reject filters and orders on sensitive fields", func() {
+			expectViolation("forbidden_filter_field", map[string]interface{}{
+				"collection": "projects",
+				"filters":    map[string]interface{}{"description": "x"},
+			})
+			expectViolation("forbidden_filter_field", map[string]interface{}{
+				"collection": "projects",
+				"order":      "-description",
+			})
+		})
+
+		It("Should reject joins that are not whitelisted or too deep", func() {
+			expectViolation("join_not_allowed", map[string]interface{}{
+				"collection": "projects",
+				"joins":      []interface{}{"ArchviedTodos"},
+			})
+			expectViolation("join_depth_exceeded", map[string]interface{}{
+				"collection": "projects",
+				"joins":      []interface{}{"Todos", "Todos.Project"},
+			})
+		})
+
+		It("Should reject fields on relations that were not joined", func() {
+			expectViolation("join_not_allowed", map[string]interface{}{
+				"collection": "tasks",
+				"order":      "Project.description",
+			})
+			expectViolation("join_not_allowed", map[string]interface{}{
+				"collection": "tasks",
+				"fields":     []interface{}{"name", "Project.name"},
+			})
+			expectViolation("join_not_allowed", map[string]interface{}{
+				"collection": "tasks",
+				"filters":    map[string]interface{}{"Project.description": "x"},
+			})
+			expectViolation("join_not_allowed", map[string]interface{}{
+				"collection": "projects",
+				"joins":      []interface{}{"Todos"},
+				"order":      "Todos.Project.name",
+			})
+		})
+
+		It("Should cap the limit", func() {
+			expectViolation("limit_exceeded", map[string]interface{}{
+				"collection": "projects",
+				"limit":      11,
+			})
+
+			q, err := parse(map[string]interface{}{"collection": "projects"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(q.GetLimit()).To(Equal(10))
+		})
+
+		It("Should add mandatory filters", func() {
+			Expect(backend.Create(&Project{Name: "policy_project", Description: "policy_tenant"})).ToNot(HaveOccurred())
+			Expect(backend.Create(&Project{Name: "policy_project", Description: "policy_other"})).ToNot(HaveOccurred())
+
+			q, err := parse(map[string]interface{}{
+				"collection": "projects",
+				"filters":    map[string]interface{}{"name": "policy_project"},
+				"joins":      []interface{}{"Todos"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := q.Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].(*Project).Description).To(Equal("policy_tenant"))
+		})
+
+		It("Should only select whitelisted fields by default", func() {
+			Expect(backend.Create(&Project{Name: "policy_fields", Description: "policy_tenant", CreatedAt: time.Now()})).ToNot(HaveOccurred())
+
+			q, err := parse(map[string]interface{}{
+				"collection": "projects",
+				"filters":    map[string]interface{}{"name": "policy_fields"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := q.Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(1))
+			project := res[0].(*Project)
+			Expect(project.Id).ToNot(BeZero())
+			Expect(project.Name).To(Equal("policy_fields"))
+			Expect(project.CreatedAt.IsZero()).To(BeTrue())
+		})
+	})
+
+	Describe("Cursor", func() {
+		It("Should iterate over all results", func() {
+			for i := 0; i < 5; i++ {
+				m := NewTestModel(200)
+				Expect(backend.Create(&m)).ToNot(HaveOccurred())
+			}
+
+			cursor, err := backend.Q("test_models").Filter("int_val", 200).Cursor()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cursor.Count()).To(Equal(5))
+
+			count := 0
+			for cursor.HasNext() {
+				m, err := cursor.Next()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*TestModel).IntVal).To(Equal(int64(200)))
+				count++
+			}
+			Expect(count).To(Equal(5))
+
+			m, err := cursor.Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeNil())
+		})
+
+		It("Should fill target model", func() {
+			m := NewTestModel(201)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
+
+			cursor, err := backend.Q("test_models").Filter("int_val", 201).Cursor()
+			Expect(err).ToNot(HaveOccurred())
+
+			var target TestModel
+			_, err = cursor.Next(&target)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Id).To(Equal(m.Id))
+			Expect(cursor.Close()).ToNot(HaveOccurred())
+		})
+
+		It("Should call AfterQuery hook", func() {
+			m := &HooksModel{}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			m.CalledHooks = nil
+
+			cursor, err := backend.Q("hooks_models").Filter("id", m.Id).Cursor()
+			Expect(err).ToNot(HaveOccurred())
+
+			m2, err := cursor.Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m2.(*HooksModel).CalledHooks).To(Equal([]string{"after_query"}))
+			Expect(cursor.Close()).ToNot(HaveOccurred())
+		})
+
+		It("Should join has-many", func() {
+			rel := backend.ModelInfo("projects").Relation("Todos")
+			rel.SetAutoCreate(true)
+
+			p1 := &Project{Name: "CursorP", Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}}}
+			p2 := &Project{Name: "CursorP", Todos: []Task{Task{Name: "T3"}}}
+			Expect(backend.Create(p1)).ToNot(HaveOccurred())
+			Expect(backend.Create(p2)).ToNot(HaveOccurred())
+
+			cursor, err := backend.Q("projects").Filter("name", "CursorP").Join("Todos").Cursor()
+			Expect(err).ToNot(HaveOccurred())
+
+			todos := 0
+			for cursor.HasNext() {
+				p, err := cursor.Next()
+				Expect(err).ToNot(HaveOccurred())
+				todos += len(p.(*Project).Todos)
+			}
+			Expect(todos).To(Equal(3))
+		})
+
+		It("Should join in transactions with multiple batches", func() {
+			txBackend, ok := backend.(db.TransactionBackend)
+			if !ok {
+				Skip("Not a transaction backend")
+			}
+			backend.ModelInfo("projects").Relation("Todos").SetAutoCreate(true)
+
+			count := db.CURSOR_BATCH_SIZE + 10
+			for i := 0; i < count; i++ {
+				p := &Project{Name: "CursorTx", Todos: []Task{Task{Name: "T"}}}
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+			}
+
+			err := db.WithTransaction(txBackend, func(tx db.Transaction) error {
+				cursor, err := tx.Q("projects").Filter("name", "CursorTx").Join("Todos").Cursor()
+				if err != nil {
+					return err
+				}
+
+				projects, todos := 0, 0
+				for cursor.HasNext() {
+					p, err := cursor.Next()
+					if err != nil {
+						return err
+					}
+					projects++
+					todos += len(p.(*Project).Todos)
+				}
+				Expect(projects).To(Equal(count))
+				Expect(todos).To(Equal(count))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Relationships", func() {
+
+		BeforeEach(func() {
+			// Clear models.
+			Expect(backend.Q("tags").Delete()).ToNot(HaveOccurred())
+			Expect(backend.Q("projects").Delete()).ToNot(HaveOccurred())
+			Expect(backend.Q("tasks").Delete()).ToNot(HaveOccurred())
+			Expect(backend.Q("files").Delete()).ToNot(HaveOccurred())
+			Expect(backend.Q("tasks_tags").Delete()).ToNot(HaveOccurred())
+
+			// Rebuild relation info.
+			backend.Build()
+		})
+
+		Describe("Has one", func() {
+			It("Should ignore unpersisted has-one", func() {
+				t := &Task{
+					Name:    "P1",
+					Project: Project{Name: "x"},
 				}
 
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Q("projects").Count()).To(Equal(0))
+			})
 
-				t.File.Filename = "NewName"
-				Expect(backend.Update(t)).ToNot(HaveOccurred())
+			It("Should set key for has-one relationship", func() {
+				p := &Project{Name: "P1"}
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
 
-				m, _ := backend.FindOne("files", t.File.Id)
-				Expect(m.(*File).Filename).To(Equal("NewName"))
+				t := &Task{
+					Name:    "T1",
+					Project: *p,
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(t.ProjectId).To(Equal(p.Id))
+
+				dbTask, err := backend.FindOne("tasks", t.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dbTask.(*Task).ProjectId).To(Equal(p.Id))
 			})
 
-			It("Should auto-delete belongs-to", func() {
+			It("Should auto-persist has-one", func() {
 				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
-				rel.SetAutoCreate(true)
-				rel.SetAutoDelete(true)
+				backend.ModelInfo("tasks").Relation("Project").SetAutoCreate(true)
 
 				t := &Task{
 					Name: "T1",
-					File: &File{
-						Filename: "test",
+					Project: Project{
+						Name: "test",
 					},
 				}
 
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+				Expect(t.Project.Id).ToNot(BeZero())
 
-				Expect(backend.Q("files").Count()).To(Equal(0))
+				m, err := backend.FindOne("projects", t.Project.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m).ToNot(BeNil())
+
+				tm, err := backend.FindOne("tasks", t.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tm).ToNot(BeNil())
+				Expect(tm.(*Task).ProjectId).To(Equal(t.Project.Id))
 			})
 
-			It("Should not delete with auto-delete disabled", func() {
+			It("Should auto-update has-one", func() {
 				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
+				rel := backend.ModelInfo("tasks").Relation("Project")
 				rel.SetAutoCreate(true)
+				rel.SetAutoUpdate(true)
 
 				t := &Task{
 					Name: "T1",
-					File: &File{
-						Filename: "test",
+					Project: Project{
+						Name: "test",
 					},
 				}
 
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				t.Project.Name = "NewName"
+				Expect(backend.Update(t)).ToNot(HaveOccurred())
+
+				m, _ := backend.FindOne("projects", t.Project.Id)
+				Expect(m.(*Project).Name).To(Equal("NewName"))
+			})
+
+			It("Should auto-delete has-one", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("Project")
+				rel.SetAutoCreate(true)
+				rel.SetAutoDelete(true)
+
+				t := &Task{
+					Name: "T1",
+					Project: Project{
+						Name: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+
+				Expect(backend.FindOne("projects", t.Project.Id)).To(BeNil())
+			})
+
+			It("Should not delete with auto-delete disabled", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("Project")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					Project: Project{
+						Name: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+
+				Expect(backend.FindOne("projects", t.Project.Id)).ToNot(BeNil())
+			})
+
+			It("Should join has-one", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("Project")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					Project: Project{
+						Name: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("tasks").Filter("id", t.Id).Join("Project").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*Task).Project.Id).To(Equal(t.Project.Id))
+			})
+
+			It("Should .Related() with model", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("Project")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					Project: Project{
+						Name: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q(t).Related("Project").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*Project).Id).To(Equal(t.Project.Id))
+			})
+
+			It("Should .Related() with id", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("Project")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					Project: Project{
+						Name: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("tasks", t.Id).Related("Project").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*Project).Id).To(Equal(t.Project.Id))
+			})
+		})
+
+		Describe("Belongs to", func() {
+			It("Should ignore unpersisted belongs-to", func() {
+				t := &Task{
+					Name: "P1",
+					File: &File{Filename: "file.txt"},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Q("files").Count()).To(Equal(0))
+			})
+
+			It("Should set key for belongs-to relationship", func() {
+				f := &File{Filename: "file.txt"}
+				Expect(backend.Create(f)).ToNot(HaveOccurred())
+
+				t := &Task{
+					Name: "T1",
+					File: f,
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(f.TaskId).To(Equal(t.Id))
+
+				dbFile, err := backend.FindOne("files", f.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dbFile.(*File).TaskId).To(Equal(t.Id))
+			})
+
+			It("Should auto-persist belongs-to", func() {
+				// Enable auto-create.
+				backend.ModelInfo("tasks").Relation("File").SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(t.File.Id).ToNot(BeZero())
+
+				m, err := backend.FindOne("files", t.File.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m).ToNot(BeNil())
+				Expect(m.(*File).TaskId).To(Equal(t.Id))
+			})
+
+			It("Should auto-update belongs-to", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+				rel.SetAutoUpdate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				t.File.Filename = "NewName"
+				Expect(backend.Update(t)).ToNot(HaveOccurred())
+
+				m, _ := backend.FindOne("files", t.File.Id)
+				Expect(m.(*File).Filename).To(Equal("NewName"))
+			})
+
+			It("Should auto-delete belongs-to", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+				rel.SetAutoDelete(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+
+				Expect(backend.Q("files").Count()).To(Equal(0))
+			})
+
+			It("Should not delete with auto-delete disabled", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+
+				Expect(backend.FindOne("files", t.File.Id)).ToNot(BeNil())
+			})
+
+			It("Should join belongs-to", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("tasks").Filter("id", t.Id).Join("File").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*Task).File).ToNot(BeNil())
+				Expect(m.(*Task).File.Id).To(Equal(t.File.Id))
+			})
+
+			It("Should .Related() with model", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q(t).Related("File").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*File).Id).To(Equal(t.File.Id))
+			})
+
+			It("Should .Related() with id", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("tasks").Relation("File")
+				rel.SetAutoCreate(true)
+
+				t := &Task{
+					Name: "T1",
+					File: &File{
+						Filename: "test",
+					},
+				}
+
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("tasks", t.Id).Related("File").First()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.(*File).Id).To(Equal(t.File.Id))
+			})
+		})
+
+		Describe("has-many", func() {
+			It("Should ignore unpersisted has-many", func() {
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+				Expect(backend.Q("tasks").Count()).To(Equal(0))
+			})
+
+			It("Should set key for has-many relationship", func() {
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(&p.Todos[0])).ToNot(HaveOccurred())
+				Expect(backend.Create(&p.Todos[1])).ToNot(HaveOccurred())
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+
+				tasks, err := backend.Q("tasks").Find()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tasks).To(HaveLen(2))
+				Expect(tasks[0].(*Task).ProjectId).To(Equal(p.Id))
+				Expect(tasks[1].(*Task).ProjectId).To(Equal(p.Id))
+			})
+
+			It("Should auto-persist has-many", func() {
+				// Enable auto-create.
+				backend.ModelInfo("projects").Relation("Todos").SetAutoCreate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+				Expect(p.Todos[0].Id).ToNot(BeZero())
+				Expect(p.Todos[1].Id).ToNot(BeZero())
+
+				tasks, err := backend.Q("tasks").Find()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tasks).To(HaveLen(2))
+				Expect(tasks[0].(*Task).ProjectId).To(Equal(p.Id))
+				Expect(tasks[1].(*Task).ProjectId).To(Equal(p.Id))
+			})
+
+			It("Should auto-update has-many", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+				rel.SetAutoUpdate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+
+				p.Todos[0].Name = "X1"
+				p.Todos[1].Name = "X2"
+				Expect(backend.Update(p)).ToNot(HaveOccurred())
+
+				tasks, err := backend.Q("tasks").Find()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tasks).To(HaveLen(2))
+				Expect(tasks[0].(*Task).Name).To(Equal("X1"))
+				Expect(tasks[1].(*Task).Name).To(Equal("X2"))
+			})
+
+			It("Should auto-delete has-many", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+				rel.SetAutoDelete(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+				Expect(backend.Delete(p)).ToNot(HaveOccurred())
+
+				Expect(backend.Q("tasks").Count()).To(Equal(0))
+			})
+
+			It("Should not delete with auto-delete disabled", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+				Expect(backend.Delete(p)).ToNot(HaveOccurred())
+
+				Expect(backend.Q("tasks").Count()).To(Equal(2))
+			})
+
+			It("Should join has-many", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("projects").Filter("id", p.Id).Join("Todos").First()
+				Expect(err).ToNot(HaveOccurred())
+
+				todos := m.(*Project).Todos
+				Expect(todos).To(HaveLen(2))
+			})
+
+			It("Should .Related() with model", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+
+				m, err := backend.Q(p).Related("Todos").Find()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(m).To(BeEquivalentTo([]interface{}{&p.Todos[0], &p.Todos[1]}))
+			})
+
+			It("Should .Related() with id", func() {
+				// Enable auto-create.
+				rel := backend.ModelInfo("projects").Relation("Todos")
+				rel.SetAutoCreate(true)
+
+				p := &Project{
+					Name:  "P1",
+					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+				}
+
+				Expect(backend.Create(p)).ToNot(HaveOccurred())
+
+				m, err := backend.Q("projects", p.Id).Related("Todos").Find()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(m).To(BeEquivalentTo([]interface{}{&p.Todos[0], &p.Todos[1]}))
+			})
+		})
+
+		Describe("M2M Collection", func() {
+
+			It("Should error when building col for unpersisted model", func() {
+				t := &Task{Name: "test"}
+
+				_, err := backend.M2M(t, "Tags")
+				Expect(err).To(HaveOccurred())
+				Expect(err.GetCode()).To(Equal("unpersisted_model"))
+			})
+
+			It("Should retrieve collection", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				_, err := backend.M2M(t, "Tags")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("Should .Add()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+
+				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
+			})
+
+			It("Should .Remove()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+				Expect(col.Add(tags[0], tags[1], tags[2], tags[3])).ToNot(HaveOccurred())
+
+				Expect(col.Remove(tags[1], tags[2])).ToNot(HaveOccurred())
+
+				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3]}))
+			})
+
+			It("Should .Clear()", func() {
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				t1 := &Task{Name: "test"}
+				Expect(backend.Create(t1)).ToNot(HaveOccurred())
+				col1, _ := backend.M2M(t1, "Tags")
+				Expect(col1.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+
+				t2 := &Task{Name: "test2"}
+				Expect(backend.Create(t2)).ToNot(HaveOccurred())
+				col2, _ := backend.M2M(t2, "Tags")
+				Expect(col2.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+
+				Expect(col1.Clear()).ToNot(HaveOccurred())
+
+				Expect(col1.Count()).To(Equal(0))
+				Expect(col2.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
+			})
+
+			It("Should .Replace()", func() {
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				t1 := &Task{Name: "test"}
+				Expect(backend.Create(t1)).ToNot(HaveOccurred())
+				col1, _ := backend.M2M(t1, "Tags")
+				Expect(col1.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+
+				Expect(col1.Replace(tags[2], tags[3])).ToNot(HaveOccurred())
+
+				Expect(col1.All()).To(BeEquivalentTo([]interface{}{&tags[2], &tags[3]}))
+			})
+
+			It("Should .Count()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.Count()).To(Equal(0))
+				Expect(col.Add(tags[0])).ToNot(HaveOccurred())
+				Expect(col.Count()).To(Equal(1))
+				Expect(col.Add(tags[1], tags[2])).ToNot(HaveOccurred())
+				Expect(col.Count()).To(Equal(3))
+				Expect(col.Clear()).ToNot(HaveOccurred())
+				Expect(col.Count()).To(Equal(0))
+			})
+
+			It("Should .Contains()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.Contains(tags[0])).To(BeFalse())
+
+				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
+				Expect(col.Contains(tags[0])).To(BeTrue())
+				Expect(col.Contains(&tags[3])).To(BeTrue())
+			})
+
+			It("Should .ContainsId()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.ContainsId(tags[0].Id)).To(BeFalse())
+
+				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
+				Expect(col.ContainsId(tags[0].Id)).To(BeTrue())
+				Expect(col.ContainsId(tags[3].Id)).To(BeTrue())
+			})
+
+			It("Should .GetById()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.ContainsId(tags[0].Id)).To(BeFalse())
+
+				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
+				Expect(col.ContainsId(tags[0].Id)).To(BeTrue())
+				Expect(col.ContainsId(tags[3].Id)).To(BeTrue())
+			})
+
+			It("Should .All()", func() {
+				t := &Task{Name: "test"}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+
+				Expect(col.All()).To(HaveLen(0))
+
+				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
+				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3]}))
+
+				Expect(col.Add(tags[1], tags[2])).ToNot(HaveOccurred())
+				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3], &tags[1], &tags[2]}))
+
+				Expect(col.Clear()).ToNot(HaveOccurred())
+				Expect(col.All()).To(HaveLen(0))
+			})
+		})
+
+		Describe("m2m", func() {
+			It("Should ignore unpersisted m2m", func() {
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+
+				t := &Task{
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
+				}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+				Expect(col.Count()).To(Equal(0))
+			})
+
+			It("Should save m2m", func() {
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				t := &Task{
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
+				}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+				Expect(col.Count()).To(Equal(2))
+			})
+
+			It("Should auto-persist m2m", func() {
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoCreate(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+
+				t := &Task{
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
+				}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+				Expect(col.Count()).To(Equal(2))
+			})
+
+			It("Should auto-update m2m", func() {
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoCreate(true)
+				rel.SetAutoUpdate(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				tags[0].Tag = "X1"
+				tags[1].Tag = "X2"
+
+				t := &Task{
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
+				}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
+				col, _ := backend.M2M(t, "Tags")
+				all, err := col.All()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(all).To(HaveLen(2))
+				Expect(all[0].(*Tag).Tag).To(Equal("X1"))
+				Expect(all[1].(*Tag).Tag).To(Equal("X2"))
+			})
+
+			It("Should auto-delete m2m", func() {
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoDelete(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+
+				t := &Task{
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
+				}
+				Expect(backend.Create(t)).ToNot(HaveOccurred())
+
 				Expect(backend.Delete(t)).ToNot(HaveOccurred())
 
-				Expect(backend.FindOne("files", t.File.Id)).ToNot(BeNil())
+				// If a m2m collection exists, check that is was cleared properly.
+				if backend.HasCollection("tasks_tags") {
+					q := backend.Q("tasks_tags").Filter("tasks.id", t.Id)
+					Expect(q.Count()).To(Equal(0))
+				}
 			})
 
-			It("Should join belongs-to", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
-				rel.SetAutoCreate(true)
+			It("Should join m2m", func() {
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoDelete(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
 
 				t := &Task{
-					Name: "T1",
-					File: &File{
-						Filename: "test",
-					},
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
 				}
-
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
 
-				m, err := backend.Q("tasks").Filter("id", t.Id).Join("File").First()
+				m, err := backend.Q("tasks").Filter("id", t.Id).Join("Tags").First()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*Task).File).ToNot(BeNil())
-				Expect(m.(*Task).File.Id).To(Equal(t.File.Id))
+
+				taskTags := m.(*Task).Tags
+				Expect(taskTags).To(HaveLen(2))
 			})
 
 			It("Should .Related() with model", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
-				rel.SetAutoCreate(true)
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoDelete(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
 
 				t := &Task{
-					Name: "T1",
-					File: &File{
-						Filename: "test",
-					},
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
 				}
-
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
 
-				m, err := backend.Q(t).Related("File").First()
+				m, err := backend.Q(t).Related("Tags").Find()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*File).Id).To(Equal(t.File.Id))
+
+				Expect(m).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
 			})
 
 			It("Should .Related() with id", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("tasks").Relation("File")
-				rel.SetAutoCreate(true)
+				rel := backend.ModelInfo("tasks").Relation("Tags")
+				rel.SetAutoDelete(true)
+
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
 
 				t := &Task{
-					Name: "T1",
-					File: &File{
-						Filename: "test",
-					},
+					Name: "test",
+					Tags: []Tag{tags[0], tags[1]},
 				}
-
 				Expect(backend.Create(t)).ToNot(HaveOccurred())
 
-				m, err := backend.Q("tasks", t.Id).Related("File").First()
+				m, err := backend.Q("tasks", t.Id).Related("Tags").Find()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(m.(*File).Id).To(Equal(t.File.Id))
+
+				Expect(m).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
 			})
+
 		})
 
-		Describe("has-many", func() {
-			It("Should ignore unpersisted has-many", func() {
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+		Describe("Complex relations", func() {
+			It("Should do a nested join", func() {
+				backend.ModelInfo("projects").Relation("Todos").SetAutoCreate(true)
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
-				Expect(backend.Q("tasks").Count()).To(Equal(0))
-			})
+				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
 
-			It("Should set key for has-many relationship", func() {
 				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+					Name: "P1",
+					Todos: []Task{
+						Task{Name: "Task 1", Tags: []Tag{tags[0], tags[1]}},
+						Task{Name: "Task 2", Tags: []Tag{tags[2], tags[3]}},
+					},
 				}
 
-				Expect(backend.Create(&p.Todos[0])).ToNot(HaveOccurred())
-				Expect(backend.Create(&p.Todos[1])).ToNot(HaveOccurred())
-
 				Expect(backend.Create(p)).ToNot(HaveOccurred())
 
-				tasks, err := backend.Q("tasks").Find()
+				raw, err := backend.Q("projects").Filter("id", p.Id).Join("Todos.Tags").First()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(tasks).To(HaveLen(2))
-				Expect(tasks[0].(*Task).ProjectId).To(Equal(p.Id))
-				Expect(tasks[1].(*Task).ProjectId).To(Equal(p.Id))
+				m := raw.(*Project)
+				Expect(m.Todos).To(HaveLen(2))
+				Expect(m.Todos[0].Tags).To(Equal([]Tag{tags[0], tags[1]}))
+				Expect(m.Todos[1].Tags).To(Equal([]Tag{tags[2], tags[3]}))
 			})
+		})
+	})
 
-			It("Should auto-persist has-many", func() {
-				// Enable auto-create.
-				backend.ModelInfo("projects").Relation("Todos").SetAutoCreate(true)
+	Describe("Transactions", func() {
+		var transactionBackend db.TransactionBackend
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+		BeforeEach(func() {
+			transactionBackend, _ = backend.(db.TransactionBackend)
+		})
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
-				Expect(p.Todos[0].Id).ToNot(BeZero())
-				Expect(p.Todos[1].Id).ToNot(BeZero())
+		It("Should successfully commit a transaction", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-				tasks, err := backend.Q("tasks").Find()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(tasks).To(HaveLen(2))
-				Expect(tasks[0].(*Task).ProjectId).To(Equal(p.Id))
-				Expect(tasks[1].(*Task).ProjectId).To(Equal(p.Id))
-			})
+			tx, err := transactionBackend.Begin()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tx).ToNot(BeNil())
 
-			It("Should auto-update has-many", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
-				rel.SetAutoUpdate(true)
+			model := NewTestModel(100)
+			Expect(tx.Create(&model)).ToNot(HaveOccurred())
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+			Expect(tx.Commit()).ToNot(HaveOccurred())
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+			m, err := backend.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).ToNot(BeNil())
+		})
 
-				p.Todos[0].Name = "X1"
-				p.Todos[1].Name = "X2"
-				Expect(backend.Update(p)).ToNot(HaveOccurred())
+		It("Should successfully roll back a transaction", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-				tasks, err := backend.Q("tasks").Find()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(tasks).To(HaveLen(2))
-				Expect(tasks[0].(*Task).Name).To(Equal("X1"))
-				Expect(tasks[1].(*Task).Name).To(Equal("X2"))
-			})
+			tx, err := transactionBackend.Begin()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tx).ToNot(BeNil())
 
-			It("Should auto-delete has-many", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
-				rel.SetAutoDelete(true)
+			model := NewTestModel(101)
+			Expect(tx.Create(&model)).ToNot(HaveOccurred())
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+			Expect(tx.Rollback()).ToNot(HaveOccurred())
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
-				Expect(backend.Delete(p)).ToNot(HaveOccurred())
+			m, err := backend.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeNil())
+		})
 
-				Expect(backend.Q("tasks").Count()).To(Equal(0))
-			})
+		It("Should roll back updates and deletes", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-			It("Should not delete with auto-delete disabled", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
+			model := NewTestModel(102)
+			Expect(backend.Create(&model)).ToNot(HaveOccurred())
+			model2 := NewTestModel(103)
+			Expect(backend.Create(&model2)).ToNot(HaveOccurred())
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+			tx, err := transactionBackend.Begin()
+			Expect(err).ToNot(HaveOccurred())
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
-				Expect(backend.Delete(p)).ToNot(HaveOccurred())
+			Expect(tx.UpdateByMap(tx.Q("test_models").Filter("id", model.Id), map[string]interface{}{"int_val": 999})).ToNot(HaveOccurred())
+			Expect(tx.Delete(&model2)).ToNot(HaveOccurred())
 
-				Expect(backend.Q("tasks").Count()).To(Equal(2))
-			})
+			Expect(tx.Rollback()).ToNot(HaveOccurred())
 
-			It("Should join has-many", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
+			m, err := backend.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.(*TestModel).IntVal).To(Equal(model.IntVal))
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
-				}
+			m, err = backend.FindOne("test_models", model2.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).ToNot(BeNil())
+		})
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+		It("Should not expose uncommitted changes", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-				m, err := backend.Q("projects").Filter("id", p.Id).Join("Todos").First()
-				Expect(err).ToNot(HaveOccurred())
+			tx, err := transactionBackend.Begin()
+			Expect(err).ToNot(HaveOccurred())
 
-				todos := m.(*Project).Todos
-				Expect(todos).To(HaveLen(2))
+			model := NewTestModel(104)
+			Expect(tx.Create(&model)).ToNot(HaveOccurred())
+
+			m, err := tx.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).ToNot(BeNil())
+
+			count, err := backend.Q("test_models").Filter("id", model.Id).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+
+			Expect(tx.Commit()).ToNot(HaveOccurred())
+
+			count, err = backend.Q("test_models").Filter("id", model.Id).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		It("Should commit with WithTransaction()", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
+
+			model := NewTestModel(105)
+			err := db.WithTransaction(transactionBackend, func(tx db.Transaction) error {
+				return tx.Create(&model)
 			})
+			Expect(err).ToNot(HaveOccurred())
 
-			It("Should .Related() with model", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
+			m, err := backend.FindOne("test_models", model.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).ToNot(BeNil())
+		})
+
+		It("Should roll back with WithTransaction() on error", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+			model := NewTestModel(106)
+			err := db.WithTransaction(transactionBackend, func(tx db.Transaction) error {
+				if err := tx.Create(&model); err != nil {
+					return err
 				}
+				return errors.New("fail")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("transaction_failed"))
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+			count, err2 := backend.Q("test_models").Filter("str_val", model.StrVal).Count()
+			Expect(err2).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
 
-				m, err := backend.Q(p).Related("Todos").Find()
-				Expect(err).ToNot(HaveOccurred())
+		It("Should roll back with WithTransaction() on panic", func() {
+			if transactionBackend == nil {
+				Skip("Not a transaction backend")
+			}
 
-				Expect(m).To(BeEquivalentTo([]interface{}{&p.Todos[0], &p.Todos[1]}))
+			model := NewTestModel(107)
+			err := db.WithTransaction(transactionBackend, func(tx db.Transaction) error {
+				if err := tx.Create(&model); err != nil {
+					return err
+				}
+				panic("fail")
 			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("transaction_panic"))
 
-			It("Should .Related() with id", func() {
-				// Enable auto-create.
-				rel := backend.ModelInfo("projects").Relation("Todos")
-				rel.SetAutoCreate(true)
+			count, err2 := backend.Q("test_models").Filter("str_val", model.StrVal).Count()
+			Expect(err2).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+	})
 
-				p := &Project{
-					Name:  "P1",
-					Todos: []Task{Task{Name: "T1"}, Task{Name: "T2"}},
+	Describe("Bulk create", func() {
+		It("Should create many models", func() {
+			models := make([]interface{}, 0)
+			for i := 0; i < 25; i++ {
+				m := NewTestModel(601)
+				m.StrVal = fmt.Sprintf("bulk_%v", i)
+				if i%3 == 0 {
+					// Models with different fields are inserted in separate batches.
+					m.MyParentId = uint64(i + 1)
 				}
+				models = append(models, &m)
+			}
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+			Expect(backend.Create(models...)).ToNot(HaveOccurred())
 
-				m, err := backend.Q("projects", p.Id).Related("Todos").Find()
-				Expect(err).ToNot(HaveOccurred())
+			ids := make(map[uint64]string)
+			for _, m := range models {
+				id := m.(*TestModel).Id
+				Expect(id).ToNot(BeZero())
+				ids[id] = m.(*TestModel).StrVal
+			}
+			Expect(ids).To(HaveLen(25))
 
-				Expect(m).To(BeEquivalentTo([]interface{}{&p.Todos[0], &p.Todos[1]}))
-			})
+			res, err := backend.Q("test_models").Filter("int_val", 601).Sort("str_val", true).Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HaveLen(25))
+			for _, item := range res {
+				m := item.(*TestModel)
+				Expect(ids).To(HaveKeyWithValue(m.Id, m.StrVal))
+			}
 		})
 
-		Describe("M2M Collection", func() {
+		It("Should call hooks for each model", func() {
+			models := []interface{}{&HooksModel{}, &HooksModel{}, &HooksModel{}}
+			Expect(backend.Create(models...)).ToNot(HaveOccurred())
 
-			It("Should error when building col for unpersisted model", func() {
-				t := &Task{Name: "test"}
+			for _, m := range models {
+				Expect(m.(*HooksModel).CalledHooks).To(Equal([]string{"before_create", "validate", "after_create"}))
+				Expect(m.(*HooksModel).Id).ToNot(BeZero())
+			}
+		})
+	})
 
-				_, err := backend.M2M(t, "Tags")
-				Expect(err).To(HaveOccurred())
-				Expect(err.GetCode()).To(Equal("unpersisted_model"))
-			})
+	Describe("Upsert", func() {
+		It("Should insert a new model", func() {
+			m := NewTestModel(611)
+			Expect(backend.Upsert(&m)).ToNot(HaveOccurred())
+			Expect(m.Id).ToNot(BeZero())
 
-			It("Should retrieve collection", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).StrVal).To(Equal("str611"))
+		})
 
-				_, err := backend.M2M(t, "Tags")
-				Expect(err).ToNot(HaveOccurred())
-			})
+		It("Should update the model on conflict", func() {
+			m := NewTestModel(612)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
 
-			It("Should .Add()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			update := NewTestModel(613)
+			update.Id = m.Id
+			Expect(backend.Upsert(&update, "id")).ToNot(HaveOccurred())
+			Expect(update.Id).To(Equal(m.Id))
 
-				col, _ := backend.M2M(t, "Tags")
+			count, err := backend.Q("test_models").Filter("id", m.Id).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
 
-				Expect(col.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).StrVal).To(Equal("str613"))
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(613)))
+		})
 
-				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
-			})
+		It("Should keep the existing model with DoNothing", func() {
+			m := NewTestModel(614)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
 
-			It("Should .Remove()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			update := NewTestModel(615)
+			update.Id = m.Id
+			err := backend.UpsertWithOptions(&update, db.UpsertOptions{DoNothing: true})
+			Expect(err).ToNot(HaveOccurred())
 
-				col, _ := backend.M2M(t, "Tags")
-				Expect(col.Add(tags[0], tags[1], tags[2], tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).StrVal).To(Equal("str614"))
+		})
 
-				Expect(col.Remove(tags[1], tags[2])).ToNot(HaveOccurred())
+		It("Should only update the UpdateFields", func() {
+			m := NewTestModel(616)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
 
-				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3]}))
+			update := NewTestModel(617)
+			update.Id = m.Id
+			err := backend.UpsertWithOptions(&update, db.UpsertOptions{
+				ConflictFields: []string{"Id"},
+				UpdateFields:   []string{"StrVal"},
 			})
+			Expect(err).ToNot(HaveOccurred())
 
-			It("Should .Clear()", func() {
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).StrVal).To(Equal("str617"))
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(616)))
+		})
 
-				t1 := &Task{Name: "test"}
-				Expect(backend.Create(t1)).ToNot(HaveOccurred())
-				col1, _ := backend.M2M(t1, "Tags")
-				Expect(col1.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+		It("Should insert a new model with a unique conflict field", func() {
+			m := &UniqueModel{Email: "upsert_insert@example.com", Name: "first"}
+			Expect(backend.Upsert(m, "email")).ToNot(HaveOccurred())
+			Expect(m.Id).ToNot(BeZero())
 
-				t2 := &Task{Name: "test2"}
-				Expect(backend.Create(t2)).ToNot(HaveOccurred())
-				col2, _ := backend.M2M(t2, "Tags")
-				Expect(col2.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("unique_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*UniqueModel).Name).To(Equal("first"))
+		})
 
-				Expect(col1.Clear()).ToNot(HaveOccurred())
+		It("Should update the model with the same unique field", func() {
+			m := &UniqueModel{Email: "upsert_update@example.com", Name: "first"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-				Expect(col1.Count()).To(Equal(0))
-				Expect(col2.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
-			})
+			update := &UniqueModel{Email: "upsert_update@example.com", Name: "second"}
+			Expect(backend.Upsert(update, "email")).ToNot(HaveOccurred())
+			Expect(update.Id).To(Equal(m.Id))
 
-			It("Should .Replace()", func() {
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			count, err := backend.Q("unique_models").Filter("email", "upsert_update@example.com").Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
 
-				t1 := &Task{Name: "test"}
-				Expect(backend.Create(t1)).ToNot(HaveOccurred())
-				col1, _ := backend.M2M(t1, "Tags")
-				Expect(col1.Add(tags[0], tags[1])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("unique_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*UniqueModel).Name).To(Equal("second"))
+		})
 
-				Expect(col1.Replace(tags[2], tags[3])).ToNot(HaveOccurred())
+		It("Should set the id of the existing model with DoNothing", func() {
+			m := &UniqueModel{Email: "upsert_nothing@example.com", Name: "first"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-				Expect(col1.All()).To(BeEquivalentTo([]interface{}{&tags[2], &tags[3]}))
-			})
+			update := &UniqueModel{Email: "upsert_nothing@example.com", Name: "second"}
+			err := backend.UpsertWithOptions(update, db.UpsertOptions{ConflictFields: []string{"email"}, DoNothing: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(update.Id).To(Equal(m.Id))
 
-			It("Should .Count()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("unique_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*UniqueModel).Name).To(Equal("first"))
+		})
 
-				col, _ := backend.M2M(t, "Tags")
+		It("Should replace a differing id with the id of the existing model", func() {
+			m := &UniqueModel{Email: "upsert_id@example.com", Name: "first"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-				Expect(col.Count()).To(Equal(0))
-				Expect(col.Add(tags[0])).ToNot(HaveOccurred())
-				Expect(col.Count()).To(Equal(1))
-				Expect(col.Add(tags[1], tags[2])).ToNot(HaveOccurred())
-				Expect(col.Count()).To(Equal(3))
-				Expect(col.Clear()).ToNot(HaveOccurred())
-				Expect(col.Count()).To(Equal(0))
-			})
+			update := &UniqueModel{Id: m.Id + 1000, Email: "upsert_id@example.com", Name: "second"}
+			Expect(backend.Upsert(update, "email")).ToNot(HaveOccurred())
+			Expect(update.Id).To(Equal(m.Id))
 
-			It("Should .Contains()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			nothing := &UniqueModel{Id: m.Id + 1001, Email: "upsert_id@example.com", Name: "third"}
+			err := backend.UpsertWithOptions(nothing, db.UpsertOptions{ConflictFields: []string{"email"}, DoNothing: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nothing.Id).To(Equal(m.Id))
+		})
 
-				col, _ := backend.M2M(t, "Tags")
+		It("Should fail for unknown conflict fields", func() {
+			m := NewTestModel(618)
+			err := backend.Upsert(&m, "unknown_field")
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("unknown_field"))
+		})
 
-				Expect(col.Contains(tags[0])).To(BeFalse())
+		It("Should increment the version of the existing model", func() {
+			m := &VersionedModel{Name: "upsert_v1"}
+			Expect(backend.Upsert(m)).ToNot(HaveOccurred())
+			Expect(m.Version).To(Equal(1))
 
-				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
-				Expect(col.Contains(tags[0])).To(BeTrue())
-				Expect(col.Contains(&tags[3])).To(BeTrue())
-			})
+			updated := &VersionedModel{Id: m.Id, Name: "upsert_v1_updated"}
+			Expect(backend.Upsert(updated)).ToNot(HaveOccurred())
+			Expect(updated.Version).To(Equal(2))
 
-			It("Should .ContainsId()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*VersionedModel).Name).To(Equal("upsert_v1_updated"))
+			Expect(dbModel.(*VersionedModel).Version).To(Equal(2))
+		})
 
-				col, _ := backend.M2M(t, "Tags")
+		It("Should keep soft deleted models deleted", func() {
+			m := &SoftDeletedModel{Name: "upsert_deleted"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
 
-				Expect(col.ContainsId(tags[0].Id)).To(BeFalse())
+			updated := &SoftDeletedModel{Id: m.Id, Name: "upsert_deleted_updated"}
+			Expect(backend.Upsert(updated)).ToNot(HaveOccurred())
 
-				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
-				Expect(col.ContainsId(tags[0].Id)).To(BeTrue())
-				Expect(col.ContainsId(tags[3].Id)).To(BeTrue())
-			})
+			Expect(backend.Q("soft_deleted_models").Filter("id", m.Id).Count()).To(Equal(0))
+			dbModel, err := backend.Q("soft_deleted_models").WithDeleted().Filter("id", m.Id).First()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*SoftDeletedModel).Name).To(Equal("upsert_deleted_updated"))
+		})
 
-			It("Should .GetById()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+		It("Should take a snapshot for dirty tracking", func() {
+			m := &DirtyModel{Name: "upsert_dirty"}
+			Expect(backend.Upsert(m)).ToNot(HaveOccurred())
 
-				col, _ := backend.M2M(t, "Tags")
+			changes, err := backend.Changes(m)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+		})
+	})
 
-				Expect(col.ContainsId(tags[0].Id)).To(BeFalse())
+	Describe("Atomic updates", func() {
+		It("Should increment and decrement fields", func() {
+			m := NewTestModel(621)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
 
-				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
-				Expect(col.ContainsId(tags[0].Id)).To(BeTrue())
-				Expect(col.ContainsId(tags[3].Id)).To(BeTrue())
-			})
+			q := backend.Q("test_models").Filter("id", m.Id)
+			Expect(q.Update(expressions.Incr("int_val", 5))).ToNot(HaveOccurred())
 
-			It("Should .All()", func() {
-				t := &Task{Name: "test"}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(626)))
 
-				col, _ := backend.M2M(t, "Tags")
+			Expect(q.Update(expressions.Decr("int_val", 2))).ToNot(HaveOccurred())
 
-				Expect(col.All()).To(HaveLen(0))
+			dbModel, err = backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(624)))
+		})
 
-				Expect(col.Add(tags[0], tags[3])).ToNot(HaveOccurred())
-				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3]}))
+		It("Should update each model with its own values", func() {
+			m1 := TestModel{StrVal: "atomic_multi", IntVal: 10}
+			m2 := TestModel{StrVal: "atomic_multi", IntVal: 20}
+			Expect(backend.Create(&m1, &m2)).ToNot(HaveOccurred())
 
-				Expect(col.Add(tags[1], tags[2])).ToNot(HaveOccurred())
-				Expect(col.All()).To(BeEquivalentTo([]interface{}{&tags[0], &tags[3], &tags[1], &tags[2]}))
+			err := backend.Q("test_models").Filter("str_val", "atomic_multi").Update(
+				expressions.Expr("int_val", expressions.Mul(expressions.FieldRef("int_val"), expressions.Value(3))))
+			Expect(err).ToNot(HaveOccurred())
 
-				Expect(col.Clear()).ToNot(HaveOccurred())
-				Expect(col.All()).To(HaveLen(0))
-			})
-		})
+			dbModel, err := backend.FindOne("test_models", m1.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(30)))
 
-		Describe("m2m", func() {
-			It("Should ignore unpersisted m2m", func() {
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+			dbModel, err = backend.FindOne("test_models", m2.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(60)))
+		})
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+		It("Should accept expressions in UpdateByMap", func() {
+			m := NewTestModel(622)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
 
-				col, _ := backend.M2M(t, "Tags")
-				Expect(col.Count()).To(Equal(0))
+			q := backend.Q("test_models").Filter("id", m.Id)
+			err := backend.UpdateByMap(q, map[string]interface{}{
+				"int_val": expressions.Sub(expressions.FieldRef("int_val"), expressions.Value(22)),
+				"str_val": "atomic_map",
 			})
+			Expect(err).ToNot(HaveOccurred())
 
-			It("Should save m2m", func() {
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("test_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*TestModel).IntVal).To(Equal(int64(600)))
+			Expect(dbModel.(*TestModel).StrVal).To(Equal("atomic_map"))
+		})
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+		It("Should set fields to the current time", func() {
+			p := &Project{Name: "atomic_now"}
+			Expect(backend.Create(p)).ToNot(HaveOccurred())
 
-				col, _ := backend.M2M(t, "Tags")
-				Expect(col.Count()).To(Equal(2))
-			})
+			err := backend.Q("projects").Filter("id", p.Id).Update(expressions.Expr("created_at", expressions.Now()))
+			Expect(err).ToNot(HaveOccurred())
 
-			It("Should auto-persist m2m", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoCreate(true)
+			dbModel, err := backend.FindOne("projects", p.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*Project).CreatedAt.IsZero()).To(BeFalse())
+		})
+	})
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
+	Describe("Optimistic locking", func() {
+		It("Should set the version on create", func() {
+			m := &VersionedModel{Name: "v1"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(m.Version).To(Equal(1))
+		})
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+		It("Should increment the version on update", func() {
+			m := &VersionedModel{Name: "v2"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-				col, _ := backend.M2M(t, "Tags")
-				Expect(col.Count()).To(Equal(2))
-			})
+			m.Name = "v2_updated"
+			Expect(backend.Update(m)).ToNot(HaveOccurred())
+			Expect(m.Version).To(Equal(2))
 
-			It("Should auto-update m2m", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoCreate(true)
-				rel.SetAutoUpdate(true)
+			dbModel, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*VersionedModel).Version).To(Equal(2))
+			Expect(dbModel.(*VersionedModel).Name).To(Equal("v2_updated"))
+		})
+
+		It("Should reject updates of stale models", func() {
+			m := &VersionedModel{Name: "v3"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+
+			rawStale, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			stale := rawStale.(*VersionedModel)
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			m.Name = "v3_first"
+			Expect(backend.Update(m)).ToNot(HaveOccurred())
 
-				tags[0].Tag = "X1"
-				tags[1].Tag = "X2"
+			stale.Name = "v3_second"
+			err = backend.Update(stale)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("stale_object"))
+			// The version is not changed by a failed update.
+			Expect(stale.Version).To(Equal(1))
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*VersionedModel).Name).To(Equal("v3_first"))
+		})
 
-				col, _ := backend.M2M(t, "Tags")
-				all, err := col.All()
-				Expect(err).ToNot(HaveOccurred())
-				Expect(all).To(HaveLen(2))
-				Expect(all[0].(*Tag).Tag).To(Equal("X1"))
-				Expect(all[1].(*Tag).Tag).To(Equal("X2"))
-			})
+		It("Should reject deletes of stale models", func() {
+			m := &VersionedModel{Name: "v4"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-			It("Should auto-delete m2m", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoDelete(true)
+			rawStale, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			Expect(backend.Update(m)).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			err = backend.Delete(rawStale)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("stale_object"))
 
-				Expect(backend.Delete(t)).ToNot(HaveOccurred())
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
+			Expect(backend.Q("versioned_models").Filter("id", m.Id).Count()).To(Equal(0))
+		})
 
-				// If a m2m collection exists, check that is was cleared properly.
-				if backend.HasCollection("tasks_tags") {
-					q := backend.Q("tasks_tags").Filter("tasks.id", t.Id)
-					Expect(q.Count()).To(Equal(0))
-				}
-			})
+		It("Should keep the relations of stale models", func() {
+			m := &VersionedModel{Name: "v5"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			item := &VersionedItem{Name: "v5_item", VersionedModelId: m.Id}
+			Expect(backend.Create(item)).ToNot(HaveOccurred())
 
-			It("Should join m2m", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoDelete(true)
+			rawStale, err := backend.FindOne("versioned_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			stale := rawStale.(*VersionedModel)
+			stale.Items = []VersionedItem{*item}
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			Expect(backend.Update(m)).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			err = backend.Delete(stale)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("stale_object"))
+			Expect(backend.Q("versioned_items").Filter("id", item.Id).Count()).To(Equal(1))
 
-				m, err := backend.Q("tasks").Filter("id", t.Id).Join("Tags").First()
-				Expect(err).ToNot(HaveOccurred())
+			m.Items = []VersionedItem{*item}
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
+			Expect(backend.Q("versioned_items").Filter("id", item.Id).Count()).To(Equal(0))
+		})
+	})
 
-				taskTags := m.(*Task).Tags
-				Expect(taskTags).To(HaveLen(2))
-			})
+	Describe("Soft delete", func() {
+		It("Should hide soft deleted models", func() {
+			m := &SoftDeletedModel{Name: "sd1"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-			It("Should .Related() with model", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoDelete(true)
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
+			Expect(m.IsDeleted()).To(BeTrue())
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd1").Count()).To(Equal(0))
+			dbModel, err := backend.FindOne("soft_deleted_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel).To(BeNil())
+		})
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+		It("Should include soft deleted models with WithDeleted()", func() {
+			m := &SoftDeletedModel{Name: "sd2"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
 
-				m, err := backend.Q(t).Related("Tags").Find()
-				Expect(err).ToNot(HaveOccurred())
+			rawModel, err := backend.Q("soft_deleted_models").Filter("name", "sd2").WithDeleted().First()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rawModel).ToNot(BeNil())
+			Expect(rawModel.(*SoftDeletedModel).IsDeleted()).To(BeTrue())
+		})
 
-				Expect(m).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
-			})
+		It("Should select only soft deleted models with OnlyDeleted()", func() {
+			active := &SoftDeletedModel{Name: "sd3"}
+			Expect(backend.Create(active)).ToNot(HaveOccurred())
+			deleted := &SoftDeletedModel{Name: "sd3"}
+			Expect(backend.Create(deleted)).ToNot(HaveOccurred())
+			Expect(backend.Delete(deleted)).ToNot(HaveOccurred())
 
-			It("Should .Related() with id", func() {
-				rel := backend.ModelInfo("tasks").Relation("Tags")
-				rel.SetAutoDelete(true)
+			models, err := backend.Q("soft_deleted_models").Filter("name", "sd3").OnlyDeleted().Find()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(models).To(HaveLen(1))
+			Expect(models[0].(*SoftDeletedModel).Id).To(Equal(deleted.Id))
+		})
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+		It("Should restore soft deleted models", func() {
+			m := &SoftDeletedModel{Name: "sd4"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(m)).ToNot(HaveOccurred())
 
-				t := &Task{
-					Name: "test",
-					Tags: []Tag{tags[0], tags[1]},
-				}
-				Expect(backend.Create(t)).ToNot(HaveOccurred())
+			Expect(backend.Restore(m)).ToNot(HaveOccurred())
+			Expect(m.IsDeleted()).To(BeFalse())
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd4").Count()).To(Equal(1))
+		})
 
-				m, err := backend.Q("tasks", t.Id).Related("Tags").Find()
-				Expect(err).ToNot(HaveOccurred())
+		It("Should permanently delete with ForceDelete()", func() {
+			m := &SoftDeletedModel{Name: "sd5"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-				Expect(m).To(BeEquivalentTo([]interface{}{&tags[0], &tags[1]}))
-			})
+			Expect(backend.ForceDelete(m)).ToNot(HaveOccurred())
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd5").WithDeleted().Count()).To(Equal(0))
+		})
+
+		It("Should soft delete with DeleteMany()", func() {
+			for i := 0; i < 2; i++ {
+				Expect(backend.Create(&SoftDeletedModel{Name: "sd6"})).ToNot(HaveOccurred())
+			}
 
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd6").Delete()).ToNot(HaveOccurred())
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd6").Count()).To(Equal(0))
+			Expect(backend.Q("soft_deleted_models").Filter("name", "sd6").OnlyDeleted().Count()).To(Equal(2))
 		})
 
-		Describe("Complex relations", func() {
-			It("Should do a nested join", func() {
-				backend.ModelInfo("projects").Relation("Todos").SetAutoCreate(true)
+		It("Should not join soft deleted models", func() {
+			parent := &SoftDeletedModel{Name: "sd7"}
+			Expect(backend.Create(parent)).ToNot(HaveOccurred())
+			m := &SoftDeletedRelationModel{Name: "sd7", ParentId: parent.Id}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(parent)).ToNot(HaveOccurred())
 
-				tags := []Tag{Tag{Tag: "T1"}, {Tag: "T2"}, {Tag: "T3"}, {Tag: "T4"}}
-				Expect(backend.Create(&tags[0], &tags[1], &tags[2], &tags[3])).ToNot(HaveOccurred())
+			rawModel, err := backend.Q("soft_deleted_relation_models").Filter("id", m.Id).Join("Parent").First()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rawModel).ToNot(BeNil())
+			Expect(rawModel.(*SoftDeletedRelationModel).Parent).To(BeNil())
+		})
 
-				p := &Project{
-					Name: "P1",
-					Todos: []Task{
-						Task{Name: "Task 1", Tags: []Tag{tags[0], tags[1]}},
-						Task{Name: "Task 2", Tags: []Tag{tags[2], tags[3]}},
-					},
-				}
+		It("Should join soft deleted models with WithDeleted()", func() {
+			parent := &SoftDeletedModel{Name: "sd8"}
+			Expect(backend.Create(parent)).ToNot(HaveOccurred())
+			m := &SoftDeletedRelationModel{Name: "sd8", ParentId: parent.Id}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+			Expect(backend.Delete(parent)).ToNot(HaveOccurred())
 
-				Expect(backend.Create(p)).ToNot(HaveOccurred())
+			q := backend.Q("soft_deleted_relation_models").Filter("id", m.Id).Join("Parent")
+			q.GetJoin("Parent").WithDeleted()
+			rawModel, err := q.First()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rawModel).ToNot(BeNil())
+			Expect(rawModel.(*SoftDeletedRelationModel).Parent).ToNot(BeNil())
+			Expect(rawModel.(*SoftDeletedRelationModel).Parent.Id).To(Equal(parent.Id))
+		})
 
-				raw, err := backend.Q("projects").Filter("id", p.Id).Join("Todos.Tags").First()
-				Expect(err).ToNot(HaveOccurred())
-				m := raw.(*Project)
-				Expect(m.Todos).To(HaveLen(2))
-				Expect(m.Todos[0].Tags).To(Equal([]Tag{tags[0], tags[1]}))
-				Expect(m.Todos[1].Tags).To(Equal([]Tag{tags[2], tags[3]}))
-			})
+		It("Should fail to restore models without a soft delete field", func() {
+			m := NewTestModel(631)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
+
+			err := backend.Restore(&m)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("not_soft_deletable"))
 		})
 	})
 
-	Describe("Transactions", func() {
-		transactionBackend, _ := backend.(db.TransactionBackend)
+	Describe("Dirty tracking", func() {
+		It("Should not report changes for created models", func() {
+			m := &DirtyModel{Name: "d1"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-		It("Should successfully commit a transaction", func() {
-			if transactionBackend == nil {
-				Skip("Not a transaction backend")
-			}
+			changes, err := backend.Changes(m)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+		})
 
-			tx, err := transactionBackend.Begin()
+		It("Should report changes of loaded models", func() {
+			m := &DirtyModel{Name: "d2", Count: 1}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+
+			rawModel, err := backend.FindOne("dirty_models", m.Id)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(tx).ToNot(BeNil())
+			loaded := rawModel.(*DirtyModel)
+			Expect(backend.IsDirty(loaded, "Name")).To(BeFalse())
 
-			model := NewTestModel(100)
-			Expect(tx.Create(&model)).ToNot(HaveOccurred())
+			loaded.Name = "d2_changed"
+			Expect(backend.IsDirty(loaded, "Name")).To(BeTrue())
+			Expect(backend.IsDirty(loaded, "Count")).To(BeFalse())
 
-			Expect(tx.Commit()).ToNot(HaveOccurred())
+			changes, err := backend.Changes(loaded)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes["Name"].Old).To(Equal("d2"))
+			Expect(changes["Name"].New).To(Equal("d2_changed"))
+		})
 
-			m, err := backend.FindOne("test_models", model.Id)
+		It("Should only update changed fields", func() {
+			m := &DirtyModel{Name: "d3", Count: 1}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+
+			rawFirst, err := backend.FindOne("dirty_models", m.Id)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(m).ToNot(BeNil())
+			rawSecond, err := backend.FindOne("dirty_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+
+			first := rawFirst.(*DirtyModel)
+			first.Name = "d3_changed"
+			Expect(backend.Update(first)).ToNot(HaveOccurred())
+			Expect(backend.IsDirty(first, "Name")).To(BeFalse())
+
+			second := rawSecond.(*DirtyModel)
+			second.Count = 2
+			Expect(backend.Update(second)).ToNot(HaveOccurred())
+
+			dbModel, err := backend.FindOne("dirty_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*DirtyModel).Name).To(Equal("d3_changed"))
+			Expect(dbModel.(*DirtyModel).Count).To(Equal(2))
 		})
 
-		It("Should successfully roll back a transaction", func() {
-			if transactionBackend == nil {
-				Skip("Not a transaction backend")
-			}
+		It("Should detect in place changes of byte slices", func() {
+			m := &DirtyModel{Name: "d4", Data: []byte("abc")}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-			tx, err := transactionBackend.Begin()
+			rawModel, err := backend.FindOne("dirty_models", m.Id)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(tx).ToNot(BeNil())
+			loaded := rawModel.(*DirtyModel)
+			loaded.Data[0] = 'x'
+			Expect(backend.IsDirty(loaded, "Data")).To(BeTrue())
+			Expect(backend.Update(loaded)).ToNot(HaveOccurred())
 
-			model := NewTestModel(101)
-			Expect(tx.Create(&model)).ToNot(HaveOccurred())
+			dbModel, err := backend.FindOne("dirty_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dbModel.(*DirtyModel).Data)).To(Equal("xbc"))
+		})
 
-			Expect(tx.Rollback()).ToNot(HaveOccurred())
+		It("Should update ignore-zero fields reset to zero", func() {
+			m := &DirtyModel{Name: "d5", Note: "note"}
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
 
-			m, err := backend.FindOne("test_models", model.Id)
+			rawModel, err := backend.FindOne("dirty_models", m.Id)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(m).To(BeNil())
+			loaded := rawModel.(*DirtyModel)
+			loaded.Note = ""
+			Expect(backend.IsDirty(loaded, "Note")).To(BeTrue())
+			Expect(backend.Update(loaded)).ToNot(HaveOccurred())
+
+			dbModel, err := backend.FindOne("dirty_models", m.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dbModel.(*DirtyModel).Note).To(Equal(""))
+		})
+
+		It("Should report all fields of untracked models as changed", func() {
+			m := NewTestModel(641)
+			Expect(backend.Create(&m)).ToNot(HaveOccurred())
+			Expect(backend.IsDirty(&m, "IntVal")).To(BeTrue())
 		})
 	})
 
@@ -1385,7 +2942,8 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 
 			err := backend.Create(m)
 			Expect(err).To(HaveOccurred())
-			Expect(err.(apperror.Error).GetCode()).To(Equal("empty_required_field"))
+			Expect(err.GetCode()).To(Equal("validation_error"))
+			Expect(err.(*db.ValidationError).FieldErrorCodes("notNullString")).To(Equal([]string{"empty_required_field"}))
 		})
 
 		It("Should fail on minimum restraint string", func() {
@@ -1399,7 +2957,8 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 
 			err := backend.Create(m)
 			Expect(err).To(HaveOccurred())
-			Expect(err.(apperror.Error).GetCode()).To(Equal("shorter_than_min_length"))
+			Expect(err.GetCode()).To(Equal("validation_error"))
+			Expect(err.(*db.ValidationError).FieldErrorCodes("validatedString")).To(Equal([]string{"shorter_than_min_length"}))
 		})
 
 		It("Should fail on maximum restraint string", func() {
@@ -1413,7 +2972,8 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 
 			err := backend.Create(m)
 			Expect(err).To(HaveOccurred())
-			Expect(err.(apperror.Error).GetCode()).To(Equal("longer_than_max_length"))
+			Expect(err.GetCode()).To(Equal("validation_error"))
+			Expect(err.(*db.ValidationError).FieldErrorCodes("validatedString")).To(Equal([]string{"longer_than_max_length"}))
 		})
 
 		It("Should fail on minimum restraint int", func() {
@@ -1427,7 +2987,8 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 
 			err := backend.Create(m)
 			Expect(err).To(HaveOccurred())
-			Expect(err.(apperror.Error).GetCode()).To(Equal("shorter_than_min_length"))
+			Expect(err.GetCode()).To(Equal("validation_error"))
+			Expect(err.(*db.ValidationError).FieldErrorCodes("validatedInt")).To(Equal([]string{"shorter_than_min_length"}))
 		})
 
 		It("Should fail on maximum restraint int", func() {
@@ -1441,7 +3002,8 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 
 			err := backend.Create(m)
 			Expect(err).To(HaveOccurred())
-			Expect(err.(apperror.Error).GetCode()).To(Equal("longer_than_max_length"))
+			Expect(err.GetCode()).To(Equal("validation_error"))
+			Expect(err.(*db.ValidationError).FieldErrorCodes("validatedInt")).To(Equal([]string{"longer_than_max_length"}))
 		})
 
 		It("Should create correctly within restraints", func() {
@@ -1455,6 +3017,189 @@ func TestBackend(skipFlag *bool, backendBuilder func() (db.Backend, apperror.Err
 			err := backend.Create(m)
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("Should report all invalid fields at once", func() {
+			m := &ValidationsModel{
+				NotNullInt:   1,
+				ValidatedInt: 6,
+
+				NotNullString:   "",
+				ValidatedString: "t",
+				Email:           "invalid",
+			}
+
+			err := backend.Create(m)
+			Expect(err).To(HaveOccurred())
+			validationErr := err.(*db.ValidationError)
+			Expect(validationErr.FieldErrors).To(HaveLen(3))
+			Expect(validationErr.FieldErrorCodes("notNullString")).To(Equal([]string{"empty_required_field"}))
+			Expect(validationErr.FieldErrorCodes("email")).To(Equal([]string{"invalid_email"}))
+
+			minErr := validationErr.FieldErrors["validatedString"][0]
+			Expect(minErr.Code).To(Equal("shorter_than_min_length"))
+			Expect(minErr.Params["min"]).To(Equal(float64(5)))
+		})
+
+		It("Should validate emails, urls, uuids, patterns and enums", func() {
+			m := &ValidationsModel{
+				NotNullString:   "x",
+				NotNullInt:      1,
+				ValidatedString: "tttttt",
+				ValidatedInt:    6,
+
+				Email:   "a@b",
+				Website: "not a url",
+				Uuid:    "1234",
+				Code:    "abc",
+				Status:  "deleted",
+			}
+
+			err := backend.Create(m)
+			Expect(err).To(HaveOccurred())
+			validationErr := err.(*db.ValidationError)
+			Expect(validationErr.FieldErrorCodes("email")).To(Equal([]string{"invalid_email"}))
+			Expect(validationErr.FieldErrorCodes("website")).To(Equal([]string{"invalid_url"}))
+			Expect(validationErr.FieldErrorCodes("uuid")).To(Equal([]string{"invalid_uuid"}))
+			Expect(validationErr.FieldErrorCodes("code")).To(Equal([]string{"pattern_mismatch"}))
+			Expect(validationErr.FieldErrorCodes("status")).To(Equal([]string{"invalid_enum_value"}))
+		})
+
+		It("Should create with valid emails, urls, uuids, patterns and enums", func() {
+			m := &ValidationsModel{
+				NotNullString:   "x",
+				NotNullInt:      1,
+				ValidatedString: "tttttt",
+				ValidatedInt:    6,
+
+				Email:   "user@example.com",
+				Website: "https://example.com/path",
+				Uuid:    "123e4567-e89b-12d3-a456-426614174000",
+				Code:    "ABC",
+				Status:  "active",
+			}
+
+			Expect(backend.Create(m)).ToNot(HaveOccurred())
+		})
 	})
 
+	Describe("Migrations", func() {
+		var handler *db.MigrationHandler
+		var log []string
+
+		migration := func(name string, withDown bool) db.Migration {
+			m := db.Migration{
+				Name: name,
+				Up: func(db.MigrationBackend) error {
+					log = append(log, "up_"+name)
+					return nil
+				},
+			}
+			if withDown {
+				m.Down = func(db.MigrationBackend) error {
+					log = append(log, "down_"+name)
+					return nil
+				}
+			}
+			return m
+		}
+
+		BeforeEach(func() {
+			migrationBackend, ok := backend.(db.MigrationBackend)
+			if !ok {
+				Skip("Backend does not support migrations")
+			}
+
+			Expect(backend.DropCollection("migration_attempts", true, false)).ToNot(HaveOccurred())
+			handler = db.NewMigrationHandler(migrationBackend)
+			log = nil
+		})
+
+		It("Should migrate up and down", func() {
+			handler.Add(migration("m1", true), migration("m2", true))
+
+			Expect(handler.Migrate(false)).ToNot(HaveOccurred())
+			Expect(handler.Backend.DetermineMigrationVersion()).To(Equal(2))
+
+			Expect(handler.MigrateTo(0, false)).ToNot(HaveOccurred())
+			Expect(handler.Backend.DetermineMigrationVersion()).To(Equal(0))
+			Expect(log).To(Equal([]string{"up_m1", "up_m2", "down_m2", "down_m1"}))
+		})
+
+		It("Should fail to roll back a migration without Down", func() {
+			handler.Add(migration("m1", true), migration("m2", false))
+			Expect(handler.Migrate(false)).ToNot(HaveOccurred())
+
+			err := handler.MigrateTo(0, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("migration_without_down"))
+			Expect(handler.Backend.DetermineMigrationVersion()).To(Equal(2))
+			Expect(log).To(Equal([]string{"up_m1", "up_m2"}))
+		})
+
+		It("Should initialize a fresh database", func() {
+			Expect(backend.DropAllCollections()).ToNot(HaveOccurred())
+
+			m1 := migration("m1", false)
+			m1.SkipOnNew = true
+			handler.Add(m1, migration("m2", false))
+
+			Expect(handler.InitializeFresh()).ToNot(HaveOccurred())
+			Expect(log).To(Equal([]string{"up_m2"}))
+			Expect(handler.Backend.DetermineMigrationVersion()).To(Equal(2))
+			Expect(backend.Q("test_models").Count()).To(Equal(0))
+		})
+
+		It("Should report the current version and pending migrations", func() {
+			handler.Add(migration("m1", true), migration("m2", true))
+			Expect(handler.CurrentVersion()).To(Equal(0))
+			Expect(handler.PendingMigrations()).To(HaveLen(2))
+
+			Expect(handler.MigrateTo(1, false)).ToNot(HaveOccurred())
+			Expect(handler.CurrentVersion()).To(Equal(1))
+			pending, err := handler.PendingMigrations()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pending).To(HaveLen(1))
+			Expect(pending[0].Name).To(Equal("m2"))
+
+			Expect(handler.Migrate(false)).ToNot(HaveOccurred())
+			Expect(handler.CurrentVersion()).To(Equal(2))
+			Expect(handler.PendingMigrations()).To(BeEmpty())
+
+			Expect(handler.MigrateTo(0, false)).ToNot(HaveOccurred())
+			Expect(handler.CurrentVersion()).To(Equal(0))
+			Expect(handler.PendingMigrations()).To(HaveLen(2))
+		})
+
+		It("Should report status and force unlock", func() {
+			handler.Add(migration("m1", false), migration("m2", false))
+			Expect(handler.MigrateTo(1, false)).ToNot(HaveOccurred())
+
+			// Simulate an aborted migration.
+			attempt := handler.Backend.NewMigrationAttempt()
+			attempt.SetVersion(2)
+			attempt.SetStartedAt(time.Now())
+			Expect(backend.Create(attempt)).ToNot(HaveOccurred())
+
+			status, err := handler.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Locked).To(BeTrue())
+			Expect(status.CurrentVersion).To(Equal(1))
+			Expect(status.LatestVersion).To(Equal(2))
+			Expect(status.Pending).To(HaveLen(1))
+			Expect(status.FailedAttempt).ToNot(BeNil())
+			Expect(status.FailedAttempt.Aborted).To(BeTrue())
+
+			Expect(handler.Migrate(false).GetCode()).To(Equal("migrations_locked"))
+
+			Expect(handler.ForceUnlock()).ToNot(HaveOccurred())
+
+			status, err = handler.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Locked).To(BeFalse())
+			Expect(status.FailedAttempt.Failed).To(BeTrue())
+
+			Expect(handler.Migrate(false)).ToNot(HaveOccurred())
+			Expect(handler.CurrentVersion()).To(Equal(2))
+		})
+	})
 }
@@ -106,3 +106,38 @@ func (m *TimeStampedModel) BeforeUpdate(b Backend) error {
 	m.UpdatedAt = time.Now()
 	return nil
 }
+
+/**
+ * SoftDeleteModel.
+ */
+
+// SoftDeleteModel can be embedded in models that should be soft deleted.
+// Deleting the model sets DeletedAt instead of removing it, and queries
+// exclude deleted models.
+type SoftDeleteModel struct {
+	DeletedAt *time.Time `db:"soft-delete"`
+}
+
+// IsDeleted returns true if the model was soft deleted.
+func (m *SoftDeleteModel) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+/**
+ * DirtyTrackingModel.
+ */
+
+// DirtyTrackingModel can be embedded in models to enable dirty tracking.
+// Models keep a snapshot of the values they were loaded with, and Update()
+// only writes the fields that changed since.
+type DirtyTrackingModel struct {
+	snapshot map[string]interface{}
+}
+
+func (m *DirtyTrackingModel) Snapshot() map[string]interface{} {
+	return m.snapshot
+}
+
+func (m *DirtyTrackingModel) SetSnapshot(snapshot map[string]interface{}) {
+	m.snapshot = snapshot
+}
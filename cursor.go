@@ -0,0 +1,201 @@
+package dukedb
+
+import (
+	"github.com/theduke/go-apperror"
+)
+
+// CURSOR_BATCH_SIZE is the number of rows a cursor reads from the backend
+// before building the models, executing joins and calling hooks.
+// Joins are executed once per batch, not once per row.
+const CURSOR_BATCH_SIZE = 100
+
+/**
+ * SliceIterator.
+ */
+
+// SliceIterator is a RowIterator for a result that is already held in memory.
+type SliceIterator struct {
+	items []interface{}
+	index int
+}
+
+// Ensure SliceIterator implements RowIterator.
+var _ RowIterator = (*SliceIterator)(nil)
+
+func NewSliceIterator(items []interface{}) *SliceIterator {
+	return &SliceIterator{
+		items: items,
+	}
+}
+
+func (i *SliceIterator) Next() (interface{}, apperror.Error) {
+	if i.index >= len(i.items) {
+		return nil, nil
+	}
+	item := i.items[i.index]
+	i.index++
+	return item, nil
+}
+
+func (i *SliceIterator) Close() apperror.Error {
+	i.items = nil
+	return nil
+}
+
+/**
+ * BaseCursor.
+ */
+
+// BaseCursor implements a Cursor on top of a RowIterator.
+//
+// Rows are read in batches of CURSOR_BATCH_SIZE. For each batch, the models
+// are built, joins are executed and the AfterQuery hook is called, so that
+// the models returned by Next() are identical to the ones returned by Query().
+type BaseCursor struct {
+	backend *BaseBackend
+	info    *ModelInfo
+
+	// query is the normalized query.
+	query *Query
+	// countQuery is an unmodified copy of the query, used by Count().
+	countQuery *Query
+
+	rows RowIterator
+
+	buffer []interface{}
+	done   bool
+	err    apperror.Error
+
+	count int
+}
+
+// Ensure BaseCursor implements Cursor.
+var _ Cursor = (*BaseCursor)(nil)
+
+// Count returns the total number of items.
+// The count is determined with a separate query on first use.
+// If the count can not be determined, -1 is returned.
+func (c *BaseCursor) Count() int {
+	if c.count > -1 {
+		return c.count
+	}
+
+	count, err := c.backend.backend.Count(c.countQuery)
+	if err != nil {
+		c.backend.backend.Logger().Errorf("Could not determine cursor count: %v", err)
+		return -1
+	}
+	c.count = count
+
+	return count
+}
+
+// HasNext returns true if a next item exists.
+// If reading the next rows fails, HasNext returns true, and the error is
+// returned by the following call to Next().
+func (c *BaseCursor) HasNext() bool {
+	if c.err != nil {
+		return true
+	}
+	if len(c.buffer) == 0 && !c.done {
+		if err := c.fill(); err != nil {
+			c.err = err
+			return true
+		}
+	}
+	return len(c.buffer) > 0
+}
+
+func (c *BaseCursor) Next(targetModel ...interface{}) (interface{}, apperror.Error) {
+	if !c.HasNext() {
+		return nil, nil
+	}
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		c.Close()
+		return nil, err
+	}
+
+	model := c.buffer[0]
+	c.buffer = c.buffer[1:]
+
+	if len(targetModel) > 0 {
+		SetPointer(targetModel[0], model)
+	}
+
+	return model, nil
+}
+
+func (c *BaseCursor) Close() apperror.Error {
+	c.buffer = nil
+	if c.done {
+		return nil
+	}
+	c.done = true
+	return c.rows.Close()
+}
+
+// fill reads the next batch of rows and prepares the models.
+func (c *BaseCursor) fill() apperror.Error {
+	rows := make([]interface{}, 0, CURSOR_BATCH_SIZE)
+	for len(rows) < CURSOR_BATCH_SIZE {
+		row, err := c.rows.Next()
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if row == nil {
+			// No more rows.
+			if err := c.Close(); err != nil {
+				return err
+			}
+			break
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	models := rows
+	if _, isMapData := rows[0].(map[string]interface{}); isMapData && c.info.HasStruct() {
+		// Received map data, so convert to models first.
+		models = make([]interface{}, len(rows), len(rows))
+		for i, data := range rows {
+			model, err := c.info.ModelFromMap(data.(map[string]interface{}))
+			if err != nil {
+				c.Close()
+				return err
+			}
+			models[i] = model
+		}
+	}
+
+	if len(c.query.GetJoins()) > 0 {
+		// The join queries are modified when executed, so they have to be
+		// cloned for each batch.
+		joinQ := c.query.Clone()
+		joinQ.rawResult = rows
+		if err := c.backend.DoJoins(c.info, joinQ, models); err != nil {
+			c.Close()
+			return err
+		}
+	}
+
+	// Remember the loaded values for dirty tracking, and call after query hook.
+	for _, model := range models {
+		if err := takeSnapshot(c.info, model); err != nil {
+			c.Close()
+			return err
+		}
+		if err := CallModelHook(c.backend.backend, model, "AfterQuery"); err != nil {
+			c.Close()
+			return err
+		}
+	}
+
+	c.buffer = models
+	return nil
+}
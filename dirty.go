@@ -0,0 +1,209 @@
+package dukedb
+
+import (
+	"reflect"
+
+	"github.com/theduke/go-apperror"
+
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Dirty tracking.
+ */
+
+// FieldChange describes the change of a single field since the model was
+// loaded.
+// The values are in the form they are written to the backend.
+type FieldChange struct {
+	Old interface{}
+	New interface{}
+}
+
+// takeSnapshot stores the current values of a model that supports dirty
+// tracking.
+// If fields are given, only their values are updated in an existing snapshot.
+func takeSnapshot(info *ModelInfo, model interface{}, fields ...string) apperror.Error {
+	hook, ok := model.(ModelSnapshotHook)
+	if !ok {
+		return nil
+	}
+
+	data, err := info.ModelToMap(model, true, false, false)
+	if err != nil {
+		return err
+	}
+	// Slices and maps are copied, so that changing them in place is detected.
+	for name, val := range data {
+		data[name] = copySnapshotValue(val)
+	}
+
+	if len(fields) > 0 {
+		old := hook.Snapshot()
+		if old == nil {
+			// The model is not tracked, so there is nothing to update.
+			return nil
+		}
+
+		// Snapshots may be shared by copies of the model, so a new map is built.
+		snapshot := make(map[string]interface{}, len(old))
+		for name, val := range old {
+			snapshot[name] = val
+		}
+		for _, name := range fields {
+			if val, ok := data[name]; ok {
+				snapshot[name] = val
+			} else {
+				delete(snapshot, name)
+			}
+		}
+		data = snapshot
+	}
+
+	hook.SetSnapshot(data)
+	return nil
+}
+
+// copySnapshotValue returns a deep copy of slices and maps.
+// Other values are returned unchanged.
+func copySnapshotValue(val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return val
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copySnapshotReflectValue(v.Index(i)))
+		}
+		return copied.Interface()
+
+	case reflect.Map:
+		if v.IsNil() {
+			return val
+		}
+		copied := reflect.MakeMap(v.Type())
+		for _, key := range v.MapKeys() {
+			copied.SetMapIndex(key, copySnapshotReflectValue(v.MapIndex(key)))
+		}
+		return copied.Interface()
+	}
+
+	return val
+}
+
+// copySnapshotReflectValue deep copies an element of a slice or map.
+func copySnapshotReflectValue(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(reflect.ValueOf(copySnapshotValue(v.Interface())))
+		return copied
+	}
+	if !v.CanInterface() || (v.Kind() != reflect.Slice && v.Kind() != reflect.Map) {
+		return v
+	}
+	return reflect.ValueOf(copySnapshotValue(v.Interface()))
+}
+
+// modelChanges returns the backend data of all fields that changed since the
+// snapshot was taken.
+// Models without a snapshot have all fields changed.
+func modelChanges(info *ModelInfo, model interface{}) (map[string]interface{}, map[string]interface{}, apperror.Error) {
+	data, err := info.ModelToMap(model, true, false, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var snapshot map[string]interface{}
+	if hook, ok := model.(ModelSnapshotHook); ok {
+		snapshot = hook.Snapshot()
+	}
+	if snapshot == nil {
+		return data, nil, nil
+	}
+
+	changes := make(map[string]interface{})
+	for name, val := range data {
+		if old, ok := snapshot[name]; !ok || !reflect.DeepEqual(old, val) {
+			changes[name] = val
+		}
+	}
+	// Fields that are ignored if zero are missing from the data when they
+	// were reset to zero. Marshalled fields are reset to null.
+	for name := range snapshot {
+		if _, ok := data[name]; ok {
+			continue
+		}
+		if attr := info.FindAttribute(name); attr != nil && !attr.BackendMarshal() {
+			changes[name] = reflect.Zero(attr.Type()).Interface()
+		} else {
+			changes[name] = nil
+		}
+	}
+	return changes, snapshot, nil
+}
+
+// modelUpdateValues returns the field expressions for an update of a model.
+// For models with a snapshot, only the changed fields are included.
+func modelUpdateValues(info *ModelInfo, model interface{}) ([]*FieldValueExpr, apperror.Error) {
+	changes, _, err := modelChanges(info, model)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*FieldValueExpr, 0, len(changes))
+	for name, val := range changes {
+		values = append(values, NewFieldVal(name, val))
+	}
+	return values, nil
+}
+
+// Changes returns the fields of a model that changed since it was loaded,
+// mapped by the struct field name.
+// Models without a snapshot have all fields changed.
+func (b *BaseBackend) Changes(model interface{}) (map[string]*FieldChange, apperror.Error) {
+	info, err := b.backend.InfoForModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, snapshot, err := modelChanges(info, model)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*FieldChange, len(changes))
+	for name, val := range changes {
+		attr := info.FindAttribute(name)
+		result[attr.Name()] = &FieldChange{
+			Old: snapshot[name],
+			New: val,
+		}
+	}
+	return result, nil
+}
+
+// IsDirty returns true if the field of a model changed since it was loaded.
+// The field may be given by its struct field name or its backend name.
+func (b *BaseBackend) IsDirty(model interface{}, field string) bool {
+	changes, err := b.Changes(model)
+	if err != nil {
+		return false
+	}
+
+	info, _ := b.backend.InfoForModel(model)
+	attr := info.FindAttribute(field)
+	if attr == nil {
+		return false
+	}
+	_, ok := changes[attr.Name()]
+	return ok
+}
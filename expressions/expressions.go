@@ -569,6 +569,61 @@ func NewReferenceConstraint(collection, field string) *ReferenceConstraint {
 	}
 }
 
+/**
+ * ForeignKeyConstraint.
+ */
+
+// ForeignKeyConstraint is a collection constraint that references a field
+// of another collection, with optional actions for updates and deletes.
+type ForeignKeyConstraint struct {
+	name      string
+	field     string
+	reference *ReferenceConstraint
+	actions   []*ActionConstraint
+}
+
+func (c *ForeignKeyConstraint) Name() string {
+	return c.name
+}
+
+func (c *ForeignKeyConstraint) Field() string {
+	return c.field
+}
+
+func (c *ForeignKeyConstraint) Reference() *ReferenceConstraint {
+	return c.reference
+}
+
+func (c *ForeignKeyConstraint) Actions() []*ActionConstraint {
+	return c.actions
+}
+
+func (c *ForeignKeyConstraint) Validate() apperror.Error {
+	if c.field == "" {
+		return apperror.New("empty_field")
+	} else if c.reference == nil {
+		return apperror.New("no_reference")
+	}
+	if err := c.reference.Validate(); err != nil {
+		return err
+	}
+	for _, action := range c.actions {
+		if err := action.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewForeignKeyConstraint(name, field, refCollection, refField string, actions ...*ActionConstraint) *ForeignKeyConstraint {
+	return &ForeignKeyConstraint{
+		name:      name,
+		field:     field,
+		reference: NewReferenceConstraint(refCollection, refField),
+		actions:   actions,
+	}
+}
+
 /**
  * UniqueFieldsConstraint.
  */
@@ -728,6 +783,174 @@ func NewFuncExpr(function string, expr Expression) *FunctionExpr {
 	return e
 }
 
+/**
+ * ArithmeticExpression.
+ */
+
+const (
+	ARITHMETIC_ADD = "+"
+	ARITHMETIC_SUB = "-"
+	ARITHMETIC_MUL = "*"
+	ARITHMETIC_DIV = "/"
+)
+
+// ArithmeticExpr combines two expressions with an arithmetic operator,
+// for example to increment a field in an update.
+type ArithmeticExpr struct {
+	left     Expression
+	operator string
+	right    Expression
+}
+
+func (e *ArithmeticExpr) Left() Expression {
+	return e.left
+}
+
+func (e *ArithmeticExpr) Operator() string {
+	return e.operator
+}
+
+func (e *ArithmeticExpr) Right() Expression {
+	return e.right
+}
+
+func (e *ArithmeticExpr) Validate() apperror.Error {
+	if e.left == nil || e.right == nil {
+		return apperror.New("empty_arithmetic_operand")
+	}
+
+	switch e.operator {
+	case ARITHMETIC_ADD, ARITHMETIC_SUB, ARITHMETIC_MUL, ARITHMETIC_DIV:
+	default:
+		return apperror.New("invalid_arithmetic_operator", fmt.Sprintf("Unknown arithmetic operator %v", e.operator))
+	}
+
+	return nil
+}
+
+func (e *ArithmeticExpr) GetIdentifiers() []Expression {
+	return append(getIdentifiers(e.left), getIdentifiers(e.right)...)
+}
+
+func NewArithmeticExpr(left Expression, operator string, right Expression) *ArithmeticExpr {
+	return &ArithmeticExpr{
+		left:     left,
+		operator: operator,
+		right:    right,
+	}
+}
+
+func Add(left, right Expression) *ArithmeticExpr {
+	return NewArithmeticExpr(left, ARITHMETIC_ADD, right)
+}
+
+func Sub(left, right Expression) *ArithmeticExpr {
+	return NewArithmeticExpr(left, ARITHMETIC_SUB, right)
+}
+
+func Mul(left, right Expression) *ArithmeticExpr {
+	return NewArithmeticExpr(left, ARITHMETIC_MUL, right)
+}
+
+func Div(left, right Expression) *ArithmeticExpr {
+	return NewArithmeticExpr(left, ARITHMETIC_DIV, right)
+}
+
+/**
+ * NowExpression.
+ */
+
+// NowExpr evaluates to the current time of the backend.
+type NowExpr struct{}
+
+func Now() *NowExpr {
+	return &NowExpr{}
+}
+
+/**
+ * Update value helpers.
+ */
+
+// FieldRef references a field, for use in value expressions.
+func FieldRef(field string) *IdentifierExpr {
+	return NewIdExpr(field)
+}
+
+// Value wraps a literal value, for use in value expressions.
+func Value(value interface{}) *ValueExpr {
+	return NewValueExpr(value)
+}
+
+// Expr sets a field to the result of an expression in an update.
+func Expr(field string, value Expression) *FieldValueExpr {
+	return NewFieldValExpr(NewIdExpr(field), value)
+}
+
+// Incr atomically increments a field in an update.
+func Incr(field string, amount interface{}) *FieldValueExpr {
+	return Expr(field, Add(FieldRef(field), Value(amount)))
+}
+
+// Decr atomically decrements a field in an update.
+func Decr(field string, amount interface{}) *FieldValueExpr {
+	return Expr(field, Sub(FieldRef(field), Value(amount)))
+}
+
+/**
+ * Aggregate functions.
+ */
+
+const (
+	AGGREGATE_COUNT = "COUNT"
+	AGGREGATE_SUM   = "SUM"
+	AGGREGATE_AVG   = "AVG"
+	AGGREGATE_MIN   = "MIN"
+	AGGREGATE_MAX   = "MAX"
+)
+
+var AGGREGATE_FUNCTIONS map[string]bool = map[string]bool{
+	AGGREGATE_COUNT: true,
+	AGGREGATE_SUM:   true,
+	AGGREGATE_AVG:   true,
+	AGGREGATE_MIN:   true,
+	AGGREGATE_MAX:   true,
+}
+
+// IsAggregate returns true if the expression is an aggregate function.
+func IsAggregate(expr Expression) bool {
+	f, ok := expr.(*FunctionExpr)
+	return ok && AGGREGATE_FUNCTIONS[strings.ToUpper(f.Function())]
+}
+
+func newAggregate(function, field string) *FunctionExpr {
+	if field == "" || field == "*" {
+		return NewFuncExpr(function, NewTextExpr("*"))
+	}
+	return NewFuncExpr(function, NewIdExpr(field))
+}
+
+// Count counts the values of a field.
+// If field is empty or "*", all items are counted.
+func Count(field string) *FunctionExpr {
+	return newAggregate(AGGREGATE_COUNT, field)
+}
+
+func Sum(field string) *FunctionExpr {
+	return newAggregate(AGGREGATE_SUM, field)
+}
+
+func Avg(field string) *FunctionExpr {
+	return newAggregate(AGGREGATE_AVG, field)
+}
+
+func Min(field string) *FunctionExpr {
+	return newAggregate(AGGREGATE_MIN, field)
+}
+
+func Max(field string) *FunctionExpr {
+	return newAggregate(AGGREGATE_MAX, field)
+}
+
 /**
  * Logical AND, OR, NOT expressions.
  */
@@ -820,38 +1043,61 @@ func NewNotExpr(expr ...Expression) *NotExpr {
  */
 
 const (
-	OPERATOR_EQ   = "="
-	OPERATOR_NEQ  = "!="
-	OPERATOR_LIKE = "like"
-	OPERATOR_IN   = "in"
-	OPERATOR_GT   = ">"
-	OPERATOR_GTE  = ">="
-	OPERATOR_LT   = "<"
-	OPERATOR_LTE  = "<="
+	OPERATOR_EQ       = "="
+	OPERATOR_NEQ      = "!="
+	OPERATOR_LIKE     = "like"
+	OPERATOR_NLIKE    = "not like"
+	OPERATOR_ILIKE    = "ilike"
+	OPERATOR_REGEX    = "regex"
+	OPERATOR_IN       = "in"
+	OPERATOR_NIN      = "not in"
+	OPERATOR_GT       = ">"
+	OPERATOR_GTE      = ">="
+	OPERATOR_LT       = "<"
+	OPERATOR_LTE      = "<="
+	OPERATOR_BETWEEN  = "between"
+	OPERATOR_NULL     = "is null"
+	OPERATOR_NOT_NULL = "is not null"
+	OPERATOR_SIZE     = "size"
 )
 
 var OPERATOR_MAP map[string]string = map[string]string{
-	OPERATOR_EQ:   "eq",
-	OPERATOR_NEQ:  "neq",
-	OPERATOR_LIKE: "like",
-	OPERATOR_IN:   "in",
-	OPERATOR_GT:   "gt",
-	OPERATOR_GTE:  "gte",
-	OPERATOR_LT:   "lt",
-	OPERATOR_LTE:  "lte",
+	OPERATOR_EQ:       "eq",
+	OPERATOR_NEQ:      "neq",
+	OPERATOR_LIKE:     "like",
+	OPERATOR_NLIKE:    "nlike",
+	OPERATOR_ILIKE:    "ilike",
+	OPERATOR_REGEX:    "regex",
+	OPERATOR_IN:       "in",
+	OPERATOR_NIN:      "nin",
+	OPERATOR_GT:       "gt",
+	OPERATOR_GTE:      "gte",
+	OPERATOR_LT:       "lt",
+	OPERATOR_LTE:      "lte",
+	OPERATOR_BETWEEN:  "between",
+	OPERATOR_NULL:     "null",
+	OPERATOR_NOT_NULL: "notnull",
+	OPERATOR_SIZE:     "size",
 }
 
 func MapOperator(op string) string {
-	switch strings.ToLower(op) {
+	op = strings.ToLower(op)
+	switch op {
 	case "==":
 		return "="
-	case "=", "!=", "<", "<=", ">", ">=", "like", "in":
+	case "=", "!=", "<", "<=", ">", ">=", "like", "not like", "ilike", "regex",
+		"in", "not in", "between", "is null", "is not null", "size":
 		return op
 	default:
 		return ""
 	}
 }
 
+// IsNullOperator returns true for operators that do not need a clause.
+func IsNullOperator(op string) bool {
+	return op == OPERATOR_NULL || op == OPERATOR_NOT_NULL
+}
+
 /**
  * FilterExpression.
  */
@@ -892,7 +1138,7 @@ func (e *Filter) Validate() apperror.Error {
 		return apperror.New("empty_operator")
 	} else if _, ok := OPERATOR_MAP[e.operator]; !ok {
 		return apperror.New("unknown_operator", fmt.Sprintf("Unknown operator %v", e.operator))
-	} else if e.clause == nil {
+	} else if e.clause == nil && !IsNullOperator(e.operator) {
 		return apperror.New("empty_clause")
 	}
 	return nil
@@ -959,10 +1205,186 @@ func Like(collection, field string, val interface{}) *Filter {
  * In.
  */
 
+// In filters by a slice of values, or by the results of a *SelectStmt
+// which selects a single field.
 func In(collection, field string, val interface{}) *Filter {
+	if stmt, ok := val.(*SelectStmt); ok {
+		return NewFieldFilter(collection, field, OPERATOR_IN, stmt)
+	}
 	return NewFieldValFilter(collection, field, OPERATOR_IN, val)
 }
 
+/**
+ * Not in.
+ */
+
+func NotIn(collection, field string, val interface{}) *Filter {
+	if stmt, ok := val.(*SelectStmt); ok {
+		return NewFieldFilter(collection, field, OPERATOR_NIN, stmt)
+	}
+	return NewFieldValFilter(collection, field, OPERATOR_NIN, val)
+}
+
+/**
+ * Exists.
+ */
+
+// ExistsExpr matches if the sub query returns at least one row.
+type ExistsExpr struct {
+	nestedExprMixin
+}
+
+func (e *ExistsExpr) SelectStmt() *SelectStmt {
+	stmt, _ := e.expression.(*SelectStmt)
+	return stmt
+}
+
+func (e *ExistsExpr) Validate() apperror.Error {
+	if e.expression == nil {
+		return apperror.New("empty_select_stmt")
+	}
+	return nil
+}
+
+func NewExistsExpr(stmt *SelectStmt) *ExistsExpr {
+	e := &ExistsExpr{}
+	e.expression = stmt
+	return e
+}
+
+/**
+ * Not like.
+ */
+
+func NotLike(collection, field string, val interface{}) *Filter {
+	return NewFieldValFilter(collection, field, OPERATOR_NLIKE, val)
+}
+
+/**
+ * Case insensitive like.
+ */
+
+func ILike(collection, field string, val interface{}) *Filter {
+	return NewFieldValFilter(collection, field, OPERATOR_ILIKE, val)
+}
+
+/**
+ * Regex.
+ */
+
+// Regex matches a field against a regular expression.
+// The syntax of the expression depends on the backend.
+func Regex(collection, field string, pattern string) *Filter {
+	return NewFieldValFilter(collection, field, OPERATOR_REGEX, pattern)
+}
+
+/**
+ * Between.
+ */
+
+// Between matches values between from and to, including both bounds.
+func Between(collection, field string, from, to interface{}) *Filter {
+	return NewFieldValFilter(collection, field, OPERATOR_BETWEEN, []interface{}{from, to})
+}
+
+/**
+ * Null.
+ */
+
+func IsNull(collection, field string) *Filter {
+	return NewFieldFilter(collection, field, OPERATOR_NULL, nil)
+}
+
+func IsNotNull(collection, field string) *Filter {
+	return NewFieldFilter(collection, field, OPERATOR_NOT_NULL, nil)
+}
+
+/**
+ * Size.
+ */
+
+// Size matches array fields with the given number of elements.
+// SQL backends store arrays marshalled. Postgres filters the marshalled
+// json, the other SQL dialects return a public unsupported_operator error.
+func Size(collection, field string, size int) *Filter {
+	return NewFieldValFilter(collection, field, OPERATOR_SIZE, size)
+}
+
+/**
+ * ElemMatch.
+ */
+
+// ELEM_MATCH_SELF is the identifier that references the array element
+// itself in the filter of an ElemMatchExpr.
+const ELEM_MATCH_SELF = "$elem"
+
+// ElemMatchExpr matches array fields that contain at least one element
+// matching the filter.
+// Identifiers in the filter reference fields of the element, or the element
+// itself with ELEM_MATCH_SELF.
+// Like Size filters, element match filters are only supported by the
+// Postgres dialect of the SQL backend.
+type ElemMatchExpr struct {
+	field  Expression
+	filter Expression
+}
+
+func (e *ElemMatchExpr) Field() Expression {
+	return e.field
+}
+
+func (e *ElemMatchExpr) SetField(field Expression) {
+	e.field = field
+}
+
+func (e *ElemMatchExpr) Filter() Expression {
+	return e.filter
+}
+
+func (e *ElemMatchExpr) Validate() apperror.Error {
+	if e.field == nil {
+		return apperror.New("empty_field")
+	} else if e.filter == nil {
+		return apperror.New("empty_filter")
+	}
+	return nil
+}
+
+func (e *ElemMatchExpr) GetIdentifiers() []Expression {
+	return getIdentifiers(e.field)
+}
+
+// MatchesScalars returns true if the filter only references the element
+// itself, and not fields of the element.
+func (e *ElemMatchExpr) MatchesScalars() bool {
+	return matchesScalars(e.filter)
+}
+
+func matchesScalars(filter Expression) bool {
+	switch f := filter.(type) {
+	case MultiExpression:
+		for _, expr := range f.Expressions() {
+			if !matchesScalars(expr) {
+				return false
+			}
+		}
+		return true
+	case *NotExpr:
+		return matchesScalars(f.Not())
+	case FilterExpression:
+		id, ok := f.Field().(*IdentifierExpr)
+		return ok && id.Identifier() == ELEM_MATCH_SELF
+	}
+	return false
+}
+
+func NewElemMatchExpr(field Expression, filter Expression) *ElemMatchExpr {
+	return &ElemMatchExpr{
+		field:  field,
+		filter: filter,
+	}
+}
+
 /**
  * Less than Lt.
  */
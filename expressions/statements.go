@@ -169,6 +169,41 @@ func NewCreateFieldStmt(collection string, field *FieldExpr) *CreateFieldStmt {
 	}
 }
 
+/**
+ * AddConstraintStatement.
+ */
+
+// AddConstraintStmt adds a constraint, like a ForeignKeyConstraint, to an
+// existing collection.
+type AddConstraintStmt struct {
+	collection string
+	constraint Expression
+}
+
+func (s *AddConstraintStmt) Collection() string {
+	return s.collection
+}
+
+func (s *AddConstraintStmt) Constraint() Expression {
+	return s.constraint
+}
+
+func (e *AddConstraintStmt) Validate() apperror.Error {
+	if e.collection == "" {
+		return apperror.New("empty_collection")
+	} else if e.constraint == nil {
+		return apperror.New("empty_constraint")
+	}
+	return nil
+}
+
+func NewAddConstraintStmt(collection string, constraint Expression) *AddConstraintStmt {
+	return &AddConstraintStmt{
+		collection: collection,
+		constraint: constraint,
+	}
+}
+
 /**
  * RenameFieldStatement.
  */
@@ -315,12 +350,24 @@ type DropIndexStmt struct {
 	name     string
 	ifExists bool
 	cascade  bool
+
+	// collection is optional, and only needed by databases that require the
+	// table name for dropping an index.
+	collection string
 }
 
 func (s *DropIndexStmt) IndexName() string {
 	return s.name
 }
 
+func (s *DropIndexStmt) Collection() string {
+	return s.collection
+}
+
+func (s *DropIndexStmt) SetCollection(col string) {
+	s.collection = col
+}
+
 func (s *DropIndexStmt) IfExists() bool {
 	return s.ifExists
 }
@@ -362,6 +409,9 @@ type SelectStmt struct {
 	offset int
 
 	joins []*JoinStmt
+
+	groupBy []Expression
+	having  Expression
 }
 
 func NewSelectStmt(collection string) *SelectStmt {
@@ -482,6 +532,153 @@ func (s *SelectStmt) AddJoin(join *JoinStmt) {
 	s.joins = append(s.joins, join)
 }
 
+/**
+ * GroupBy.
+ */
+
+func (s *SelectStmt) GroupBy() []Expression {
+	return s.groupBy
+}
+
+func (s *SelectStmt) SetGroupBy(exprs []Expression) {
+	s.groupBy = exprs
+}
+
+func (s *SelectStmt) AddGroupBy(exprs ...Expression) {
+	s.groupBy = append(s.groupBy, exprs...)
+}
+
+/**
+ * Having.
+ */
+
+// Having returns the filter applied to grouped results.
+// Identifiers that match the name of an aggregate field selector refer to
+// the aggregate.
+func (s *SelectStmt) Having() Expression {
+	return s.having
+}
+
+func (s *SelectStmt) SetHaving(having Expression) {
+	s.having = having
+}
+
+func (s *SelectStmt) HavingAnd(filter Expression) {
+	if s.having == nil {
+		s.having = filter
+	} else if andExpr, ok := s.having.(*AndExpr); ok {
+		andExpr.Add(filter)
+	} else {
+		s.having = NewAndExpr(s.having, filter)
+	}
+}
+
+// IsAggregate returns true if the statement groups results or selects
+// aggregate functions.
+func (s *SelectStmt) IsAggregate() bool {
+	if len(s.groupBy) > 0 {
+		return true
+	}
+	for _, field := range s.fields {
+		if sel, ok := field.(*FieldSelectorExpr); ok && IsAggregate(sel.Expression()) {
+			return true
+		}
+		if IsAggregate(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregates returns the aggregate functions selected with a
+// FieldSelectorExpr, mapped by their name.
+func (s *SelectStmt) Aggregates() map[string]*FunctionExpr {
+	aggregates := make(map[string]*FunctionExpr)
+	for _, field := range s.fields {
+		if sel, ok := field.(*FieldSelectorExpr); ok && IsAggregate(sel.Expression()) {
+			aggregates[sel.Name()] = sel.Expression().(*FunctionExpr)
+		}
+	}
+	return aggregates
+}
+
+// ReplaceAggregateAliases returns a copy of a filter where identifiers that
+// match the name of an aggregate are replaced by the aggregate function.
+func ReplaceAggregateAliases(filter Expression, aggregates map[string]*FunctionExpr) Expression {
+	switch f := filter.(type) {
+	case *AndExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			exprs = append(exprs, ReplaceAggregateAliases(expr, aggregates))
+		}
+		return NewAndExpr(exprs...)
+
+	case *OrExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			exprs = append(exprs, ReplaceAggregateAliases(expr, aggregates))
+		}
+		return NewOrExpr(exprs...)
+
+	case *NotExpr:
+		return NewNotExpr(ReplaceAggregateAliases(f.Not(), aggregates))
+
+	case FilterExpression:
+		return NewFilter(ReplaceAggregateAliases(f.Field(), aggregates), f.Operator(), f.Clause())
+
+	case *IdentifierExpr:
+		if aggregate, ok := aggregates[f.Identifier()]; ok {
+			return aggregate
+		}
+
+	case *ColFieldIdentifierExpr:
+		if f.Collection() == "" {
+			if aggregate, ok := aggregates[f.Field()]; ok {
+				return aggregate
+			}
+		}
+	}
+
+	return filter
+}
+
+// Copy returns a copy of the statement.
+// Fields, sorts, joins, group by expressions and top level AND/OR filters are
+// copied, so the copy can be modified without affecting the original
+// statement.
+func (s *SelectStmt) Copy() *SelectStmt {
+	c := *s
+
+	c.fields = append([]Expression(nil), s.fields...)
+	c.groupBy = append([]Expression(nil), s.groupBy...)
+
+	c.sorts = nil
+	for _, sort := range s.sorts {
+		c.sorts = append(c.sorts, NewSortExpr(sort.Expression(), sort.Ascending()))
+	}
+
+	c.joins = nil
+	for _, join := range s.joins {
+		c.joins = append(c.joins, join.Copy())
+	}
+
+	c.filter = copyFilter(s.filter)
+	c.having = copyFilter(s.having)
+
+	return &c
+}
+
+// copyFilter copies a top level AND/OR filter.
+func copyFilter(filter Expression) Expression {
+	switch f := filter.(type) {
+	case *AndExpr:
+		return NewAndExpr(append([]Expression(nil), f.Expressions()...)...)
+	case *OrExpr:
+		return NewOrExpr(append([]Expression(nil), f.Expressions()...)...)
+	}
+	return filter
+}
+
 func (e *SelectStmt) Validate() apperror.Error {
 	if e.collection == "" {
 		return apperror.New("empty_collection")
@@ -505,6 +702,11 @@ func (s *SelectStmt) GetIdentifiers() []Expression {
 	for _, join := range s.joins {
 		ids = append(ids, join.GetIdentifiers()...)
 	}
+	// Group by.
+	for _, expr := range s.groupBy {
+		ids = append(ids, getIdentifiers(expr)...)
+	}
+	ids = append(ids, getIdentifiers(s.having)...)
 	return ids
 }
 
@@ -564,6 +766,15 @@ func (s *JoinStmt) SelectStatement() *SelectStmt {
 	return &s.SelectStmt
 }
 
+// Copy returns a copy of the join statement.
+func (s *JoinStmt) Copy() *JoinStmt {
+	return &JoinStmt{
+		SelectStmt:    *s.SelectStmt.Copy(),
+		joinType:      s.joinType,
+		joinCondition: s.joinCondition,
+	}
+}
+
 func (e *JoinStmt) Validate() apperror.Error {
 	if err := e.SelectStmt.Validate(); err != nil {
 		return err
@@ -650,13 +861,101 @@ func (s mutationStmt) GetIdentifiers() []Expression {
  * CreateStatement.
  */
 
+// CreateStmt inserts one or more rows.
+// The values of the first row are held in Values(), the values of additional
+// rows for multi-row inserts are added with AddRow().
 type CreateStmt struct {
 	mutationStmt
+
+	// rows holds the values of the additional rows.
+	rows [][]*FieldValueExpr
+	// rawValues holds the raw values of the additional rows.
+	rawValues []interface{}
 }
 
 // Ensure CreateStatement implements FieldedExpression.
 var _ FieldedExpression = (*CreateStmt)(nil)
 
+// Rows returns the values of all rows, including the first one.
+func (s *CreateStmt) Rows() [][]*FieldValueExpr {
+	rows := [][]*FieldValueExpr{s.values}
+	return append(rows, s.rows...)
+}
+
+// RawValues returns the raw values of all rows, including the first one.
+func (s *CreateStmt) RawValues() []interface{} {
+	values := []interface{}{s.rawValue}
+	return append(values, s.rawValues...)
+}
+
+// AddRow adds a row to the statement.
+// All rows must have values for the same fields.
+func (s *CreateStmt) AddRow(values []*FieldValueExpr, rawValue interface{}) {
+	if len(s.values) == 0 {
+		s.values = values
+		s.rawValue = rawValue
+		return
+	}
+	s.rows = append(s.rows, values)
+	s.rawValues = append(s.rawValues, rawValue)
+}
+
+// IsMultiRow returns true if the statement inserts more than one row.
+func (s *CreateStmt) IsMultiRow() bool {
+	return len(s.rows) > 0
+}
+
+// RowValue returns the value expression for the field in a row, or nil if the
+// row has no value for the field.
+func (s *CreateStmt) RowValue(row []*FieldValueExpr, field Expression) Expression {
+	name := fieldValueName(field)
+	for _, val := range row {
+		if fieldValueName(val.Field()) == name {
+			return val.Value()
+		}
+	}
+	return nil
+}
+
+func (s *CreateStmt) Validate() apperror.Error {
+	if err := s.mutationStmt.Validate(); err != nil {
+		return err
+	}
+
+	for _, row := range s.rows {
+		if len(row) != len(s.values) {
+			return apperror.New("invalid_row", "All rows of a multi-row insert must have the same fields")
+		}
+		for _, val := range s.values {
+			if s.RowValue(row, val.Field()) == nil {
+				return apperror.New("invalid_row", "All rows of a multi-row insert must have the same fields")
+			}
+		}
+	}
+	return nil
+}
+
+func (s *CreateStmt) GetIdentifiers() []Expression {
+	ids := s.mutationStmt.GetIdentifiers()
+	for _, row := range s.rows {
+		for _, val := range row {
+			ids = append(ids, getIdentifiers(val)...)
+		}
+	}
+	return ids
+}
+
+// fieldValueName returns the name of the field of a FieldValueExpr.
+func fieldValueName(field Expression) string {
+	switch f := field.(type) {
+	case *IdentifierExpr:
+		return f.Identifier()
+	case *ColFieldIdentifierExpr:
+		return f.Field()
+	}
+	return ""
+}
+
 func NewCreateStmt(collection string, values []*FieldValueExpr) *CreateStmt {
 	stmt := &CreateStmt{}
 	stmt.collection = collection
@@ -664,6 +963,139 @@ func NewCreateStmt(collection string, values []*FieldValueExpr) *CreateStmt {
 	return stmt
 }
 
+/**
+ * UpsertStatement.
+ */
+
+// UpsertStmt inserts a row, or updates the existing row if the insert
+// conflicts with a unique constraint on the conflict fields.
+type UpsertStmt struct {
+	mutationStmt
+
+	// conflictFields are the fields of the unique constraint.
+	conflictFields []string
+
+	// updateFields are the fields that are updated on conflict.
+	// If empty, all inserted fields except the conflict fields are updated.
+	updateFields []string
+
+	// doNothing keeps the existing row unchanged on conflict.
+	doNothing bool
+
+	// updateExpressions are set on conflict in addition to the update
+	// fields, like Incr() for a version field.
+	// Field references refer to the existing row.
+	updateExpressions []*FieldValueExpr
+}
+
+// Ensure UpsertStmt implements FieldedExpression.
+var _ FieldedExpression = (*UpsertStmt)(nil)
+
+func (s *UpsertStmt) ConflictFields() []string {
+	return s.conflictFields
+}
+
+func (s *UpsertStmt) SetConflictFields(fields []string) {
+	s.conflictFields = fields
+}
+
+func (s *UpsertStmt) UpdateFields() []string {
+	return s.updateFields
+}
+
+func (s *UpsertStmt) SetUpdateFields(fields []string) {
+	s.updateFields = fields
+}
+
+func (s *UpsertStmt) DoNothing() bool {
+	return s.doNothing
+}
+
+func (s *UpsertStmt) SetDoNothing(flag bool) {
+	s.doNothing = flag
+}
+
+// UpdateExpressions returns the expressions that are set on conflict in
+// addition to the UpdateValues().
+// It is empty if the statement does nothing on conflict.
+func (s *UpsertStmt) UpdateExpressions() []*FieldValueExpr {
+	if s.doNothing {
+		return nil
+	}
+	return s.updateExpressions
+}
+
+// AddUpdateExpression adds expressions that are set on conflict.
+func (s *UpsertStmt) AddUpdateExpression(values ...*FieldValueExpr) {
+	s.updateExpressions = append(s.updateExpressions, values...)
+}
+
+// UpdateValues returns the values that are updated on conflict, with the
+// inserted value.
+// Fields that have an update expression are not included.
+// It is empty if the statement does nothing on conflict.
+func (s *UpsertStmt) UpdateValues() []*FieldValueExpr {
+	values := make([]*FieldValueExpr, 0)
+	if s.doNothing {
+		return values
+	}
+
+	expressionFields := make([]string, 0)
+	for _, expr := range s.updateExpressions {
+		expressionFields = append(expressionFields, fieldValueName(expr.Field()))
+	}
+
+	for _, val := range s.values {
+		name := fieldValueName(val.Field())
+		if stringInSlice(expressionFields, name) {
+			continue
+		}
+		if len(s.updateFields) > 0 {
+			if stringInSlice(s.updateFields, name) {
+				values = append(values, val)
+			}
+		} else if !stringInSlice(s.conflictFields, name) {
+			values = append(values, val)
+		}
+	}
+	return values
+}
+
+func (s *UpsertStmt) Validate() apperror.Error {
+	if err := s.mutationStmt.Validate(); err != nil {
+		return err
+	} else if len(s.conflictFields) < 1 {
+		return apperror.New("no_conflict_fields")
+	}
+	return nil
+}
+
+// CreateStmt returns a CreateStmt for inserting the values.
+func (s *UpsertStmt) CreateStmt() *CreateStmt {
+	stmt := NewCreateStmt(s.collection, s.values)
+	stmt.SetRawValue(s.rawValue)
+	return stmt
+}
+
+// stringInSlice checks if the slice contains the string.
+func stringInSlice(slice []string, str string) bool {
+	for _, item := range slice {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+func NewUpsertStmt(collection string, values []*FieldValueExpr, conflictFields []string) *UpsertStmt {
+	stmt := &UpsertStmt{
+		conflictFields: conflictFields,
+	}
+	stmt.collection = collection
+	stmt.values = values
+	return stmt
+}
+
 /**
  * UpdateStatement.
  */
@@ -80,8 +80,14 @@ func (t *BaseTranslator) W(strs ...string) {
 }
 
 // WQ quotes an identifier and writes it to the buffer.
+// The identifier is quoted by the translator, so dialects can override
+// QuoteIdentifier().
 func (t *BaseTranslator) WQ(str string) {
-	t.W(t.QuoteIdentifier(str))
+	if t.translator != nil {
+		t.W(t.translator.QuoteIdentifier(str))
+	} else {
+		t.W(t.QuoteIdentifier(str))
+	}
 }
 
 // Reset the buffer.
@@ -225,6 +231,25 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 		t.WQ(fk.Field())
 		t.W(")")
 
+	case *ForeignKeyConstraint:
+		if e.Name() != "" {
+			t.W("CONSTRAINT ")
+			t.WQ(e.Name())
+			t.W(" ")
+		}
+		t.W("FOREIGN KEY (")
+		t.WQ(e.Field())
+		t.W(") ")
+		if err := t.translator.Translate(e.Reference()); err != nil {
+			return err
+		}
+		for _, action := range e.Actions() {
+			t.W(" ")
+			if err := t.translator.Translate(action); err != nil {
+				return err
+			}
+		}
+
 	case *FieldExpr:
 		t.WQ(e.Name())
 		t.W(" ")
@@ -258,6 +283,20 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 		}
 		t.W(")")
 
+	case *ArithmeticExpr:
+		t.W("(")
+		if err := t.translator.Translate(e.Left()); err != nil {
+			return err
+		}
+		t.W(" ", e.Operator(), " ")
+		if err := t.translator.Translate(e.Right()); err != nil {
+			return err
+		}
+		t.W(")")
+
+	case *NowExpr:
+		t.W("CURRENT_TIMESTAMP")
+
 	case *AndExpr:
 		lastIndex := len(e.Expressions()) - 1
 		if lastIndex > 0 {
@@ -301,42 +340,17 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 		}
 
 	case FilterExpression:
-		if err := t.translator.Translate(e.Field()); err != nil {
+		if err := t.TranslateFilter(e); err != nil {
 			return err
 		}
-		t.W(" ", e.Operator(), " ")
 
-		if e.Operator() != OPERATOR_IN {
-			if err := t.translator.Translate(e.Clause()); err != nil {
-				return err
-			}
-		} else {
-			val, ok := e.Clause().(*ValueExpr)
-			if !ok {
-				return apperror.New("invalid_in_filter")
-			}
+	case *ElemMatchExpr:
+		return apperror.New("unsupported_operator", "Element match filters are not supported by this backend", true)
 
-			r, err := reflector.Reflect(val.Value()).Slice()
-			if err != nil {
-				return apperror.New("invalid_in_filter_value")
-			}
-
-			if r.Len() < 1 {
-				return apperror.New("invalid_in_filter_no_values")
-			}
-
-			t.W("(")
-
-			lastIndex := r.Len() - 1
-			for i, item := range r.Items() {
-				t.W(t.translator.Placeholder())
-				if i < lastIndex {
-					t.W(",")
-				}
-
-				t.Arg(NewValueExpr(item.Interface(), item.Type()))
-			}
-			t.W(")")
+	case *ExistsExpr:
+		t.W("EXISTS ")
+		if err := t.translator.Translate(e.SelectStmt()); err != nil {
+			return err
 		}
 
 	case *SortExpr:
@@ -369,15 +383,11 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 		}
 
 		// Constraints.
-		lastIndex = len(e.Constraints()) - 1
-		for i, constraint := range e.Constraints() {
+		for _, constraint := range e.Constraints() {
 			t.W(", ")
 			if err := t.translator.Translate(constraint); err != nil {
 				return err
 			}
-			if i < lastIndex {
-				t.W(", ")
-			}
 		}
 
 		t.W(")")
@@ -418,6 +428,14 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 			return err
 		}
 
+	case *AddConstraintStmt:
+		t.W("ALTER TABLE ")
+		t.WQ(e.Collection())
+		t.W(" ADD ")
+		if err := t.translator.Translate(e.Constraint()); err != nil {
+			return err
+		}
+
 	case *RenameFieldStmt:
 		t.W("ALTER TABLE ")
 		t.WQ(e.Collection())
@@ -474,6 +492,10 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 		t.W("SELECT ")
 
 		// Field expressions.
+		if len(e.Fields()) < 1 {
+			// Sub queries are not prepared, so they might not have fields.
+			t.W("*")
+		}
 		lastIndex := len(e.Fields()) - 1
 		for i, expr := range e.Fields() {
 			if err := t.translator.Translate(expr); err != nil {
@@ -520,6 +542,29 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 			}
 		}
 
+		if len(e.GroupBy()) > 0 {
+			t.W(" GROUP BY ")
+			lastIndex := len(e.GroupBy()) - 1
+			for i, expr := range e.GroupBy() {
+				if err := t.translator.Translate(expr); err != nil {
+					return err
+				}
+				if i < lastIndex {
+					t.W(", ")
+				}
+			}
+		}
+
+		if e.Having() != nil {
+			// Not all databases allow aliases in HAVING, so aggregate names
+			// are replaced with the aggregate function.
+			t.W(" HAVING ")
+			having := ReplaceAggregateAliases(e.Having(), e.Aggregates())
+			if err := t.translator.Translate(having); err != nil {
+				return err
+			}
+		}
+
 		if len(e.Sorts()) > 0 {
 			t.W(" ORDER BY ")
 			lastIndex := len(e.Sorts()) - 1
@@ -567,16 +612,70 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 				t.W(", ")
 			}
 		}
-		t.W(") VALUES(")
-		for i, field := range e.Values() {
-			if err := t.translator.Translate(field.Value()); err != nil {
-				return err
+		t.W(") VALUES")
+		for rowIndex, row := range e.Rows() {
+			if rowIndex > 0 {
+				t.W(", ")
 			}
-			if i < lastIndex {
-				t.W(",")
+			t.W("(")
+			// Values are written in the field order of the first row.
+			for i, field := range e.Values() {
+				value := field.Value()
+				if rowIndex > 0 {
+					if value = e.RowValue(row, field.Field()); value == nil {
+						return apperror.New("invalid_row", "All rows of a multi-row insert must have the same fields")
+					}
+				}
+				if err := t.translator.Translate(value); err != nil {
+					return err
+				}
+				if i < lastIndex {
+					t.W(",")
+				}
+			}
+			t.W(")")
+		}
+
+	case *UpsertStmt:
+		// Renders the standard ON CONFLICT clause.
+		if err := t.Translate(e.CreateStmt()); err != nil {
+			return err
+		}
+
+		t.W(" ON CONFLICT (")
+		for i, field := range e.ConflictFields() {
+			if i > 0 {
+				t.W(", ")
+			}
+			t.WQ(field)
+		}
+		t.W(") ")
+
+		values := e.UpdateValues()
+		expressions := e.UpdateExpressions()
+		if len(values) < 1 && len(expressions) < 1 {
+			t.W("DO NOTHING")
+			return nil
+		}
+
+		t.W("DO UPDATE SET ")
+		for i, val := range values {
+			if i > 0 {
+				t.W(", ")
+			}
+			name := fieldValueName(val.Field())
+			t.WQ(name)
+			t.W(" = excluded.")
+			t.WQ(name)
+		}
+		for i, val := range expressions {
+			if i > 0 || len(values) > 0 {
+				t.W(", ")
+			}
+			if err := t.translator.Translate(val); err != nil {
+				return err
 			}
 		}
-		t.W(")")
 
 	case *UpdateStmt:
 		t.W("UPDATE ")
@@ -666,6 +765,112 @@ func (t *SqlTranslator) Translate(expression Expression) apperror.Error {
 	return nil
 }
 
+// TranslateFilter translates a filter expression.
+// Dialects can override single operators and use TranslateFilter for all
+// others.
+func (t *SqlTranslator) TranslateFilter(e FilterExpression) apperror.Error {
+	if e.Operator() == OPERATOR_SIZE {
+		return apperror.New("unsupported_operator", "Size filters are not supported by this backend", true)
+	}
+
+	field := e.Field()
+	if e.Operator() == OPERATOR_ILIKE {
+		// Generic case insensitive LIKE.
+		field = NewFuncExpr("LOWER", field)
+	}
+	if err := t.translator.Translate(field); err != nil {
+		return err
+	}
+
+	switch e.Operator() {
+	case OPERATOR_NULL, OPERATOR_NOT_NULL:
+		t.W(" ", strings.ToUpper(e.Operator()))
+		return nil
+
+	case OPERATOR_ILIKE:
+		t.W(" LIKE ")
+		return t.translator.Translate(NewFuncExpr("LOWER", e.Clause()))
+
+	case OPERATOR_REGEX:
+		t.W(" REGEXP ")
+		return t.translator.Translate(e.Clause())
+
+	case OPERATOR_BETWEEN:
+		t.W(" BETWEEN ")
+		values, err := BetweenValues(e.Clause())
+		if err != nil {
+			return err
+		}
+		if err := t.translator.Translate(values[0]); err != nil {
+			return err
+		}
+		t.W(" AND ")
+		return t.translator.Translate(values[1])
+
+	case OPERATOR_IN, OPERATOR_NIN:
+		t.W(" ", e.Operator(), " ")
+
+		if stmt, ok := e.Clause().(*SelectStmt); ok {
+			// Sub query.
+			if len(stmt.Fields()) != 1 {
+				return apperror.New("invalid_in_filter_subquery", "Sub queries for IN filters must select exactly one field")
+			}
+			return t.translator.Translate(stmt)
+		}
+
+		val, ok := e.Clause().(*ValueExpr)
+		if !ok {
+			return apperror.New("invalid_in_filter")
+		}
+
+		r, err := reflector.Reflect(val.Value()).Slice()
+		if err != nil {
+			return apperror.New("invalid_in_filter_value")
+		}
+
+		if r.Len() < 1 {
+			return apperror.New("invalid_in_filter_no_values")
+		}
+
+		t.W("(")
+
+		lastIndex := r.Len() - 1
+		for i, item := range r.Items() {
+			t.W(t.translator.Placeholder())
+			if i < lastIndex {
+				t.W(",")
+			}
+
+			t.Arg(NewValueExpr(item.Interface(), item.Type()))
+		}
+		t.W(")")
+		return nil
+	}
+
+	t.W(" ", e.Operator(), " ")
+	return t.translator.Translate(e.Clause())
+}
+
+// BetweenValues returns the two bounds of a between filter clause, which
+// must be a ValueExpr holding a slice with two items.
+func BetweenValues(clause Expression) ([]*ValueExpr, apperror.Error) {
+	val, ok := clause.(*ValueExpr)
+	if !ok {
+		return nil, apperror.New("invalid_between_filter")
+	}
+
+	r, err := reflector.Reflect(val.Value()).Slice()
+	if err != nil || r.Len() != 2 {
+		return nil, apperror.New("invalid_between_filter_value", "Between filters need a slice with two values")
+	}
+
+	values := make([]*ValueExpr, 0)
+	for _, item := range r.Items() {
+		values = append(values, NewValueExpr(item.Interface(), item.Type()))
+	}
+	return values, nil
+}
+
 func NewSqlTranslator(translator ExpressionTranslator) SqlTranslator {
 	t := SqlTranslator{
 		BaseTranslator: NewBaseTranslator(translator),
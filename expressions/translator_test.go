@@ -160,6 +160,27 @@ var _ = Describe("ExpressionTranslater", func() {
 			Expect(t.String()).To(Equal(sql))
 		})
 
+		It("Should translate ArithmeticExpression", func() {
+			sql := `"views" = ("views" + ?)`
+			expr := Incr("views", 1)
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+			Expect(t.Arguments()).To(Equal([]interface{}{1}))
+		})
+
+		It("Should translate nested ArithmeticExpression", func() {
+			sql := `(("a" - ?) * "b")`
+			expr := Mul(Sub(FieldRef("a"), Value(2)), FieldRef("b"))
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+		})
+
+		It("Should translate NowExpression", func() {
+			expr := Expr("updated_at", Now())
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(`"updated_at" = CURRENT_TIMESTAMP`))
+		})
+
 		/**
 		 * Logical expressions.
 		 */
@@ -338,6 +359,74 @@ var _ = Describe("ExpressionTranslater", func() {
 			Expect(t.String()).To(Equal(sql))
 		})
 
+		It("Should translate CreateStatement", func() {
+			sql := `INSERT INTO "col"("field1", "field2") VALUES(?,?)`
+
+			expr := NewCreateStmt("col", []*FieldValueExpr{NewFieldVal("field1", 1), NewFieldVal("field2", "a")})
+
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+			Expect(t.Arguments()).To(Equal([]interface{}{1, "a"}))
+		})
+
+		It("Should translate multi-row CreateStatement", func() {
+			sql := `INSERT INTO "col"("field1", "field2") VALUES(?,?), (?,?)`
+
+			expr := NewCreateStmt("col", nil)
+			expr.AddRow([]*FieldValueExpr{NewFieldVal("field1", 1), NewFieldVal("field2", "a")}, nil)
+			// Values of further rows are ordered by the fields of the first row.
+			expr.AddRow([]*FieldValueExpr{NewFieldVal("field2", "b"), NewFieldVal("field1", 2)}, nil)
+
+			Expect(expr.Validate()).ToNot(HaveOccurred())
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+			Expect(t.Arguments()).To(Equal([]interface{}{1, "a", 2, "b"}))
+		})
+
+		It("Should reject multi-row CreateStatement with different fields", func() {
+			expr := NewCreateStmt("col", nil)
+			expr.AddRow([]*FieldValueExpr{NewFieldVal("field1", 1)}, nil)
+			expr.AddRow([]*FieldValueExpr{NewFieldVal("field2", 2)}, nil)
+
+			Expect(expr.Validate()).To(HaveOccurred())
+		})
+
+		It("Should translate UpsertStatement", func() {
+			sql := `INSERT INTO "col"("field1", "field2") VALUES(?,?) ON CONFLICT ("field1") DO UPDATE SET "field2" = excluded."field2"`
+
+			expr := NewUpsertStmt("col", []*FieldValueExpr{NewFieldVal("field1", 1), NewFieldVal("field2", "a")}, []string{"field1"})
+
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+			Expect(t.Arguments()).To(Equal([]interface{}{1, "a"}))
+		})
+
+		It("Should translate UpsertStatement with DoNothing", func() {
+			sql := `INSERT INTO "col"("field1", "field2") VALUES(?,?) ON CONFLICT ("field1") DO NOTHING`
+
+			expr := NewUpsertStmt("col", []*FieldValueExpr{NewFieldVal("field1", 1), NewFieldVal("field2", "a")}, []string{"field1"})
+			expr.SetDoNothing(true)
+
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+		})
+
+		It("Should translate UpsertStatement with update expressions", func() {
+			sql := `INSERT INTO "col"("field1", "field2", "version") VALUES(?,?,?) ON CONFLICT ("field1") DO UPDATE SET "field2" = excluded."field2", "version" = ("col"."version" + ?)`
+
+			expr := NewUpsertStmt("col", []*FieldValueExpr{NewFieldVal("field1", 1), NewFieldVal("field2", "a"), NewFieldVal("version", 1)}, []string{"field1"})
+			expr.AddUpdateExpression(Expr("version", Add(NewColFieldIdExpr("col", "version"), Value(1))))
+
+			Expect(t.Translate(expr)).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(sql))
+			Expect(t.Arguments()).To(Equal([]interface{}{1, "a", 1, 1}))
+		})
+
+		It("Should reject UpsertStatement without conflict fields", func() {
+			expr := NewUpsertStmt("col", []*FieldValueExpr{NewFieldVal("field1", 1)}, nil)
+			Expect(expr.Validate()).To(HaveOccurred())
+		})
+
 	})
 })
 
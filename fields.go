@@ -3,6 +3,7 @@ package dukedb
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -34,6 +35,8 @@ type fieldTag struct {
 	primaryKey    bool
 	ignoreIfZero  bool
 	autoIncrement bool
+	version       bool
+	softDelete    bool
 	unique        bool
 	uniqueWith    []string
 	required      bool
@@ -42,6 +45,11 @@ type fieldTag struct {
 	defaultVal    string
 	min           float64
 	max           float64
+	email         bool
+	url           bool
+	uuid          bool
+	pattern       string
+	enum          []string
 
 	marshal bool
 	embed   bool
@@ -58,6 +66,10 @@ type fieldTag struct {
 	autoCreate  bool
 	autoUpdate  bool
 	autoDelete  bool
+
+	foreignKey bool
+	onDelete   string
+	onUpdate   string
 }
 
 /**
@@ -79,12 +91,28 @@ type Field struct {
 	isRequired bool
 }
 
+// splitTag splits the content of a field tag at every semicolon that is not
+// escaped with a backslash.
+func splitTag(tagContent string) []string {
+	parts := make([]string, 0)
+	start := 0
+	for i := 0; i < len(tagContent); i++ {
+		if tagContent[i] == ';' && (i == 0 || tagContent[i-1] != '\\') {
+			parts = append(parts, tagContent[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tagContent[start:])
+}
+
 // Parse the information contained in a 'db:"xxx"' field tag.
+// Items are separated by semicolons, so a semicolon in a pattern must be
+// escaped with a backslash: `db:"pattern:^[^\\;]+$"`.
 func (f *Field) parseTag(tagContent string) apperror.Error {
 	tag := &fieldTag{}
 	f.tag = tag
 
-	parts := strings.Split(strings.TrimSpace(tagContent), ";")
+	parts := splitTag(strings.TrimSpace(tagContent))
 	for _, part := range parts {
 		if part == "" {
 			continue
@@ -130,6 +158,12 @@ func (f *Field) parseTag(tagContent string) apperror.Error {
 		case "auto-increment":
 			tag.autoIncrement = true
 
+		case "version":
+			tag.version = true
+
+		case "soft-delete":
+			tag.softDelete = true
+
 		case "unique":
 			tag.unique = true
 
@@ -169,6 +203,33 @@ func (f *Field) parseTag(tagContent string) apperror.Error {
 			}
 			tag.max = x
 
+		case "email":
+			tag.email = true
+
+		case "url":
+			tag.url = true
+
+		case "uuid":
+			tag.uuid = true
+
+		case "pattern":
+			// Patterns may contain colons, and escaped semicolons, which
+			// the regexp matches as a literal semicolon.
+			pattern := strings.Join(itemParts[1:], ":")
+			if pattern == "" {
+				return apperror.New("invalid_pattern", "pattern specifier must be in format pattern:regex")
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return apperror.Wrap(err, "invalid_pattern", fmt.Sprintf("Invalid pattern %v", pattern))
+			}
+			tag.pattern = pattern
+
+		case "enum":
+			if value == "" {
+				return apperror.New("invalid_enum", "enum specifier must be in format enum:a,b,c")
+			}
+			tag.enum = strings.Split(value, ",")
+
 		case "marshal":
 			tag.marshal = true
 
@@ -227,6 +288,22 @@ func (f *Field) parseTag(tagContent string) apperror.Error {
 		case "auto-delete":
 			tag.autoDelete = true
 
+		case "foreign-key":
+			tag.foreignKey = true
+
+		case "on-delete", "on-update":
+			action := strings.Replace(value, "-", "_", -1)
+			if _, ok := ACTIONS_MAP[action]; !ok {
+				return apperror.New("invalid_"+strings.Replace(specifier, "-", "_", -1),
+					fmt.Sprintf("%v must be one of cascade, restrict, set-null or set-default", specifier))
+			}
+			tag.foreignKey = true
+			if specifier == "on-delete" {
+				tag.onDelete = action
+			} else {
+				tag.onUpdate = action
+			}
+
 		default:
 			return apperror.New("invalid_tag", "Invalid field tag: %v", specifier)
 		}
@@ -344,6 +421,8 @@ type Attribute struct {
 	backendEmbed   bool
 	isPrimaryKey   bool
 	autoIncrement  bool
+	isVersion      bool
+	isSoftDelete   bool
 	isUnique       bool
 	isUniqueWith   []string
 	ignoreIfZero   bool
@@ -351,6 +430,11 @@ type Attribute struct {
 	indexName      string
 	min            float64
 	max            float64
+	isEmail        bool
+	isUrl          bool
+	isUuid         bool
+	pattern        *regexp.Regexp
+	enum           []string
 	defaultValue   interface{}
 }
 
@@ -382,6 +466,8 @@ func (a *Attribute) readTag() {
 	a.isPrimaryKey = tag.primaryKey
 	a.ignoreIfZero = tag.ignoreIfZero
 	a.autoIncrement = tag.autoIncrement
+	a.isVersion = tag.version
+	a.isSoftDelete = tag.softDelete
 	a.isUnique = tag.unique
 	a.isUniqueWith = tag.uniqueWith
 	a.isRequired = tag.required
@@ -392,6 +478,14 @@ func (a *Attribute) readTag() {
 	}
 	a.min = tag.min
 	a.max = tag.max
+	a.isEmail = tag.email
+	a.isUrl = tag.url
+	a.isUuid = tag.uuid
+	if tag.pattern != "" {
+		// The pattern was already checked when parsing the tag.
+		a.pattern = regexp.MustCompile(tag.pattern)
+	}
+	a.enum = tag.enum
 
 	a.backendMarshal = tag.marshal
 	a.backendEmbed = tag.embed
@@ -461,6 +555,34 @@ func (a *Attribute) SetAutoIncrement(val bool) {
 	a.autoIncrement = val
 }
 
+/**
+ * IsVersion.
+ */
+
+// IsVersion returns true if the attribute holds the version of the model for
+// optimistic locking.
+func (a *Attribute) IsVersion() bool {
+	return a.isVersion
+}
+
+func (a *Attribute) SetIsVersion(val bool) {
+	a.isVersion = val
+}
+
+/**
+ * IsSoftDelete.
+ */
+
+// IsSoftDelete returns true if the attribute holds the deletion time of soft
+// deleted models.
+func (a *Attribute) IsSoftDelete() bool {
+	return a.isSoftDelete
+}
+
+func (a *Attribute) SetIsSoftDelete(val bool) {
+	a.isSoftDelete = val
+}
+
 /**
  * IsUnique.
  */
@@ -545,6 +667,66 @@ func (a *Attribute) SetMax(val float64) {
 	a.max = val
 }
 
+/**
+ * IsEmail.
+ */
+
+func (a *Attribute) IsEmail() bool {
+	return a.isEmail
+}
+
+func (a *Attribute) SetIsEmail(x bool) {
+	a.isEmail = x
+}
+
+/**
+ * IsUrl.
+ */
+
+func (a *Attribute) IsUrl() bool {
+	return a.isUrl
+}
+
+func (a *Attribute) SetIsUrl(x bool) {
+	a.isUrl = x
+}
+
+/**
+ * IsUuid.
+ */
+
+func (a *Attribute) IsUuid() bool {
+	return a.isUuid
+}
+
+func (a *Attribute) SetIsUuid(x bool) {
+	a.isUuid = x
+}
+
+/**
+ * Pattern.
+ */
+
+func (a *Attribute) Pattern() *regexp.Regexp {
+	return a.pattern
+}
+
+func (a *Attribute) SetPattern(x *regexp.Regexp) {
+	a.pattern = x
+}
+
+/**
+ * Enum.
+ */
+
+func (a *Attribute) Enum() []string {
+	return a.enum
+}
+
+func (a *Attribute) SetEnum(x []string) {
+	a.enum = x
+}
+
 /**
  * DefaultValue.
  */
@@ -610,6 +792,11 @@ type Relation struct {
 	localField     string
 	foreignField   string
 	inversingField string
+
+	// foreignKey specifies if a foreign key constraint should be created.
+	foreignKey bool
+	onDelete   string
+	onUpdate   string
 }
 
 // buildRelation builds up a relation based on a field.
@@ -648,6 +835,10 @@ func (r *Relation) readTag() {
 	r.autoCreate = tag.autoCreate
 	r.autoUpdate = tag.autoUpdate
 	r.autoDelete = tag.autoDelete
+
+	r.foreignKey = tag.foreignKey
+	r.onDelete = tag.onDelete
+	r.onUpdate = tag.onUpdate
 	if tag.autoPersist {
 		r.autoCreate = true
 		r.autoUpdate = true
@@ -772,3 +963,45 @@ func (r *Relation) ForeignField() string {
 func (r *Relation) SetForeignField(val string) {
 	r.foreignField = val
 }
+
+/**
+ * ForeignKey.
+ */
+
+// HasForeignKey returns true if a foreign key constraint should be created
+// for the relation.
+func (r *Relation) HasForeignKey() bool {
+	return r.foreignKey
+}
+
+func (r *Relation) SetHasForeignKey(val bool) {
+	r.foreignKey = val
+}
+
+/**
+ * OnDelete.
+ */
+
+// OnDelete returns the action of the foreign key constraint when the
+// referenced item is deleted, like expressions.ACTION_CASCADE.
+func (r *Relation) OnDelete() string {
+	return r.onDelete
+}
+
+func (r *Relation) SetOnDelete(val string) {
+	r.onDelete = val
+}
+
+/**
+ * OnUpdate.
+ */
+
+// OnUpdate returns the action of the foreign key constraint when the
+// referenced field is updated.
+func (r *Relation) OnUpdate() string {
+	return r.onUpdate
+}
+
+func (r *Relation) SetOnUpdate(val string) {
+	r.onUpdate = val
+}
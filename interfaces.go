@@ -29,6 +29,19 @@ type Cursor interface {
 	// As with other query methods, you may pass a pointer to the model
 	// that should be filled with the data.
 	Next(targetModel ...interface{}) (interface{}, apperror.Error)
+
+	// Close releases all resources held by the cursor.
+	// It must be called if the cursor is not iterated until the end.
+	Close() apperror.Error
+}
+
+// RowIterator iterates over the raw result rows of a select statement.
+type RowIterator interface {
+	// Next returns the next row, or nil if no more rows are available.
+	Next() (interface{}, apperror.Error)
+
+	// Close releases all resources held by the iterator.
+	Close() apperror.Error
 }
 
 type JoinAssigner func(relation *Relation, joinQ *RelationQuery, resultQuery *Query, objs, joinedModels []interface{})
@@ -119,8 +132,18 @@ type Backend interface {
 	// The result will be nil for all statements except a SelectStatement.
 	Exec(statement Expression) apperror.Error
 
+	// ExecAffected executes an expression like Exec, and returns the number
+	// of affected rows, or -1 if the backend can not determine it.
+	ExecAffected(statement Expression) (affected int, err apperror.Error)
+
 	ExecQuery(statement FieldedExpression) (result []interface{}, err apperror.Error)
 
+	// ExecQueryIterator executes a select statement and returns an iterator
+	// over the result rows.
+	// Backends that can not stream results may return an iterator over the
+	// complete result.
+	ExecQueryIterator(statement FieldedExpression) (RowIterator, apperror.Error)
+
 	// Create the specified collection in the backend.
 	// (eg the table or the mongo collection)
 	CreateCollection(collection ...string) apperror.Error
@@ -170,6 +193,7 @@ type Backend interface {
 	Query(q *Query, targetSlice ...interface{}) ([]interface{}, apperror.Error)
 
 	// Executes a query, and returns a cursor.
+	// The cursor must be closed if it is not iterated until the end.
 	QueryCursor(q *Query) (Cursor, apperror.Error)
 
 	// Perform a query and get the first result.
@@ -219,14 +243,43 @@ type Backend interface {
 	// is new, or updates it otherwise.
 	Save(model interface{}) apperror.Error
 
+	// Upsert creates the model, or updates the existing model if the insert
+	// conflicts with a unique constraint on the conflict fields.
+	// The id of the model is set to the id of the created or existing model.
+	Upsert(model interface{}, conflictFields ...string) apperror.Error
+
+	// UpsertWithOptions is like Upsert, with control over the conflict
+	// handling.
+	UpsertWithOptions(model interface{}, options UpsertOptions) apperror.Error
+
 	// Updat all models matching a query by values in a map.
 	UpdateByMap(query *Query, data map[string]interface{}) apperror.Error
 
+	// UpdateMany updates all models matching a query with value expressions
+	// like Incr("views", 1).
+	UpdateMany(query *Query, values ...*FieldValueExpr) apperror.Error
+
 	// Delete deletes the model from the backend.
+	// Models with a soft-delete field are only marked as deleted.
 	Delete(model interface{}) apperror.Error
 
+	// ForceDelete permanently deletes a model, even if it is soft deletable.
+	ForceDelete(model interface{}) apperror.Error
+
+	// Restore undoes the soft delete of a model.
+	Restore(model interface{}) apperror.Error
+
 	// DeleteQ deletes all models that match the passed query.
 	DeleteMany(*Query) apperror.Error
+
+	// Changes returns the fields of a model that changed since it was
+	// loaded, mapped by the struct field name.
+	// Dirty tracking is only supported for models implementing
+	// ModelSnapshotHook. For other models, all fields are reported as changed.
+	Changes(model interface{}) (map[string]*FieldChange, apperror.Error)
+
+	// IsDirty returns true if the field of a model changed since it was loaded.
+	IsDirty(model interface{}, field string) bool
 }
 
 type HookHandler func(backend Backend, obj interface{}) apperror.Error
@@ -255,6 +308,25 @@ type TransactionBackend interface {
 	Backend
 	Begin() (Transaction, apperror.Error)
 	MustBegin() Transaction
+
+	// IsTransaction returns true if the backend is a transaction.
+	IsTransaction() bool
+}
+
+// RetryableErrorBackend is implemented by backends that can detect errors
+// after which a transaction can be retried, like serialization failures or
+// deadlocks.
+type RetryableErrorBackend interface {
+	IsRetryableError(err error) bool
+}
+
+// BatchCreateBackend is implemented by backends that can create multiple
+// models with a single multi-row CreateStmt.
+type BatchCreateBackend interface {
+	// CreateBatchSize returns the maximum number of rows of a multi-row
+	// insert into the collection, for rows with fieldCount fields.
+	// A size below 2 disables batching.
+	CreateBatchSize(info *ModelInfo, fieldCount int) int
 }
 
 type MigrationAttempt interface {
@@ -342,3 +414,10 @@ type ModelAfterDeleteHook interface {
 type ModelAfterQueryHook interface {
 	AfterQuery(Backend)
 }
+
+// ModelSnapshotHook is implemented by models that support dirty tracking.
+// Embed DirtyTrackingModel to implement it.
+type ModelSnapshotHook interface {
+	Snapshot() map[string]interface{}
+	SetSnapshot(snapshot map[string]interface{})
+}
@@ -31,13 +31,19 @@ func (m *MigrationHandler) HasMigration(version int) bool {
 }
 
 func (m *MigrationHandler) Add(migrations ...Migration) {
-	for _, migration := range migrations {
+	for index := range migrations {
+		migration := migrations[index]
 		migration.Version = len(m.migrations) + 1
 		m.migrations = append(m.migrations, &migration)
 	}
 }
 
+// Get returns the migration with the given version, or nil if it does not
+// exist.
 func (m *MigrationHandler) Get(version int) *Migration {
+	if version < 1 || version > len(m.migrations) {
+		return nil
+	}
 	return m.migrations[version-1]
 }
 
@@ -45,7 +51,16 @@ func (m *MigrationHandler) Migrate(force bool) apperror.Error {
 	return m.MigrateTo(len(m.migrations), force)
 }
 
+// MigrateTo migrates the database to the target version.
+//
+// If the target version is lower than the current version, the Down
+// functions of all migrations above the target are run in reverse order.
 func (m *MigrationHandler) MigrateTo(targetVersion int, force bool) apperror.Error {
+	if targetVersion < 0 || targetVersion > len(m.migrations) {
+		return apperror.New("unknown_migration",
+			fmt.Sprintf("Unknown migration version: %v", targetVersion))
+	}
+
 	// Ensure that migrations are set up.
 	if err := m.Backend.MigrationsSetup(); err != nil {
 		return err
@@ -82,49 +97,359 @@ func (m *MigrationHandler) MigrateTo(targetVersion int, force bool) apperror.Err
 				return err
 			}
 		}
+	} else if curVersion > targetVersion {
+		// Ensure that all migrations can be rolled back before starting.
+		for version := curVersion; version > targetVersion; version-- {
+			migration := m.Get(version)
+			if migration == nil {
+				return apperror.New("unknown_migration",
+					fmt.Sprintf("Unknown migration version: %v", version))
+			}
+			if migration.Down == nil {
+				return &apperror.Err{
+					Code:    "migration_without_down",
+					Message: fmt.Sprintf("Can not roll back to version %v: Migration %v (version %v) has no Down function", targetVersion, migration.Name, migration.Version),
+					Public:  true,
+				}
+			}
+		}
+
+		for version := curVersion; version > targetVersion; version-- {
+			if err := m.RollbackMigration(m.Get(version)); err != nil {
+				// Rollback failed! Abort.
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func (handler *MigrationHandler) RunMigration(m *Migration) apperror.Error {
+// InitializeFresh sets up a new, empty database.
+//
+// All registered collections are created from the current model info, and
+// all migrations are marked as complete.
+// Migrations with SkipOnNew set are skipped. The Up function of all other
+// migrations is run, so they can seed data.
+func (m *MigrationHandler) InitializeFresh() apperror.Error {
+	// Ensure that migrations are set up.
+	if err := m.Backend.MigrationsSetup(); err != nil {
+		return err
+	}
+
+	isLocked, err := m.Backend.IsMigrationLocked()
+	if err != nil {
+		return err
+	}
+	if isLocked {
+		return apperror.New("migrations_locked",
+			"Can not initialize database: Last migration was aborted. DB is locked.")
+	}
+
+	curVersion, err := m.Backend.DetermineMigrationVersion()
+	if err != nil {
+		return err
+	}
+	if curVersion != 0 {
+		return apperror.New("database_not_fresh",
+			fmt.Sprintf("Can not initialize database: Database is already at version %v", curVersion))
+	}
 
-	backend := handler.Backend
-	useTransaction := false
+	// Create all collections, referenced collections first.
+	attemptCollection, err := GetModelCollection(m.Backend.NewMigrationAttempt())
+	if err != nil {
+		return err
+	}
+	for _, collection := range m.Backend.ModelInfos().SortedByDependencies() {
+		if collection == attemptCollection {
+			// Created by MigrationsSetup().
+			continue
+		}
+		if err := m.Backend.CreateCollection(collection); err != nil {
+			return apperror.Wrap(err, "collection_creation_failed",
+				fmt.Sprintf("Could not create collection %v", collection))
+		}
+	}
 
-	var tx Transaction
-	txCapableBackend, hasTransactions := handler.Backend.(TransactionBackend)
-	if hasTransactions && m.WrapTransaction {
-		useTransaction = true
-		tx, err := txCapableBackend.Begin()
-		if err != nil {
+	for _, migration := range m.migrations {
+		if migration.SkipOnNew {
+			if err := m.recordSkippedMigration(migration); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.RunMigration(migration); err != nil {
 			return err
 		}
-		backend = tx.(MigrationBackend)
 	}
 
-	attempt := backend.NewMigrationAttempt()
+	return nil
+}
+
+// recordSkippedMigration marks a migration as complete without running it.
+func (handler *MigrationHandler) recordSkippedMigration(m *Migration) apperror.Error {
+	now := time.Now()
+
+	attempt := handler.Backend.NewMigrationAttempt()
 	attempt.SetVersion(m.Version)
+	attempt.SetStartedAt(now)
+	attempt.SetFinishedAt(now)
+	attempt.SetComplete(true)
+
+	return handler.Backend.Create(attempt)
+}
+
+/**
+ * Status.
+ */
+
+// MigrationAttemptStatus describes a single migration attempt.
+type MigrationAttemptStatus struct {
+	Attempt MigrationAttempt
+
+	Version    int
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Duration is zero if the attempt did not finish.
+	Duration time.Duration
+
+	Complete bool
+	// Failed is true if the attempt finished without completing.
+	Failed bool
+	// Aborted is true if the attempt never finished.
+	Aborted bool
+}
+
+func newMigrationAttemptStatus(attempt MigrationAttempt) *MigrationAttemptStatus {
+	s := &MigrationAttemptStatus{
+		Attempt:    attempt,
+		Version:    attempt.GetVersion(),
+		StartedAt:  attempt.GetStartedAt(),
+		FinishedAt: attempt.GetFinishedAt(),
+		Complete:   attempt.GetComplete(),
+	}
+
+	if s.FinishedAt.IsZero() {
+		s.Aborted = true
+	} else {
+		s.Duration = s.FinishedAt.Sub(s.StartedAt)
+		s.Failed = !s.Complete
+	}
+
+	return s
+}
+
+// MigrationStatus describes the migration state of the database.
+type MigrationStatus struct {
+	CurrentVersion int
+	LatestVersion  int
+	Locked         bool
+
+	// Pending holds all migrations above the current version.
+	Pending []*Migration
+
+	// Attempts holds all attempts, the oldest first.
+	Attempts []*MigrationAttemptStatus
+
+	// FailedAttempt is the last attempt if it failed or was aborted, nil otherwise.
+	FailedAttempt *MigrationAttemptStatus
+}
+
+// CurrentVersion returns the current version of the database.
+func (m *MigrationHandler) CurrentVersion() (int, apperror.Error) {
+	if err := m.Backend.MigrationsSetup(); err != nil {
+		return -1, err
+	}
+	return m.Backend.DetermineMigrationVersion()
+}
+
+// PendingMigrations returns all migrations above the current version.
+func (m *MigrationHandler) PendingMigrations() ([]*Migration, apperror.Error) {
+	curVersion, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*Migration, 0)
+	for _, migration := range m.migrations {
+		if migration.Version > curVersion {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Attempts returns all migration attempts, the oldest first.
+func (m *MigrationHandler) Attempts() ([]*MigrationAttemptStatus, apperror.Error) {
+	if err := m.Backend.MigrationsSetup(); err != nil {
+		return nil, err
+	}
+
+	collection, err := GetModelCollection(m.Backend.NewMigrationAttempt())
+	if err != nil {
+		return nil, err
+	}
+
+	q := m.Backend.Q(collection)
+	if info := m.Backend.ModelInfo(collection); info != nil && info.PkAttribute() != nil {
+		q.Sort(info.PkAttribute().BackendName(), true)
+	}
+
+	models, err := q.Find()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make([]*MigrationAttemptStatus, 0)
+	for _, model := range models {
+		attempt, ok := model.(MigrationAttempt)
+		if !ok {
+			return nil, apperror.New("invalid_migration_attempt")
+		}
+		attempts = append(attempts, newMigrationAttemptStatus(attempt))
+	}
+
+	return attempts, nil
+}
+
+// Status returns the complete migration status of the database.
+func (m *MigrationHandler) Status() (*MigrationStatus, apperror.Error) {
+	attempts, err := m.Attempts()
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := m.Backend.IsMigrationLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{
+		LatestVersion: len(m.migrations),
+		Locked:        locked,
+		Pending:       pending,
+		Attempts:      attempts,
+	}
+
+	status.CurrentVersion, err = m.Backend.DetermineMigrationVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attempts) > 0 {
+		last := attempts[len(attempts)-1]
+		if last.Failed || last.Aborted {
+			status.FailedAttempt = last
+		}
+	}
+
+	return status, nil
+}
+
+// ForceUnlock unlocks the database after an aborted migration.
+// The aborted attempt is marked as failed.
+// Make sure to check the state of the database before migrating again.
+func (m *MigrationHandler) ForceUnlock() apperror.Error {
+	attempts, err := m.Attempts()
+	if err != nil {
+		return err
+	}
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	last := attempts[len(attempts)-1]
+	if !last.Aborted {
+		// Not locked.
+		return nil
+	}
+
+	last.Attempt.SetFinishedAt(time.Now())
+	last.Attempt.SetComplete(false)
+	if err := m.Backend.Update(last.Attempt); err != nil {
+		return apperror.Wrap(err, "attempt_update_failed", "Could not mark the aborted migration attempt as failed")
+	}
+
+	return nil
+}
+
+// RunMigration runs the Up function of a migration.
+func (handler *MigrationHandler) RunMigration(m *Migration) apperror.Error {
+	return handler.runMigration(m, false)
+}
+
+// RollbackMigration runs the Down function of a migration.
+//
+// The attempt is recorded with the version preceding the migration, since
+// that is the version of the database after the rollback.
+func (handler *MigrationHandler) RollbackMigration(m *Migration) apperror.Error {
+	if m.Down == nil {
+		return &apperror.Err{
+			Code:    "migration_without_down",
+			Message: fmt.Sprintf("Migration %v (version %v) has no Down function", m.Name, m.Version),
+			Public:  true,
+		}
+	}
+	return handler.runMigration(m, true)
+}
+
+func (handler *MigrationHandler) runMigration(m *Migration, down bool) apperror.Error {
+	txBackend, hasTransactions := handler.Backend.(TransactionBackend)
+	if hasTransactions && m.WrapTransaction {
+		return WithTransaction(txBackend, func(tx Transaction) error {
+			if err := handler.executeMigration(tx.(MigrationBackend), m, down, true); err != nil {
+				return err
+			}
+			return nil
+		})
+	}
+
+	return handler.executeMigration(handler.Backend, m, down, false)
+}
+
+// executeMigration records the attempt and runs the migration.
+// If inTransaction is true, the attempt is not updated on failure, since the
+// transaction will be rolled back.
+func (handler *MigrationHandler) executeMigration(backend MigrationBackend, m *Migration, down, inTransaction bool) apperror.Error {
+	attempt := backend.NewMigrationAttempt()
+	if down {
+		attempt.SetVersion(m.Version - 1)
+	} else {
+		attempt.SetVersion(m.Version)
+	}
 	attempt.SetStartedAt(time.Now())
 	attempt.SetComplete(false)
 
 	if err := backend.Create(attempt); err != nil {
-		if useTransaction {
-			tx.Rollback()
-		}
 		return err
 	}
 
-	if err := m.Up(backend); err != nil {
-		if useTransaction {
-			tx.Rollback()
-		} else {
+	var err error
+	if down {
+		err = m.Down(backend)
+	} else {
+		err = m.Up(backend)
+	}
+
+	if err != nil {
+		if !inTransaction {
 			// No transaction, so update the attempt to reflect
 			// finished state but fail.
 			attempt.SetFinishedAt(time.Now())
 			backend.Update(attempt)
 		}
 
+		if down {
+			return apperror.Wrap(err, "migration_rollback_failed",
+				fmt.Sprintf("Rollback of %v (version %v) failed: %v", m.Name, m.Version, err), true)
+		}
 		return apperror.Wrap(err, "migration_failed",
 			fmt.Sprintf("Migration to %v (version %v) failed: %v", m.Name, m.Version, err), true)
 	}
@@ -133,21 +458,10 @@ func (handler *MigrationHandler) RunMigration(m *Migration) apperror.Error {
 	attempt.SetFinishedAt(time.Now())
 	attempt.SetComplete(true)
 	if err := backend.Update(attempt); err != nil {
-		// Updating the attempt failed.
-
-		if useTransaction {
-			// Roll back the transaction.
-			tx.Rollback()
-		}
-
 		return apperror.Wrap(err, "attempt_update_failed",
 			"Migration succeded, but could not update the attempt in the database")
 	}
 
-	if useTransaction {
-		tx.Commit()
-	}
-
 	return nil
 }
 
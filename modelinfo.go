@@ -3,8 +3,11 @@ package dukedb
 import (
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/theduke/go-reflector"
 
@@ -39,6 +42,10 @@ type ModelInfo struct {
 
 	attributes map[string]*Attribute
 	relations  map[string]*Relation
+
+	// foreignKeyRelations holds all relations, of this or other models, that
+	// require a foreign key constraint on this collection.
+	foreignKeyRelations []*Relation
 }
 
 /**
@@ -184,6 +191,30 @@ func (m *ModelInfo) PkAttribute() *Attribute {
 	return nil
 }
 
+// VersionAttribute returns the attribute tagged with "version", which is used
+// for optimistic locking, or nil.
+func (m *ModelInfo) VersionAttribute() *Attribute {
+	for _, attr := range m.attributes {
+		if attr.IsVersion() {
+			return attr
+		}
+	}
+
+	return nil
+}
+
+// SoftDeleteAttribute returns the attribute tagged with "soft-delete", which
+// holds the deletion time of soft deleted models, or nil.
+func (m *ModelInfo) SoftDeleteAttribute() *Attribute {
+	for _, attr := range m.attributes {
+		if attr.IsSoftDelete() {
+			return attr
+		}
+	}
+
+	return nil
+}
+
 // FindField tries to find a field by checking its Name, BackendName and MarshalName.
 func (m *ModelInfo) FindAttribute(name string) *Attribute {
 	for _, attr := range m.attributes {
@@ -228,6 +259,89 @@ func (m *ModelInfo) FindRelation(name string) *Relation {
 	return nil
 }
 
+/**
+ * Foreign keys.
+ */
+
+// ForeignKeys returns the foreign key constraints of the collection.
+//
+// Constraints are created for relations with a foreign key specified with the
+// foreign-key, on-delete or on-update tags, and for m2m collections.
+// For has-one relations, the local field references the related model.
+// For belongs-to and has-many relations, the foreign field of the related
+// model references the local field.
+// Constraints are sorted by field name.
+func (m *ModelInfo) ForeignKeys() []*ForeignKeyConstraint {
+	constraints := make([]*ForeignKeyConstraint, 0)
+	names := make(map[string]bool)
+
+	for _, relation := range m.foreignKeyRelations {
+		var field, refField *Attribute
+		var refInfo *ModelInfo
+
+		if relation.RelationType() == RELATION_TYPE_HAS_ONE {
+			field = m.Attribute(relation.LocalField())
+			refInfo = relation.RelatedModel()
+			refField = refInfo.Attribute(relation.ForeignField())
+		} else {
+			field = m.Attribute(relation.ForeignField())
+			refInfo = relation.Model()
+			refField = refInfo.Attribute(relation.LocalField())
+		}
+		if field == nil || refField == nil {
+			continue
+		}
+
+		name := foreignKeyName(m.BackendName(), field.BackendName())
+		if names[name] {
+			// Multiple relations may use the same key.
+			continue
+		}
+		names[name] = true
+
+		actions := make([]*ActionConstraint, 0)
+		if relation.OnDelete() != "" {
+			actions = append(actions, NewActionConstraint(EVENT_DELETE, relation.OnDelete()))
+		}
+		if relation.OnUpdate() != "" {
+			actions = append(actions, NewActionConstraint(EVENT_UPDATE, relation.OnUpdate()))
+		}
+
+		constraint := NewForeignKeyConstraint(name, field.BackendName(), refInfo.BackendName(), refField.BackendName(), actions...)
+		constraints = append(constraints, constraint)
+	}
+
+	sort.Sort(foreignKeysByField(constraints))
+
+	return constraints
+}
+
+// foreignKeysByField sorts foreign key constraints by field name.
+type foreignKeysByField []*ForeignKeyConstraint
+
+func (f foreignKeysByField) Len() int {
+	return len(f)
+}
+
+func (f foreignKeysByField) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (f foreignKeysByField) Less(i, j int) bool {
+	return f[i].Field() < f[j].Field()
+}
+
+// foreignKeyName builds the name of a foreign key constraint.
+// Names longer than 63 characters, the limit of most databases, are
+// shortened with a checksum suffix.
+func foreignKeyName(collection, field string) string {
+	name := "fk_" + collection + "_" + strings.Replace(field, ".", "_", -1)
+	if len(name) > 63 {
+		name = fmt.Sprintf("%v_%08x", name[:54], crc32.ChecksumIEEE([]byte(name)))
+	}
+	return name
+}
+
 // Builds the ModelInfo for a model and returns it.
 func BuildModelInfo(model interface{}) (*ModelInfo, apperror.Error) {
 	structReflector, err := reflector.Reflect(model).Struct()
@@ -309,6 +423,53 @@ func BuildModelInfo(model interface{}) (*ModelInfo, apperror.Error) {
 		}
 	}
 
+	// Validate the version attribute for optimistic locking.
+	var versionAttr *Attribute
+	for _, attr := range info.attributes {
+		if !attr.IsVersion() {
+			continue
+		}
+
+		switch attr.Type().Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, apperror.New("invalid_version_field",
+				fmt.Sprintf("The version field %v.%v must be an integer", info.StructName(), attr.Name()))
+		}
+		if versionAttr != nil {
+			return nil, apperror.New("multiple_version_fields",
+				fmt.Sprintf("The model %v has multiple version fields", info.StructName()))
+		}
+		versionAttr = attr
+	}
+
+	// Validate the soft delete field.
+	// Time fields are transient until relations are analyzed, so the tags of
+	// all fields are checked.
+	softDeleteFields := make([]*Field, 0)
+	for _, attr := range info.attributes {
+		softDeleteFields = append(softDeleteFields, &attr.Field)
+	}
+	for _, field := range info.transientFields {
+		softDeleteFields = append(softDeleteFields, field)
+	}
+	var softDeleteField *Field
+	for _, field := range softDeleteFields {
+		if field.tag == nil || !field.tag.softDelete {
+			continue
+		}
+		if field.Type() != reflect.TypeOf(&time.Time{}) {
+			return nil, apperror.New("invalid_soft_delete_field",
+				fmt.Sprintf("The soft delete field %v.%v must be a *time.Time", info.StructName(), field.Name()))
+		}
+		if softDeleteField != nil {
+			return nil, apperror.New("multiple_soft_delete_fields",
+				fmt.Sprintf("The model %v has multiple soft delete fields", info.StructName()))
+		}
+		softDeleteField = field
+	}
+
 	return info, nil
 }
 
@@ -384,6 +545,9 @@ func (info *ModelInfo) buildFields(modelVal *reflector.StructReflector, embedded
 		if err := field.parseTag(fieldInfo.Tag.Get("db")); err != nil {
 			return apperror.Wrap(err, "invalid_field_tag", fmt.Sprintf("The field %v has an invalid db tag", field.name))
 		}
+		if field.tag.marshalName != "" {
+			field.marshalName = field.tag.marshalName
+		}
 
 		// If tag specifies ignore, we can skip this field now.
 		if field.tag.ignore {
@@ -661,12 +825,9 @@ func (info *ModelInfo) BuildCreateStmt(withReferences bool) *CreateCollectionStm
 	}
 
 	if withReferences {
-		// Add reference constraints.
-		/*
-			for name, relation := range info.Relations() {
-
-			}
-		*/
+		for _, constraint := range info.ForeignKeys() {
+			constraints = append(constraints, constraint)
+		}
 	}
 
 	fields := make([]*FieldExpr, 0)
@@ -725,13 +886,27 @@ func (info *ModelInfo) ModelFromMap(data map[string]interface{}) (interface{}, a
 	return r.AddrInterface(), nil
 }
 
+// ValidateModel fills in default values and validates all attributes of a
+// model.
+// All invalid fields are reported at once with a *ValidationError.
 func (info *ModelInfo) ValidateModel(m interface{}) apperror.Error {
 	r, err := reflector.Reflect(m).Struct()
 	if err != nil {
 		return apperror.Wrap(err, "invalid_model")
 	}
 
-	for fieldName, fieldInfo := range info.Attributes() {
+	errs := NewValidationError(fmt.Sprintf("The %v is invalid", info.StructName()))
+
+	// Validate the attributes sorted by name, so the errors are reported in
+	// a stable order.
+	names := make([]string, 0, len(info.Attributes()))
+	for name := range info.Attributes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, fieldName := range names {
+		fieldInfo := info.Attribute(fieldName)
 		field := r.Field(fieldName)
 
 		// Fill in default values.
@@ -742,61 +917,31 @@ func (info *ModelInfo) ValidateModel(m interface{}) apperror.Error {
 			}
 		}
 
-		// If field is required, and the field is not a primary key, validate that it is
-		// not zero.
-		// Note: numeric fields will not be checked, since their zero value is "0", which might
-		// be a valid field value.
-		if !field.IsNumeric() && fieldInfo.IsRequired() && !fieldInfo.AutoIncrement() {
-			if field.IsZero() {
-				return &apperror.Err{
-					Code:    "empty_required_field",
-					Message: fmt.Sprintf("The required field %v is empty", fieldName),
-					Public:  true,
-				}
-			}
-		}
-		if fieldInfo.Min() > 0 || fieldInfo.Max() > 0 {
-			// Either min or max is set, so check length.
-			var length float64
-
-			if field.IsIterable() {
-				length = float64(field.Len())
-			} else if field.IsNumeric() {
-				x, _ := field.ConvertTo(float64(0))
-				length = x.(float64)
-			} else {
-				msg := fmt.Sprintf("Field %v.%v has min or max set, but is neither numeric nor a string", info.collection, fieldName)
-				return apperror.New("invalid_min_or_max_condition", msg)
-			}
-
-			if fieldInfo.Min() > 0 && length < fieldInfo.Min() {
-				return &apperror.Err{
-					Code:    "shorter_than_min_length",
-					Message: fmt.Sprintf("The field %v is shorter than the minimum length %v", fieldName, fieldInfo.Min()),
-				}
-			}
-			if fieldInfo.Max() > 0 && length > fieldInfo.Max() {
-				return &apperror.Err{
-					Code:    "longer_than_max_length",
-					Message: fmt.Sprintf("The field %v is longer than the maximum length %v", fieldName, fieldInfo.Max()),
-				}
-			}
+		if err := validateAttribute(info, fieldInfo, field, errs); err != nil {
+			return err
 		}
 	}
 
 	// If the model implements ModelValidateHook, call it.
 	if validator, ok := m.(ModelValidateHook); ok {
 		if err := validator.Validate(); err != nil {
-			// Check if error is an apperror, and return it if so.
-			if apperr, ok := err.(apperror.Error); ok {
-				return apperr
-			} else {
-				// Not an apperror, so create a new one.
-				return apperror.New(err.Error())
+			_, isValidationErr := err.(*ValidationError)
+			if !isValidationErr && !errs.HasErrors() {
+				// Check if error is an apperror, and return it if so.
+				if apperr, ok := err.(apperror.Error); ok {
+					return apperr
+				} else {
+					// Not an apperror, so create a new one.
+					return apperror.New(err.Error())
+				}
 			}
+			mergeValidationErrors(info, errs, err)
 		}
 	}
 
+	if errs.HasErrors() {
+		return errs
+	}
 	return nil
 }
 
@@ -828,6 +973,68 @@ func (i ModelInfos) Find(name string) *ModelInfo {
 	return nil
 }
 
+// SortedByDependencies returns all collections, sorted so that each
+// collection comes after the collections referenced by its foreign keys or
+// the foreign keys of its m2m collections.
+// Independent collections are sorted by name, and cyclic references are
+// broken in name order, so the order is deterministic.
+func (i ModelInfos) SortedByDependencies() []string {
+	names := make([]string, 0, len(i))
+	for name := range i {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sorted := make([]string, 0, len(names))
+	visited := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		for _, dependency := range i.dependencies(i[name]) {
+			visit(dependency)
+		}
+		sorted = append(sorted, name)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return sorted
+}
+
+// dependencies returns the sorted collections referenced by the foreign keys
+// of a collection and its m2m collections.
+func (i ModelInfos) dependencies(info *ModelInfo) []string {
+	infos := []*ModelInfo{info}
+	for _, relation := range info.Relations() {
+		if relation.RelationType() != RELATION_TYPE_M2M {
+			continue
+		}
+		if m2mInfo := i.Find(relation.BackendName()); m2mInfo != nil {
+			infos = append(infos, m2mInfo)
+		}
+	}
+
+	dependencies := make([]string, 0)
+	for _, keyInfo := range infos {
+		for _, fk := range keyInfo.ForeignKeys() {
+			refInfo := i.Find(fk.Reference().ForeignKey().Collection())
+			if refInfo != nil && refInfo.Collection() != info.Collection() {
+				dependencies = append(dependencies, refInfo.Collection())
+			}
+		}
+	}
+	sort.Strings(dependencies)
+
+	return dependencies
+}
+
 /**
  * Functions for analyzing the relationships between model structs.
  */
@@ -838,9 +1045,36 @@ func (m ModelInfos) AnalyzeRelations() apperror.Error {
 			return err
 		}
 	}
+
+	m.assignForeignKeyRelations()
+
 	return nil
 }
 
+// assignForeignKeyRelations adds all relations with a foreign key to the
+// model that holds the key field.
+func (m ModelInfos) assignForeignKeyRelations() {
+	for _, info := range m {
+		info.foreignKeyRelations = nil
+	}
+
+	for _, info := range m {
+		for _, relation := range info.Relations() {
+			if !relation.HasForeignKey() {
+				continue
+			}
+
+			switch relation.RelationType() {
+			case RELATION_TYPE_HAS_ONE:
+				info.foreignKeyRelations = append(info.foreignKeyRelations, relation)
+			case RELATION_TYPE_BELONGS_TO, RELATION_TYPE_HAS_MANY:
+				related := relation.RelatedModel()
+				related.foreignKeyRelations = append(related.foreignKeyRelations, relation)
+			}
+		}
+	}
+}
+
 // Recursive helper for building the relationship information.
 // Will properly analyze all embedded structs as well.
 // All transientFields will be checked, and split intro attributes or
@@ -1063,6 +1297,13 @@ func (m ModelInfos) buildM2MRelation(relation *Relation) apperror.Error {
 		isUniqueWith: []string{localFieldName},
 	}
 
+	// Join items are deleted together with the items they reference,
+	// unless an other action was specified.
+	onDelete := relation.OnDelete()
+	if onDelete == "" {
+		onDelete = ACTION_CASCADE
+	}
+
 	col := &ModelInfo{
 		collection:  colName,
 		backendName: colName,
@@ -1086,6 +1327,9 @@ func (m ModelInfos) buildM2MRelation(relation *Relation) apperror.Error {
 			relationType: RELATION_TYPE_HAS_ONE,
 			localField:   localFieldName,
 			foreignField: relation.LocalField(),
+			foreignKey:   true,
+			onDelete:     onDelete,
+			onUpdate:     relation.OnUpdate(),
 		},
 
 		"RelatedItem": &Relation{
@@ -1099,6 +1343,9 @@ func (m ModelInfos) buildM2MRelation(relation *Relation) apperror.Error {
 			relationType: RELATION_TYPE_HAS_ONE,
 			localField:   fkName,
 			foreignField: relation.ForeignField(),
+			foreignKey:   true,
+			onDelete:     onDelete,
+			onUpdate:     relation.OnUpdate(),
 		},
 	}
 
@@ -6,6 +6,8 @@ import (
 
 	"github.com/theduke/go-apperror"
 	. "github.com/theduke/go-dukedb"
+	. "github.com/theduke/go-dukedb/expressions"
+	"github.com/theduke/go-dukedb/models/govalidate"
 	//. "github.com/theduke/go-dukedb/backends/tests"
 )
 
@@ -129,4 +131,316 @@ var _ = Describe("Modelinfo", func() {
 			})
 		})
 	})
+
+	Describe("Foreign keys", func() {
+		It("Should build a foreign key for has-one relations", func() {
+			type Author struct {
+				Id uint64
+			}
+			type Book struct {
+				Id      uint64
+				Owner   *Author `db:"has-one:OwnerId:Id;on-delete:set-null;on-update:cascade"`
+				OwnerId uint64
+			}
+
+			infos, err := buildInfo(&Author{}, &Book{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(infos.Get("authors").ForeignKeys()).To(BeEmpty())
+
+			fks := infos.Get("books").ForeignKeys()
+			Expect(fks).To(HaveLen(1))
+			Expect(fks[0].Name()).To(Equal("fk_books_owner_id"))
+			Expect(fks[0].Field()).To(Equal("owner_id"))
+			Expect(fks[0].Reference().ForeignKey().Collection()).To(Equal("authors"))
+			Expect(fks[0].Reference().ForeignKey().Field()).To(Equal("id"))
+			Expect(fks[0].Actions()).To(Equal([]*ActionConstraint{
+				NewActionConstraint(EVENT_DELETE, ACTION_SET_NULL),
+				NewActionConstraint(EVENT_UPDATE, ACTION_CASCADE),
+			}))
+		})
+
+		It("Should build a foreign key on the related model for has-many relations", func() {
+			type Book struct {
+				Id       uint64
+				AuthorId uint64
+			}
+			type Author struct {
+				Id    uint64
+				Books []Book `db:"has-many:Id:AuthorId;foreign-key"`
+			}
+
+			infos, err := buildInfo(&Author{}, &Book{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(infos.Get("authors").ForeignKeys()).To(BeEmpty())
+
+			fks := infos.Get("books").ForeignKeys()
+			Expect(fks).To(HaveLen(1))
+			Expect(fks[0].Field()).To(Equal("author_id"))
+			Expect(fks[0].Reference().ForeignKey().Collection()).To(Equal("authors"))
+			Expect(fks[0].Actions()).To(BeEmpty())
+		})
+
+		It("Should not build foreign keys without a tag", func() {
+			type Author struct {
+				Id uint64
+			}
+			type Book struct {
+				Id       uint64
+				Author   *Author
+				AuthorId uint64
+			}
+
+			infos, err := buildInfo(&Author{}, &Book{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos.Get("books").ForeignKeys()).To(BeEmpty())
+		})
+
+		It("Should sort foreign keys by field name", func() {
+			type Author struct {
+				Id uint64
+			}
+			type Book struct {
+				Id         uint64
+				Reviewer   *Author `db:"has-one:ReviewerId:Id;foreign-key"`
+				ReviewerId uint64
+				Editor     *Author `db:"has-one:EditorId:Id;foreign-key"`
+				EditorId   uint64
+				Author     *Author `db:"foreign-key"`
+				AuthorId   uint64
+			}
+
+			infos, err := buildInfo(&Author{}, &Book{})
+			Expect(err).ToNot(HaveOccurred())
+
+			fields := make([]string, 0)
+			for _, fk := range infos.Get("books").ForeignKeys() {
+				fields = append(fields, fk.Field())
+			}
+			Expect(fields).To(Equal([]string{"author_id", "editor_id", "reviewer_id"}))
+		})
+
+		It("Should build cascading foreign keys for m2m collections", func() {
+			type Tag struct {
+				Id uint64
+			}
+			type Post struct {
+				Id   uint64
+				Tags []Tag `db:"m2m"`
+			}
+
+			infos, err := buildInfo(&Post{}, &Tag{})
+			Expect(err).ToNot(HaveOccurred())
+
+			fks := infos.Get("posts_tags").ForeignKeys()
+			Expect(fks).To(HaveLen(2))
+			for _, fk := range fks {
+				Expect(fk.Actions()).To(Equal([]*ActionConstraint{NewActionConstraint(EVENT_DELETE, ACTION_CASCADE)}))
+			}
+		})
+
+		It("Should add foreign keys to the create statement", func() {
+			type Author struct {
+				Id uint64
+			}
+			type Book struct {
+				Id       uint64
+				Author   *Author `db:"foreign-key"`
+				AuthorId uint64
+			}
+
+			infos, err := buildInfo(&Author{}, &Book{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(infos.Get("books").BuildCreateStmt(false).Constraints()).To(BeEmpty())
+			Expect(infos.Get("books").BuildCreateStmt(true).Constraints()).To(HaveLen(1))
+		})
+
+		It("Should sort collections by their dependencies", func() {
+			type Zoo struct {
+				Id uint64
+			}
+			type Keeper struct {
+				Id uint64
+			}
+			type Animal struct {
+				Id      uint64
+				Zoo     *Zoo `db:"foreign-key"`
+				ZooId   uint64
+				Keepers []Keeper `db:"m2m"`
+			}
+
+			infos, err := buildInfo(&Animal{}, &Zoo{}, &Keeper{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos.SortedByDependencies()).To(Equal([]string{"keepers", "zoos", "animals", "animals_keepers"}))
+		})
+
+		It("Should error out on an invalid action", func() {
+			type Author struct {
+				Id uint64
+			}
+			type Book struct {
+				Id       uint64
+				Author   *Author `db:"on-delete:explode"`
+				AuthorId uint64
+			}
+
+			_, err := buildInfo(&Author{}, &Book{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_on_delete"))
+		})
+	})
+
+	Describe("Version attribute", func() {
+		It("Should detect the version attribute", func() {
+			type Doc struct {
+				Id      uint64
+				Version int `db:"version"`
+			}
+
+			info, err := BuildModelInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.VersionAttribute()).ToNot(BeNil())
+			Expect(info.VersionAttribute().Name()).To(Equal("Version"))
+		})
+
+		It("Should error out on non-integer version fields", func() {
+			type Doc struct {
+				Id      uint64
+				Version string `db:"version"`
+			}
+
+			_, err := BuildModelInfo(&Doc{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_version_field"))
+		})
+	})
+
+	Describe("Soft delete attribute", func() {
+		It("Should detect the embedded soft delete attribute", func() {
+			type Doc struct {
+				SoftDeleteModel
+				Id uint64
+			}
+
+			infos, err := buildInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+			info := infos.Get("docs")
+			Expect(info.SoftDeleteAttribute()).ToNot(BeNil())
+			Expect(info.SoftDeleteAttribute().Name()).To(Equal("DeletedAt"))
+		})
+
+		It("Should error out on soft delete fields that are not *time.Time", func() {
+			type Doc struct {
+				Id        uint64
+				DeletedAt bool `db:"soft-delete"`
+			}
+
+			_, err := BuildModelInfo(&Doc{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_soft_delete_field"))
+		})
+	})
+
+	Describe("Validators", func() {
+		It("Should parse validator tags", func() {
+			type Doc struct {
+				Id     uint64
+				Mail   string `db:"email"`
+				Site   string `db:"url"`
+				Key    string `db:"uuid"`
+				Time   string `db:"pattern:^\\d{2}:\\d{2}$"`
+				Status string `db:"enum:a,b"`
+			}
+
+			info, err := BuildModelInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Attribute("Mail").IsEmail()).To(BeTrue())
+			Expect(info.Attribute("Site").IsUrl()).To(BeTrue())
+			Expect(info.Attribute("Key").IsUuid()).To(BeTrue())
+			Expect(info.Attribute("Time").Pattern().String()).To(Equal(`^\d{2}:\d{2}$`))
+			Expect(info.Attribute("Status").Enum()).To(Equal([]string{"a", "b"}))
+		})
+
+		It("Should error out on invalid patterns", func() {
+			type Doc struct {
+				Id   uint64
+				Code string `db:"pattern:[a-"`
+			}
+
+			_, err := BuildModelInfo(&Doc{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("invalid_field_tag"))
+		})
+
+		It("Should parse patterns with escaped semicolons", func() {
+			type Doc struct {
+				Id   uint64
+				Code string `db:"pattern:^[a-z\\;]+$;required"`
+			}
+
+			info, err := BuildModelInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Attribute("Code").Pattern().MatchString("a;b")).To(BeTrue())
+			Expect(info.Attribute("Code").IsRequired()).To(BeTrue())
+		})
+
+		It("Should collect the errors of all fields", func() {
+			type Doc struct {
+				Id     uint64
+				Mail   string `db:"email;required"`
+				Status string `db:"enum:a,b;marshal-name:state"`
+			}
+
+			info, err := BuildModelInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = info.ValidateModel(&Doc{Status: "c"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.GetCode()).To(Equal("validation_error"))
+
+			validationErr := err.(*ValidationError)
+			Expect(validationErr.FieldErrorCodes("mail")).To(Equal([]string{"empty_required_field"}))
+			Expect(validationErr.FieldErrorCodes("state")).To(Equal([]string{"invalid_enum_value"}))
+		})
+
+		It("Should report field errors in attribute name order", func() {
+			type Doc struct {
+				Id    uint64
+				Zip   string `db:"required"`
+				Mail  string `db:"required"`
+				Alias string `db:"required"`
+			}
+
+			info, err := BuildModelInfo(&Doc{})
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 10; i++ {
+				err = info.ValidateModel(&Doc{})
+				Expect(err).To(HaveOccurred())
+
+				fields := make([]string, 0)
+				for _, fieldErr := range err.(*ValidationError).Errors {
+					fields = append(fields, fieldErr.(*FieldError).Field)
+				}
+				Expect(fields).To(Equal([]string{"alias", "mail", "zip"}))
+			}
+		})
+
+		It("Should validate models with govalidate", func() {
+			type Doc struct {
+				Id   uint64
+				Mail string `valid:"email"`
+			}
+
+			Expect(govalidate.Validate(&Doc{Mail: "a@b.com"})).ToNot(HaveOccurred())
+
+			err := govalidate.Validate(&Doc{Mail: "invalid"})
+			Expect(err).To(HaveOccurred())
+			validationErr := err.(*ValidationError)
+			Expect(validationErr.GetCode()).To(Equal("validation_error"))
+			Expect(validationErr.FieldErrorCodes("Mail")).To(Equal([]string{"invalid_field"}))
+		})
+	})
 })
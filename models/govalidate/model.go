@@ -4,8 +4,14 @@ import (
 	"github.com/asaskevich/govalidator"
 
 	"github.com/theduke/go-apperror"
+
+	db "github.com/theduke/go-dukedb"
 )
 
+// Model validates itself with govalidator.
+//
+// Deprecated: an embedded Model can not access the fields of the embedding
+// struct. Call Validate() from the Validate() method of the model instead.
 type Model struct{}
 
 func (m Model) Validate() apperror.Error {
@@ -27,3 +33,36 @@ func (m Model) Validate() apperror.Error {
 		Errors:  []error{err},
 	}
 }
+
+// Validate validates a model with govalidator, and returns a
+// *db.ValidationError with an error for each invalid field.
+// The field errors are merged with the errors of the dukedb tag validators
+// when called from the Validate() method of a model:
+//
+//	func (u *User) Validate() error {
+//		return govalidate.Validate(u)
+//	}
+func Validate(model interface{}) error {
+	ok, err := govalidator.ValidateStruct(model)
+	if ok {
+		return nil
+	}
+
+	errs := db.NewValidationError("The model is invalid")
+	addErrors(errs, err)
+	return errs
+}
+
+// addErrors converts govalidator errors to field errors.
+func addErrors(errs *db.ValidationError, err error) {
+	switch e := err.(type) {
+	case govalidator.Errors:
+		for _, nested := range e {
+			addErrors(errs, nested)
+		}
+	case govalidator.Error:
+		errs.AddFieldError(db.NewFieldError(e.Name, "invalid_field", e.Error()))
+	default:
+		errs.Errors = append(errs.Errors, err)
+	}
+}
@@ -0,0 +1,287 @@
+package dukedb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
+
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Keyset pagination.
+ */
+
+// Page is a page of a query result, returned by Query.Paginate().
+type Page struct {
+	Items []interface{}
+
+	// HasMore is true if there are more items in the direction of
+	// the pagination.
+	HasMore bool
+
+	// NextCursor can be passed to Query.After() to retrieve the next page.
+	// It is empty if there is no next page.
+	NextCursor string
+
+	// PrevCursor can be passed to Query.Before() to retrieve the previous page.
+	// It is empty if there is no previous page.
+	PrevCursor string
+}
+
+// pageCursor is the decoded content of a pagination cursor.
+type pageCursor struct {
+	Fields []string      `json:"f"`
+	Values []interface{} `json:"v"`
+}
+
+// pageKey is a field of the keyset used for pagination.
+type pageKey struct {
+	attr      *Attribute
+	ascending bool
+}
+
+// After restricts the query to items after the cursor, which is obtained from
+// Page.NextCursor.
+func (q *Query) After(cursor string) *Query {
+	q.afterCursor = cursor
+	q.beforeCursor = ""
+	return q
+}
+
+// Before restricts the query to items before the cursor, which is obtained from
+// Page.PrevCursor.
+func (q *Query) Before(cursor string) *Query {
+	q.beforeCursor = cursor
+	q.afterCursor = ""
+	return q
+}
+
+// Paginate retrieves a page of pageSize items, starting after the cursor set
+// with After() or ending before the cursor set with Before().
+//
+// Instead of an offset, a filter on the sort fields of the query is used
+// (keyset pagination), so deep pages are as fast as the first one.
+// The primary key is added as a final sort to make the order unique.
+// Sort fields must not contain NULL values.
+func (q *Query) Paginate(pageSize int) (*Page, apperror.Error) {
+	if q.backend == nil {
+		panic("Calling .Paginate() on query without backend")
+	}
+	if pageSize < 1 {
+		return nil, apperror.New("invalid_page_size", "The page size must be greater than 0", true)
+	}
+
+	info := q.backend.ModelInfos().Find(q.collection)
+	if info == nil {
+		return nil, apperror.New("unknown_collection", fmt.Sprintf("The collection %v was not registered with the backend", q.collection))
+	}
+
+	keys, err := q.pageKeys(info)
+	if err != nil {
+		return nil, err
+	}
+
+	pageQ := q.Clone()
+	backwards := q.beforeCursor != ""
+
+	// Sort by the keyset, reversing the order when paginating backwards.
+	sorts := make([]*SortExpr, 0)
+	for _, key := range keys {
+		sorts = append(sorts, NewSort(q.collection, key.attr.BackendName(), key.ascending != backwards))
+	}
+	pageQ.GetStatement().SetSorts(sorts)
+
+	if cursor := q.afterCursor + q.beforeCursor; cursor != "" {
+		values, err := decodePageCursor(cursor, keys)
+		if err != nil {
+			return nil, err
+		}
+		pageQ.FilterExpr(keysetFilter(q.collection, keys, values, backwards))
+	}
+
+	pageQ.Limit(pageSize + 1)
+	items, err := pageQ.Find()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{
+		Items:   items,
+		HasMore: len(items) > pageSize,
+	}
+	if page.HasMore {
+		page.Items = items[:pageSize]
+	}
+
+	if backwards {
+		// Restore the regular order.
+		for i, j := 0, len(page.Items)-1; i < j; i, j = i+1, j-1 {
+			page.Items[i], page.Items[j] = page.Items[j], page.Items[i]
+		}
+	}
+
+	if len(page.Items) == 0 {
+		return page, nil
+	}
+
+	// A next page exists if there are more items, or if this page was
+	// retrieved backwards. The same applies for the previous page.
+	hasNext := (!backwards && page.HasMore) || backwards
+	hasPrev := (backwards && page.HasMore) || q.afterCursor != ""
+
+	if hasNext {
+		if page.NextCursor, err = encodePageCursor(page.Items[len(page.Items)-1], keys); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.PrevCursor, err = encodePageCursor(page.Items[0], keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// pageKeys returns the keyset for the query: the sort fields, followed by
+// the primary key.
+func (q *Query) pageKeys(info *ModelInfo) ([]*pageKey, apperror.Error) {
+	keys := make([]*pageKey, 0)
+	hasPk := false
+
+	for _, sortExpr := range q.statement.Sorts() {
+		var field string
+		switch e := sortExpr.Expression().(type) {
+		case *IdentifierExpr:
+			field = e.Identifier()
+		case *ColFieldIdentifierExpr:
+			field = e.Field()
+		default:
+			return nil, apperror.New("unsupported_pagination_sort", "Only sorts by fields are supported for pagination", true)
+		}
+
+		attr := info.FindAttribute(field)
+		if attr == nil {
+			return nil, apperror.New("invalid_sort_field", fmt.Sprintf("The collection %v does not have a field %v", q.collection, field), true)
+		}
+		if attr == info.PkAttribute() {
+			hasPk = true
+		}
+		keys = append(keys, &pageKey{attr: attr, ascending: sortExpr.Ascending()})
+	}
+
+	if !hasPk {
+		keys = append(keys, &pageKey{attr: info.PkAttribute(), ascending: true})
+	}
+
+	return keys, nil
+}
+
+// keysetFilter builds the filter for items after the values in the order of
+// the keys, or before them if backwards is true:
+// (a > x) OR (a = x AND b > y) OR ...
+func keysetFilter(collection string, keys []*pageKey, values []interface{}, backwards bool) Expression {
+	or := NewOrExpr()
+	for i, key := range keys {
+		and := NewAndExpr()
+		for j := 0; j < i; j++ {
+			and.Add(Eq(collection, keys[j].attr.BackendName(), values[j]))
+		}
+
+		operator := OPERATOR_GT
+		if key.ascending == backwards {
+			operator = OPERATOR_LT
+		}
+		and.Add(NewFieldValFilter(collection, key.attr.BackendName(), operator, values[i]))
+
+		or.Add(and)
+	}
+	return or
+}
+
+// encodePageCursor builds the opaque cursor for a model.
+func encodePageCursor(model interface{}, keys []*pageKey) (string, apperror.Error) {
+	r, err := reflector.Reflect(model).Struct()
+	if err != nil {
+		return "", apperror.Wrap(err, "invalid_model")
+	}
+
+	cursor := &pageCursor{}
+	for _, key := range keys {
+		field := r.Field(key.attr.Name()).Value()
+		if field.Kind() == reflect.Ptr && field.IsNil() {
+			return "", apperror.New("null_pagination_value", fmt.Sprintf("The sort field %v is NULL, which is not supported for pagination", key.attr.Name()))
+		}
+
+		val := reflect.Indirect(field).Interface()
+		if t, ok := val.(time.Time); ok {
+			val = t.Format(time.RFC3339Nano)
+		}
+
+		cursor.Fields = append(cursor.Fields, key.attr.BackendName())
+		cursor.Values = append(cursor.Values, val)
+	}
+
+	js, err2 := json.Marshal(cursor)
+	if err2 != nil {
+		return "", apperror.Wrap(err2, "cursor_marshal_error")
+	}
+	return base64.RawURLEncoding.EncodeToString(js), nil
+}
+
+// decodePageCursor decodes a cursor and converts the values to the types of
+// the keys.
+func decodePageCursor(encoded string, keys []*pageKey) ([]interface{}, apperror.Error) {
+	invalid := apperror.New("invalid_cursor", "The pagination cursor is invalid", true)
+
+	js, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(js, &cursor); err != nil {
+		return nil, invalid
+	}
+
+	if len(cursor.Fields) != len(keys) || len(cursor.Values) != len(keys) {
+		return nil, invalid
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if cursor.Fields[i] != key.attr.BackendName() {
+			// The cursor was created for a query with different sorts.
+			return nil, invalid
+		}
+
+		typ := key.attr.Type()
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+
+		if typ == reflect.TypeOf(time.Time{}) {
+			str, _ := cursor.Values[i].(string)
+			t, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return nil, invalid
+			}
+			values[i] = t
+			continue
+		}
+
+		val, err := reflector.Reflect(cursor.Values[i]).ConvertTo(typ)
+		if err != nil {
+			return nil, invalid
+		}
+		values[i] = val
+	}
+
+	return values, nil
+}
@@ -41,6 +41,18 @@ type Query struct {
 	joinResultAssigner JoinAssigner
 
 	rawResult []interface{}
+
+	// afterCursor and beforeCursor hold the keyset pagination cursors
+	// used by Paginate().
+	afterCursor  string
+	beforeCursor string
+
+	// withDeleted and onlyDeleted control whether soft deleted models are
+	// included in the result.
+	withDeleted bool
+	onlyDeleted bool
+	// softDeleteScoped is set once Normalize() added the soft delete filter.
+	softDeleteScoped bool
 }
 
 func NewQuery(collection string, backend Backend) *Query {
@@ -98,6 +110,27 @@ func (q *Query) SetJoinResultAssigner(x JoinAssigner) {
 	q.joinResultAssigner = x
 }
 
+// Clone returns a copy of the query.
+// The statement and all joins are copied as well, so the clone can be
+// modified and executed without affecting the original query.
+func (q *Query) Clone() *Query {
+	newQ := *q
+	newQ.statement = q.statement.Copy()
+	newQ.cloneJoins(q)
+	return &newQ
+}
+
+// cloneJoins replaces the joins of the query with copies of the joins in
+// source.
+func (q *Query) cloneJoins(source *Query) {
+	q.joins = make(map[string]*RelationQuery)
+	for name, join := range source.joins {
+		newJoin := join.Clone()
+		newJoin.SetBaseQuery(q)
+		q.joins[name] = newJoin
+	}
+}
+
 /**
  * Limit methods.
  */
@@ -169,6 +202,63 @@ func (q *Query) SetSorts(exprs []*SortExpr) *Query {
 	return q
 }
 
+/**
+ * Group by and aggregate methods.
+ */
+
+// GroupBy groups the results by the given fields.
+// The fields are also selected with their given name, so they are part of
+// the result maps returned by .Pluck().
+func (q *Query) GroupBy(fields ...string) *Query {
+	for _, field := range fields {
+		q.statement.AddGroupBy(NewIdExpr(field))
+		if !q.hasField(field) {
+			q.statement.AddField(NewFieldSelectorExpr(field, NewIdExpr(field), nil))
+		}
+	}
+	return q
+}
+
+func (q *Query) GroupByExpr(exprs ...Expression) *Query {
+	q.statement.AddGroupBy(exprs...)
+	return q
+}
+
+// Aggregate selects an aggregate function like Sum("amount") as a field
+// with the given name.
+func (q *Query) Aggregate(name string, expr Expression) *Query {
+	q.statement.AddField(NewFieldSelectorExpr(name, expr, nil))
+	return q
+}
+
+func (q *Query) hasField(field string) bool {
+	for _, expr := range q.statement.Fields() {
+		if id, ok := expr.(*IdentifierExpr); ok && id.Identifier() == field {
+			return true
+		} else if sel, ok := expr.(*FieldSelectorExpr); ok && sel.Name() == field {
+			return true
+		}
+	}
+	return false
+}
+
+// HavingExpr filters grouped results.
+func (q *Query) HavingExpr(expressions ...Expression) *Query {
+	for _, expr := range expressions {
+		q.statement.HavingAnd(expr)
+	}
+	return q
+}
+
+// HavingCond filters grouped results by a field or the name of an aggregate.
+func (q *Query) HavingCond(field string, condition string, val interface{}) *Query {
+	return q.HavingExpr(NewFieldValFilter("", field, condition, val))
+}
+
+func (q *Query) Having(field string, val interface{}) *Query {
+	return q.HavingCond(field, OPERATOR_EQ, val)
+}
+
 /**
  * Filter methods.
  */
@@ -206,7 +296,7 @@ func (q *Query) FilterCond(field string, condition string, val interface{}) *Que
 		}
 	}
 
-	return q.FilterExpr(NewFieldValFilter(q.collection, field, condition, val))
+	return q.FilterExpr(NewFieldFilter(q.collection, field, condition, filterClause(val)))
 }
 
 func (q *Query) Filter(field string, val interface{}) *Query {
@@ -244,7 +334,7 @@ func (q *Query) OrCond(field string, condition string, val interface{}) *Query {
 		}
 	}
 
-	return q.OrExpr(NewFieldValFilter(q.collection, field, condition, val))
+	return q.OrExpr(NewFieldFilter(q.collection, field, condition, filterClause(val)))
 }
 
 func (q *Query) Or(field string, val interface{}) *Query {
@@ -274,7 +364,33 @@ func (q *Query) NotCond(field string, condition string, val interface{}) *Query
 		}
 	}
 
-	return q.NotExpr(NewFieldValFilter(q.collection, field, condition, val))
+	return q.NotExpr(NewFieldFilter(q.collection, field, condition, filterClause(val)))
+}
+
+// FilterExists filters by a sub query that must return at least one row.
+// The sub query can reference fields of the parent collection with a
+// ColFieldIdentifierExpr.
+func (q *Query) FilterExists(subQuery *Query) *Query {
+	return q.FilterExpr(NewExistsExpr(subQuery.GetStatement()))
+}
+
+// FilterNotExists filters by a sub query that must not return any rows.
+func (q *Query) FilterNotExists(subQuery *Query) *Query {
+	return q.NotExpr(NewExistsExpr(subQuery.GetStatement()))
+}
+
+// filterClause converts a filter value to a clause expression.
+// Queries are used as sub queries, other values become a ValueExpr.
+func filterClause(val interface{}) Expression {
+	switch v := val.(type) {
+	case *Query:
+		return v.GetStatement()
+	case *RelationQuery:
+		return v.Query.GetStatement()
+	case *SelectStmt:
+		return v
+	}
+	return NewValueExpr(val)
 }
 
 /**
@@ -362,6 +478,14 @@ func (q *Query) Find(targetSlice ...interface{}) ([]interface{}, apperror.Error)
 	return q.backend.Query(q, targetSlice...)
 }
 
+func (q *Query) Cursor() (Cursor, apperror.Error) {
+	if q.backend == nil {
+		panic("Calling .Cursor() on query without backend")
+	}
+
+	return q.backend.QueryCursor(q)
+}
+
 func (q *Query) First(targetModel ...interface{}) (interface{}, apperror.Error) {
 	if q.backend == nil {
 		panic("Calling .First() on query without backend")
@@ -391,6 +515,15 @@ func (q *Query) Count() (int, apperror.Error) {
 	return q.backend.Count(q)
 }
 
+// Update updates all models matching the query with the values.
+// See Backend.UpdateMany.
+func (q *Query) Update(values ...*FieldValueExpr) apperror.Error {
+	if q.backend == nil {
+		panic("Calling .Update() on query without backend")
+	}
+	return q.backend.UpdateMany(q, values...)
+}
+
 func (q *Query) Delete() apperror.Error {
 	if q.backend == nil {
 		panic("Calling .Delete() on query without backend")
@@ -472,6 +605,16 @@ func (q *RelationQuery) SetBaseQuery(bq *Query) {
 	q.baseQuery = bq
 }
 
+// Clone returns a copy of the relation query.
+// The base query of the clone is the same as the one of the original.
+func (q *RelationQuery) Clone() *RelationQuery {
+	newQ := *q
+	newQ.statement = q.statement.Copy()
+	newQ.Query.statement = newQ.statement.SelectStatement()
+	newQ.Query.cloneJoins(&q.Query)
+	return &newQ
+}
+
 func (q *RelationQuery) GetRelationName() string {
 	return q.relationName
 }
@@ -621,6 +764,40 @@ func (q *RelationQuery) SetSorts(exprs []*SortExpr) *RelationQuery {
 	return q
 }
 
+/**
+ * Group by and aggregate methods.
+ */
+
+func (q *RelationQuery) GroupBy(fields ...string) *RelationQuery {
+	q.Query.GroupBy(fields...)
+	return q
+}
+
+func (q *RelationQuery) GroupByExpr(exprs ...Expression) *RelationQuery {
+	q.Query.GroupByExpr(exprs...)
+	return q
+}
+
+func (q *RelationQuery) Aggregate(name string, expr Expression) *RelationQuery {
+	q.Query.Aggregate(name, expr)
+	return q
+}
+
+func (q *RelationQuery) HavingExpr(expressions ...Expression) *RelationQuery {
+	q.Query.HavingExpr(expressions...)
+	return q
+}
+
+func (q *RelationQuery) HavingCond(field string, condition string, val interface{}) *RelationQuery {
+	q.Query.HavingCond(field, condition, val)
+	return q
+}
+
+func (q *RelationQuery) Having(field string, val interface{}) *RelationQuery {
+	q.Query.Having(field, val)
+	return q
+}
+
 /**
  * Filter methods.
  */
@@ -690,6 +867,16 @@ func (q *RelationQuery) NotCond(field string, condition string, val interface{})
 	return q
 }
 
+func (q *RelationQuery) FilterExists(subQuery *Query) *RelationQuery {
+	q.Query.FilterExists(subQuery)
+	return q
+}
+
+func (q *RelationQuery) FilterNotExists(subQuery *Query) *RelationQuery {
+	q.Query.FilterNotExists(subQuery)
+	return q
+}
+
 /**
  * Joins.
  */
@@ -787,6 +974,17 @@ func (q *Query) Normalize() apperror.Error {
 	// Normalize fields.
 	fields := make([]Expression, 0)
 	for _, field := range s.Fields() {
+		if sel, ok := field.(*FieldSelectorExpr); ok && isAggregateSelector(sel) {
+			// Aggregate function or group by field, so normalize the
+			// selected field.
+			normalized, err := q.normalizeAggregateExpr(info, sel)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, normalized)
+			continue
+		}
+
 		id, ok := field.(*IdentifierExpr)
 		if !ok {
 			// Custom field, so just accept it.
@@ -855,6 +1053,31 @@ func (q *Query) Normalize() apperror.Error {
 		return err
 	}
 
+	// Exclude soft deleted models.
+	q.applySoftDeleteScope(info)
+
+	// Normalize group by.
+	groupBy := make([]Expression, 0)
+	for _, expr := range s.GroupBy() {
+		normalized, err := q.normalizeAggregateExpr(info, expr)
+		if err != nil {
+			return err
+		}
+		groupBy = append(groupBy, normalized)
+	}
+	s.SetGroupBy(groupBy)
+
+	aggregates := s.Aggregates()
+
+	// Normalize having.
+	if s.Having() != nil {
+		having, err := q.normalizeHaving(info, aggregates, s.Having())
+		if err != nil {
+			return err
+		}
+		s.SetHaving(having)
+	}
+
 	// Normalize sorts.
 	sorts := make([]*SortExpr, 0)
 	for _, sort := range s.Sorts() {
@@ -863,9 +1086,16 @@ func (q *Query) Normalize() apperror.Error {
 		if !ok {
 			// Custom sort, just add it.
 			sorts = append(sorts, sort)
+			continue
 		}
 
 		fieldName := id.Identifier()
+		if _, ok := aggregates[fieldName]; ok {
+			// Sort by an aggregate.
+			sorts = append(sorts, sort)
+			continue
+		}
+
 		left, right := utils.StrSplitLeft(fieldName, ".")
 		if right == "" {
 			// Not a nested field.
@@ -919,13 +1149,115 @@ func (q *Query) Normalize() apperror.Error {
 			Message: fmt.Sprintf("Collection %v does not have a field %v", info.Collection(), fieldName),
 		}
 	}
+	s.SetSorts(sorts)
 
 	return nil
 }
 
+// isAggregateSelector returns true if a field selector selects an aggregate
+// function or an unnormalized field, as added by .Aggregate() and .GroupBy().
+func isAggregateSelector(sel *FieldSelectorExpr) bool {
+	_, isId := sel.Expression().(*IdentifierExpr)
+	return isId || IsAggregate(sel.Expression())
+}
+
+// normalizeAggregateExpr converts field names in group by expressions and
+// aggregate functions to backend names.
+func (q *Query) normalizeAggregateExpr(info *ModelInfo, expr Expression) (Expression, apperror.Error) {
+	switch e := expr.(type) {
+	case *IdentifierExpr:
+		attr := info.FindAttribute(e.Identifier())
+		if attr == nil {
+			return nil, &apperror.Err{
+				Public:  true,
+				Code:    "unknown_field",
+				Message: fmt.Sprintf("The collection %v does not have a field %v", info.Collection(), e.Identifier()),
+			}
+		}
+		return NewColFieldIdExpr(info.BackendName(), attr.BackendName()), nil
+
+	case *FunctionExpr:
+		nested, err := q.normalizeAggregateExpr(info, e.Expression())
+		if err != nil {
+			return nil, err
+		}
+		return NewFuncExpr(e.Function(), nested), nil
+
+	case *FieldSelectorExpr:
+		nested, err := q.normalizeAggregateExpr(info, e.Expression())
+		if err != nil {
+			return nil, err
+		}
+		return NewFieldSelectorExpr(e.Name(), nested, e.Type()), nil
+	}
+
+	if err := q.normalizeFilter(info, expr); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// normalizeHaving converts field names in a having filter to backend names.
+// Names of aggregates are kept.
+func (q *Query) normalizeHaving(info *ModelInfo, aggregates map[string]*FunctionExpr, filter Expression) (Expression, apperror.Error) {
+	switch f := filter.(type) {
+	case *AndExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			normalized, err := q.normalizeHaving(info, aggregates, expr)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, normalized)
+		}
+		return NewAndExpr(exprs...), nil
+
+	case *OrExpr:
+		exprs := make([]Expression, 0)
+		for _, expr := range f.Expressions() {
+			normalized, err := q.normalizeHaving(info, aggregates, expr)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, normalized)
+		}
+		return NewOrExpr(exprs...), nil
+
+	case *NotExpr:
+		normalized, err := q.normalizeHaving(info, aggregates, f.Not())
+		if err != nil {
+			return nil, err
+		}
+		return NewNotExpr(normalized), nil
+
+	case FilterExpression:
+		if id, ok := f.Field().(*IdentifierExpr); ok {
+			if _, ok := aggregates[id.Identifier()]; ok {
+				return filter, nil
+			}
+			field, err := q.normalizeAggregateExpr(info, id)
+			if err != nil {
+				return nil, err
+			}
+			return NewFilter(field, f.Operator(), f.Clause()), nil
+		}
+		if err := q.normalizeFilter(info, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
+
 func (q *Query) normalizeFilter(info *ModelInfo, filter Expression) apperror.Error {
 
 	switch f := filter.(type) {
+	case *SelectStmt:
+		// Sub query.
+		subQuery := NewQuery(f.Collection(), q.backend)
+		subQuery.SetStatement(f)
+		return subQuery.Normalize()
+
 	case MultiExpression:
 		for _, e := range f.Expressions() {
 			if err := q.normalizeFilter(info, e); err != nil {
@@ -933,6 +1265,11 @@ func (q *Query) normalizeFilter(info *ModelInfo, filter Expression) apperror.Err
 			}
 		}
 
+	case *ElemMatchExpr:
+		// The element filter references fields of the array elements,
+		// so only the array field is normalized.
+		return q.normalizeFilter(info, f.Field())
+
 	case NestedExpression:
 		if err := q.normalizeFilter(info, f.Expression()); err != nil {
 			return err
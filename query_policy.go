@@ -0,0 +1,372 @@
+package dukedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/theduke/go-apperror"
+)
+
+/**
+ * QueryPolicy.
+ */
+
+// QueryPolicy restricts the queries that can be built with
+// ParseQueryWithPolicy, for parsing queries received from untrusted clients.
+//
+// Only collections with a CollectionPolicy can be queried.
+type QueryPolicy struct {
+	// Collections maps collection names to their policy.
+	Collections map[string]*CollectionPolicy
+
+	// MaxLimit caps the limit of queries.
+	// Queries without a limit get MaxLimit as limit, queries with a higher
+	// limit are rejected.
+	// 0 means no cap.
+	MaxLimit int
+
+	// MaxJoinDepth restricts the nesting of joins.
+	// "tasks" has a depth of 1, "tasks.comments" a depth of 2.
+	// 0 means no restriction.
+	MaxJoinDepth int
+}
+
+// CollectionPolicy restricts the queries on a single collection.
+// Fields and relations may be specified with their name, backend name or
+// marshal name.
+type CollectionPolicy struct {
+	// Fields that may be selected, filtered and sorted by.
+	// Queries that do not request fields select all of them, so joins
+	// require the key fields to be whitelisted.
+	// If empty, all fields are allowed.
+	Fields []string
+
+	// SensitiveFields may not be used in filters or sort orders.
+	SensitiveFields []string
+
+	// Joins holds the relations that may be joined.
+	Joins []string
+
+	// Filters are mandatory equality filters (eg a tenant id), which are added
+	// to every query and join on the collection.
+	Filters map[string]interface{}
+}
+
+// policyError builds a public error for a policy violation.
+func policyError(code, message string) apperror.Error {
+	return &apperror.Err{
+		Code:    code,
+		Message: message,
+		Public:  true,
+	}
+}
+
+// collectionPolicy returns the model info and policy for a collection.
+func (p *QueryPolicy) collectionPolicy(backend Backend, collection string) (*ModelInfo, *CollectionPolicy) {
+	info := backend.ModelInfos().Find(collection)
+
+	for name, policy := range p.Collections {
+		if name == collection || (info != nil && backend.ModelInfos().Find(name) == info) {
+			return info, policy
+		}
+	}
+
+	return info, nil
+}
+
+// inList checks if the field is in the list.
+func (p *CollectionPolicy) inList(info *ModelInfo, list []string, field string) bool {
+	var attr *Attribute
+	if info != nil {
+		attr = info.FindAttribute(field)
+	}
+
+	for _, name := range list {
+		if name == field || (attr != nil && info.FindAttribute(name) == attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// canJoin checks if the relation may be joined.
+func (p *CollectionPolicy) canJoin(info *ModelInfo, relation string) bool {
+	var rel *Relation
+	if info != nil {
+		rel = info.FindRelation(relation)
+	}
+
+	for _, name := range p.Joins {
+		if name == relation || (rel != nil && info.FindRelation(name) == rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyTarget is a collection that is queried directly or through a join.
+type policyTarget struct {
+	collection string
+	info       *ModelInfo
+	policy     *CollectionPolicy
+}
+
+// checkField checks if a field may be used.
+func (t *policyTarget) checkField(field string, filter bool) apperror.Error {
+	// Nested fields of embedded attributes are checked by the attribute name.
+	field = strings.Split(field, ".")[0]
+
+	if len(t.policy.Fields) > 0 && !t.policy.inList(t.info, t.policy.Fields, field) {
+		return policyError("field_not_allowed", fmt.Sprintf("The field %v of collection %v can not be queried", field, t.collection))
+	}
+	if filter && t.policy.inList(t.info, t.policy.SensitiveFields, field) {
+		return policyError("forbidden_filter_field", fmt.Sprintf("The field %v of collection %v can not be used in filters or sort orders", field, t.collection))
+	}
+	return nil
+}
+
+// Apply checks if the query parsed from data conforms to the policy,
+// and adds the limit and the mandatory filters to it.
+// If no fields of a collection with a field whitelist were requested, the
+// query selects only the whitelisted fields.
+func (p *QueryPolicy) Apply(q *Query, data map[string]interface{}) apperror.Error {
+	backend := q.backend
+
+	info, policy := p.collectionPolicy(backend, q.GetCollection())
+	if policy == nil {
+		return policyError("collection_not_allowed", fmt.Sprintf("The collection %v can not be queried", q.GetCollection()))
+	}
+
+	// Check joins, and collect the collections of all joins by their path.
+	targets := map[string]*policyTarget{
+		"": &policyTarget{collection: q.GetCollection(), info: info, policy: policy},
+	}
+
+	joins := make([]string, 0)
+	if rawJoins, ok := data["joins"].([]interface{}); ok {
+		for _, rawJoin := range rawJoins {
+			if join, ok := rawJoin.(string); ok {
+				joins = append(joins, join)
+			}
+		}
+	}
+	// Sorting makes sure parent joins are handled before nested joins.
+	sort.Strings(joins)
+
+	for _, join := range joins {
+		parts := strings.Split(join, ".")
+		if p.MaxJoinDepth > 0 && len(parts) > p.MaxJoinDepth {
+			return policyError("join_depth_exceeded", fmt.Sprintf("The join %v exceeds the maximum join depth of %v", join, p.MaxJoinDepth))
+		}
+
+		parent := targets[strings.Join(parts[:len(parts)-1], ".")]
+		if parent == nil {
+			return policyError("join_not_allowed", fmt.Sprintf("The join %v can not be used", join))
+		}
+
+		relationName := parts[len(parts)-1]
+		if !parent.policy.canJoin(parent.info, relationName) || parent.info == nil {
+			return policyError("join_not_allowed", fmt.Sprintf("The relation %v of collection %v can not be joined", relationName, parent.collection))
+		}
+
+		relation := parent.info.FindRelation(relationName)
+		if relation == nil || relation.RelatedModel() == nil {
+			return policyError("join_not_allowed", fmt.Sprintf("The collection %v has no relation %v", parent.collection, relationName))
+		}
+
+		relatedInfo, relatedPolicy := p.collectionPolicy(backend, relation.RelatedModel().Collection())
+		if relatedPolicy == nil {
+			return policyError("collection_not_allowed", fmt.Sprintf("The collection %v can not be queried", relation.RelatedModel().Collection()))
+		}
+
+		targets[join] = &policyTarget{
+			collection: relatedInfo.Collection(),
+			info:       relatedInfo,
+			policy:     relatedPolicy,
+		}
+	}
+
+	// findTarget returns the target collection of a possibly joined field,
+	// and the field name.
+	// Fields on relations that were not joined explicitly are rejected, since
+	// the query would join them automatically, bypassing the join whitelist
+	// and the policy of the related collection.
+	findTarget := func(field string) (*policyTarget, string, apperror.Error) {
+		target, name := targets[""], field
+		parts := strings.Split(field, ".")
+		for i := len(parts) - 1; i > 0; i-- {
+			if t := targets[strings.Join(parts[:i], ".")]; t != nil {
+				target, name = t, strings.Join(parts[i:], ".")
+				break
+			}
+		}
+
+		if nameParts := strings.Split(name, "."); len(nameParts) > 1 && target.info != nil && target.info.FindRelation(nameParts[0]) != nil {
+			return nil, "", policyError("join_not_allowed", fmt.Sprintf("The field %v uses the relation %v of collection %v, which was not joined", field, nameParts[0], target.collection))
+		}
+
+		return target, name, nil
+	}
+
+	// Check fields.
+	selected := make(map[*policyTarget]bool)
+	if rawFields, ok := data["fields"].([]interface{}); ok {
+		for _, rawField := range rawFields {
+			field, _ := rawField.(string)
+			target, name, err := findTarget(field)
+			if err != nil {
+				return err
+			}
+			if err := target.checkField(name, false); err != nil {
+				return err
+			}
+			selected[target] = true
+		}
+	}
+
+	// Check orders.
+	orders, ok := data["order"].([]interface{})
+	if order, isString := data["order"].(string); isString {
+		orders, ok = []interface{}{order}, true
+	}
+	if ok {
+		for _, rawOrder := range orders {
+			field, _ := rawOrder.(string)
+			target, name, err := findTarget(strings.TrimLeft(field, "+-"))
+			if err != nil {
+				return err
+			}
+			if err := target.checkField(name, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Check filters.
+	if filters, ok := data["filters"].(map[string]interface{}); ok {
+		err := walkFilterFields(filters, func(field string) apperror.Error {
+			target, name, err := findTarget(field)
+			if err != nil {
+				return err
+			}
+			return target.checkField(name, true)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Apply the limit.
+	if p.MaxLimit > 0 {
+		if limit := q.GetLimit(); limit > p.MaxLimit {
+			return policyError("limit_exceeded", fmt.Sprintf("The limit may not exceed %v", p.MaxLimit))
+		} else if limit < 1 {
+			q.Limit(p.MaxLimit)
+		}
+	}
+
+	// Restrict queries without selected fields to the whitelisted fields.
+	for path, target := range targets {
+		if len(target.policy.Fields) == 0 || selected[target] {
+			continue
+		}
+		if path == "" {
+			q.Field(target.policy.Fields...)
+		} else {
+			q.GetJoin(path).Field(target.policy.Fields...)
+		}
+	}
+
+	// Add mandatory filters.
+	for path, target := range targets {
+		for _, field := range sortedKeys(target.policy.Filters) {
+			if path == "" {
+				q.Filter(field, target.policy.Filters[field])
+			} else {
+				q.GetJoin(path).Filter(field, target.policy.Filters[field])
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkFilterFields calls fn for every field used in a filter document.
+// Fields of array elements in $elemMatch conditions are not included.
+func walkFilterFields(data map[string]interface{}, fn func(field string) apperror.Error) apperror.Error {
+	for _, key := range sortedKeys(data) {
+		val := data[key]
+		switch key {
+		case "$and", "$or", "$nor":
+			docs, _ := val.([]interface{})
+			for _, rawDoc := range docs {
+				if doc, ok := rawDoc.(map[string]interface{}); ok {
+					if err := walkFilterFields(doc, fn); err != nil {
+						return err
+					}
+				}
+			}
+
+		case "$not":
+			if doc, ok := val.(map[string]interface{}); ok {
+				if err := walkFilterFields(doc, fn); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if !strings.HasPrefix(key, "$") {
+				if err := fn(key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseQueryWithPolicy builds a query like ParseQuery, and ensures that it
+// conforms to the policy with QueryPolicy.Apply.
+// A nil policy allows everything.
+func ParseQueryWithPolicy(backend Backend, data map[string]interface{}, policy *QueryPolicy) (*Query, apperror.Error) {
+	if policy == nil {
+		return ParseQuery(backend, data)
+	}
+
+	if data != nil {
+		if collection, _ := data["collection"].(string); collection != "" {
+			if _, collectionPolicy := policy.collectionPolicy(backend, collection); collectionPolicy == nil {
+				return nil, policyError("collection_not_allowed", fmt.Sprintf("The collection %v can not be queried", collection))
+			}
+		}
+	}
+
+	q, err := ParseQuery(backend, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := policy.Apply(q, data); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// ParseJsonQueryWithPolicy builds a query from json like ParseJsonQuery, and
+// ensures that it conforms to the policy.
+func ParseJsonQueryWithPolicy(backend Backend, js []byte, policy *QueryPolicy) (*Query, apperror.Error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(js, &data); err != nil {
+		return nil, &apperror.Err{
+			Public:  true,
+			Code:    "invalid_json",
+			Message: "Query json could not be unmarshaled. Check for invalid json.",
+		}
+	}
+
+	return ParseQueryWithPolicy(backend, data, policy)
+}
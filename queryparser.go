@@ -3,6 +3,8 @@ package dukedb
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/theduke/go-reflector"
@@ -33,6 +35,7 @@ func ParseJsonQuery(backend Backend, js []byte) (*Query, apperror.Error) {
 //
 // It returns a Query equal to the Mongo query, with unsupported features omitted.
 // An error is returned if the building of the query fails.
+// Use ParseQueryWithPolicy for queries received from untrusted clients.
 //
 // Format: {
 //   // Order by field:
@@ -56,7 +59,12 @@ func ParseJsonQuery(backend Backend, js []byte) (*Query, apperror.Error) {
 //  limit: 100,
 //
 //  // Offset:
-//  offset: 20
+//  offset: 20,
+//
+//  // Soft deleted models:
+//  withDeleted: true,
+//  // or
+//  onlyDeleted: true
 // }
 //
 func ParseQuery(backend Backend, data map[string]interface{}) (*Query, apperror.Error) {
@@ -235,6 +243,31 @@ func ParseQuery(backend Backend, data map[string]interface{}) (*Query, apperror.
 		}
 	}
 
+	// Handle soft deleted models.
+	flags := make(map[string]bool)
+	for _, key := range []string{"withDeleted", "onlyDeleted"} {
+		if rawFlag, ok := data[key]; ok {
+			flag, ok := rawFlag.(bool)
+			if !ok {
+				return nil, &apperror.Err{
+					Code:    "deleted_flag_non_boolean",
+					Message: fmt.Sprintf("%v must be a boolean", key),
+				}
+			}
+			flags[key] = flag
+		}
+	}
+	if flags["withDeleted"] && flags["onlyDeleted"] {
+		return nil, &apperror.Err{
+			Code:    "invalid_deleted_flags",
+			Message: "withDeleted and onlyDeleted can not be combined",
+		}
+	} else if flags["withDeleted"] {
+		q.WithDeleted()
+	} else if flags["onlyDeleted"] {
+		q.OnlyDeleted()
+	}
+
 	return q, nil
 }
 
@@ -273,16 +306,33 @@ func parseQueryJoins(q *Query, joins []string, depth int) ([]string, apperror.Er
 }
 
 func parseQueryFilters(q *Query, filters map[string]interface{}) apperror.Error {
-	filter, err := parseQueryFilter("", filters, q)
+	filter, err := parseFilterDocument("filters", filters, q, true)
 	if err != nil {
 		return err
 	}
-	q.FilterExpr(filter)
+	if filter != nil {
+		q.FilterExpr(filter)
+	}
 	return nil
 }
 
+// filterError builds a public error for an invalid filter, naming the path
+// of the offending key.
+func filterError(code, path, message string) apperror.Error {
+	return &apperror.Err{
+		Code:    code,
+		Message: fmt.Sprintf("Invalid filter at '%v': %v", path, message),
+		Public:  true,
+	}
+}
+
 func setExpressionIdentifier(expr interface{}, forCollection, identifier string) {
-	if multi, ok := expr.(MultiExpression); ok {
+	if elemMatch, ok := expr.(*ElemMatchExpr); ok {
+		// Only the array field is set, the element filter has its own identifiers.
+		setExpressionIdentifier(elemMatch.Field(), forCollection, identifier)
+	} else if not, ok := expr.(*NotExpr); ok {
+		setExpressionIdentifier(not.Not(), forCollection, identifier)
+	} else if multi, ok := expr.(MultiExpression); ok {
 		for _, expr := range multi.Expressions() {
 			setExpressionIdentifier(expr, forCollection, identifier)
 		}
@@ -299,20 +349,189 @@ func setExpressionIdentifier(expr interface{}, forCollection, identifier string)
 	}
 }
 
-// Parses a mongo query filter to a Filter.
-// All mongo operators expect $nor are supported.
+// sortedKeys returns the keys of a filter document in a stable order.
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Parses a mongo query filter document to an Expression.
+// Supported are the logical operators $and, $or, $nor and $not, and the
+// operators handled by parseFilterOperator.
 // Refer to http://docs.mongodb.org/manual/reference/operator/query.
-func parseQueryFilter(name string, data interface{}, query *Query) (Expression, apperror.Error) {
-	// Handle
-	switch name {
+//
+// If query is not nil, filters on fields of joined relations
+// (eg "relation.field") are added to the join query.
+// Since the join filters can not be negated or combined with other filters,
+// they are rejected if joinFilters is false, which is the case inside of
+// $or, $nor and $not.
+// Returns nil if the document is empty.
+func parseFilterDocument(path string, data map[string]interface{}, query *Query, joinFilters bool) (Expression, apperror.Error) {
+	and := NewAndExpr()
+
+	for _, key := range sortedKeys(data) {
+		keyPath := path + "." + key
+
+		switch key {
+		case "$and", "$or", "$nor":
+			filters, err := parseFilterList(keyPath, key, data[key], query, joinFilters && key == "$and")
+			if err != nil {
+				return nil, err
+			}
+
+			if key == "$and" {
+				and.Add(NewAndExpr(filters...))
+			} else if key == "$or" {
+				and.Add(NewOrExpr(filters...))
+			} else {
+				and.Add(NewNotExpr(NewOrExpr(filters...)))
+			}
+			continue
+
+		case "$not":
+			doc, ok := data[key].(map[string]interface{})
+			if !ok {
+				return nil, filterError("invalid_not_data", keyPath, "$not expects a filter document")
+			}
+			filter, err := parseFilterDocument(keyPath, doc, query, false)
+			if err != nil {
+				return nil, err
+			}
+			if filter == nil {
+				return nil, filterError("invalid_not_data", keyPath, "$not expects a non-empty filter document")
+			}
+			and.Add(NewNotExpr(filter))
+			continue
+		}
+
+		if strings.HasPrefix(key, "$") {
+			return nil, filterError("unknown_filter_operator", keyPath, fmt.Sprintf("unknown operator %v", key))
+		}
+
+		filter, err := parseFieldCondition(keyPath, data[key])
+		if err != nil {
+			return nil, err
+		}
+
+		// Check for joins.
+		parts := strings.Split(key, ".")
+		if query != nil && len(parts) > 1 {
+			// Possibly a field on a joined model. Check if a parent join can be found.
+			joinQ := query.GetJoin(strings.Join(parts[:len(parts)-1], "."))
+			if joinQ != nil {
+				if !joinFilters {
+					return nil, filterError("invalid_join_filter", keyPath, "filters on joined relations can not be used in $or, $nor or $not")
+				}
+
+				// Join query found, add filter to the join query.
+				setExpressionIdentifier(filter, joinQ.GetCollection(), parts[len(parts)-1])
+				joinQ.FilterExpr(filter)
+				continue
+			}
+		}
+
+		setExpressionIdentifier(filter, "", key)
+		and.Add(filter)
+	}
+
+	switch len(and.Expressions()) {
+	case 0:
+		return nil, nil
+	case 1:
+		return and.Expressions()[0], nil
+	}
+	return and, nil
+}
+
+// parseFilterList parses the array of filter documents of $and, $or and $nor.
+func parseFilterList(path, operator string, data interface{}, query *Query, joinFilters bool) ([]Expression, apperror.Error) {
+	code := "invalid_" + strings.TrimPrefix(operator, "$") + "_data"
+
+	rawFilters, ok := data.([]interface{})
+	if !ok || len(rawFilters) == 0 {
+		return nil, filterError(code, path, fmt.Sprintf("%v expects a non-empty array of filter documents", operator))
+	}
+
+	filters := make([]Expression, 0)
+	for index, rawFilter := range rawFilters {
+		itemPath := fmt.Sprintf("%v[%v]", path, index)
+
+		doc, ok := rawFilter.(map[string]interface{})
+		if !ok {
+			return nil, filterError(code, itemPath, fmt.Sprintf("%v expects a non-empty array of filter documents", operator))
+		}
+		filter, err := parseFilterDocument(itemPath, doc, query, joinFilters)
+		if err != nil {
+			return nil, err
+		}
+		if filter == nil {
+			return nil, filterError(code, itemPath, "empty filter document")
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// parseFieldCondition parses the condition for a single field, which is
+// either a plain value for an equality check or a document of operators.
+// The returned filters use a placeholder identifier that has to be replaced
+// with setExpressionIdentifier.
+func parseFieldCondition(path string, data interface{}) (Expression, apperror.Error) {
+	doc, ok := data.(map[string]interface{})
+	if !ok {
+		return Eq("", "placeholder", data), nil
+	}
+
+	if len(doc) == 0 {
+		return nil, filterError("invalid_filter_condition", path, "empty operator document")
+	}
+
+	and := NewAndExpr()
+	for _, key := range sortedKeys(doc) {
+		keyPath := path + "." + key
+		if !strings.HasPrefix(key, "$") {
+			return nil, filterError("invalid_filter_condition", keyPath, fmt.Sprintf("expected an operator, got %v (use dotted field names for nested fields)", key))
+		}
+
+		filter, err := parseFilterOperator(keyPath, key, doc[key])
+		if err != nil {
+			return nil, err
+		}
+		and.Add(filter)
+	}
+
+	if len(and.Expressions()) == 1 {
+		return and.Expressions()[0], nil
+	}
+	return and, nil
+}
+
+// parseFilterOperator parses a single operator of a field condition.
+func parseFilterOperator(path, operator string, data interface{}) (Expression, apperror.Error) {
+	switch operator {
 	case "$eq":
 		return Eq("", "placeholder", data), nil
 	case "$ne":
 		return Neq("", "placeholder", data), nil
-	case "$in":
-		return In("", "placeholder", data), nil
+	case "$in", "$nin":
+		if data == nil || reflect.TypeOf(data).Kind() != reflect.Slice {
+			return nil, filterError("invalid_"+operator[1:]+"_value", path, fmt.Sprintf("%v expects an array", operator))
+		}
+		if operator == "$in" {
+			return In("", "placeholder", data), nil
+		}
+		return NotIn("", "placeholder", data), nil
 	case "$like":
 		return Like("", "placeholder", data), nil
+	case "$nlike":
+		return NotLike("", "placeholder", data), nil
+	case "$ilike":
+		return ILike("", "placeholder", data), nil
 	case "$gt":
 		return Gt("", "placeholder", data), nil
 	case "$gte":
@@ -321,96 +540,93 @@ func parseQueryFilter(name string, data interface{}, query *Query) (Expression,
 		return Lt("", "placeholder", data), nil
 	case "$lte":
 		return Lte("", "placeholder", data), nil
-	case "$nin":
-		return NewNotExpr(In("", "placeholder", data)), nil
-	}
 
-	if name == "$nor" {
-		return nil, &apperror.Err{
-			Code:    "unsupported_nor_query",
-			Message: "$nor queryies are not supported",
+	case "$not":
+		// $not either negates an operator document or a regular expression.
+		if pattern, ok := data.(string); ok {
+			return NewNotExpr(Regex("", "placeholder", pattern)), nil
 		}
-	}
+		if _, ok := data.(map[string]interface{}); !ok {
+			return nil, filterError("invalid_not_data", path, "$not expects an operator document or a regular expression")
+		}
+		filter, err := parseFieldCondition(path, data)
+		if err != nil {
+			return nil, err
+		}
+		return NewNotExpr(filter), nil
 
-	// Handle OR.
-	if name == "$or" {
-		orClauses, ok := data.([]interface{})
+	case "$exists":
+		exists, ok := data.(bool)
 		if !ok {
-			return nil, &apperror.Err{Code: "invalid_or_data"}
+			return nil, filterError("invalid_exists_value", path, "$exists expects a boolean")
 		}
-
-		or := NewOrExpr()
-		for _, rawClause := range orClauses {
-			clause, ok := rawClause.(map[string]interface{})
-			if !ok {
-				return nil, &apperror.Err{Code: "invalid_or_data"}
-			}
-
-			filter, err := parseQueryFilter("", clause, query)
-			if err != nil {
-				return nil, err
-			}
-			or.Add(filter)
+		if exists {
+			return IsNotNull("", "placeholder"), nil
 		}
+		return IsNull("", "placeholder"), nil
 
-		return or, nil
-	}
-
-	if nestedData, ok := data.(map[string]interface{}); ok {
-		// Nested dict with multipe AND clauses.
+	case "$regex":
+		pattern, ok := data.(string)
+		if !ok {
+			return nil, filterError("invalid_regex_value", path, "$regex expects a string")
+		}
+		return Regex("", "placeholder", pattern), nil
 
-		// Build an AND filter.
-		and := NewAndExpr()
-		for key := range nestedData {
-			filter, err := parseQueryFilter(key, nestedData[key], query)
-			if err != nil {
-				return nil, err
-			}
+	case "$between":
+		bounds, ok := data.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, filterError("invalid_between_value", path, "$between expects an array with two values")
+		}
+		return Between("", "placeholder", bounds[0], bounds[1]), nil
 
-			doAdd := true
+	case "$size":
+		size, err := reflector.Reflect(data).ConvertTo(float64(0))
+		if data == nil || err != nil {
+			return nil, filterError("invalid_size_value", path, "$size expects a number")
+		}
+		if f := size.(float64); f < 0 || f != float64(int(f)) {
+			return nil, filterError("invalid_size_value", path, "$size expects a non-negative integer")
+		}
+		return Size("", "placeholder", int(size.(float64))), nil
 
-			if key == "$or" || key == "$and" || key == "$not" {
-				// Do nothing
-			} else {
-				field := name
-				if field == "" {
-					field = key
-				}
+	case "$elemMatch":
+		return parseElemMatch(path, data)
+	}
 
-				// Check for joins.
-
-				parts := strings.Split(field, ".")
-				if len(parts) > 1 {
-					// Possibly a field on a joined model. Check if a parent join can be found.
-					joinQ := query.GetJoin(strings.Join(parts[:len(parts)-1], "."))
-					if joinQ != nil {
-						// Join query found, add filter to the join query.
-						fieldName := parts[len(parts)-1]
-						setExpressionIdentifier(filter, joinQ.GetCollection(), fieldName)
-						joinQ.FilterExpr(filter)
-						// Set flag to prevent adding to regular query.
-						doAdd = false
-					}
-				}
+	return nil, filterError("unknown_filter_operator", path, fmt.Sprintf("unknown operator %v", operator))
+}
 
-				if doAdd {
-					setExpressionIdentifier(filter, "", field)
-				}
-			}
+// parseElemMatch parses an $elemMatch condition.
+// Conditions with only operators (eg {$gt: 1, $lt: 5}) are checked against
+// the array elements themselves, other documents against the fields of the
+// elements.
+func parseElemMatch(path string, data interface{}) (Expression, apperror.Error) {
+	doc, ok := data.(map[string]interface{})
+	if !ok || len(doc) == 0 {
+		return nil, filterError("invalid_elem_match_value", path, "$elemMatch expects a non-empty filter document")
+	}
 
-			if doAdd {
-				and.Add(filter)
-			}
+	scalar := true
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") || key == "$and" || key == "$or" || key == "$nor" {
+			scalar = false
+			break
 		}
+	}
 
-		if len(and.Expressions()) == 1 {
-			return and.Expressions()[0], nil
-		} else {
-			return and, nil
+	var filter Expression
+	var err apperror.Error
+	if scalar {
+		filter, err = parseFieldCondition(path, doc)
+		if err == nil {
+			setExpressionIdentifier(filter, "", ELEM_MATCH_SELF)
 		}
+	} else {
+		filter, err = parseFilterDocument(path, doc, nil, false)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// If execution reaches this point, the filter must be a simple equals filter
-	// with a value.
-	return Eq("", name, data), nil
+	return NewElemMatchExpr(NewIdExpr("placeholder"), filter), nil
 }
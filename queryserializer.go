@@ -0,0 +1,392 @@
+package dukedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/theduke/go-apperror"
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Query serialization functions.
+ */
+
+// mongoOperators maps filter operators to the operators understood by ParseQuery.
+var mongoOperators = map[string]string{
+	OPERATOR_EQ:      "$eq",
+	OPERATOR_NEQ:     "$ne",
+	OPERATOR_IN:      "$in",
+	OPERATOR_NIN:     "$nin",
+	OPERATOR_LIKE:    "$like",
+	OPERATOR_NLIKE:   "$nlike",
+	OPERATOR_ILIKE:   "$ilike",
+	OPERATOR_GT:      "$gt",
+	OPERATOR_GTE:     "$gte",
+	OPERATOR_LT:      "$lt",
+	OPERATOR_LTE:     "$lte",
+	OPERATOR_REGEX:   "$regex",
+	OPERATOR_BETWEEN: "$between",
+	OPERATOR_SIZE:    "$size",
+}
+
+// ToJson serializes the query to the json format understood by ParseJsonQuery.
+// See QueryToMap.
+func (q *Query) ToJson() ([]byte, apperror.Error) {
+	data, err := QueryToMap(q)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err2 := json.Marshal(data)
+	if err2 != nil {
+		return nil, apperror.Wrap(err2, "json_marshal_error", "Could not marshal the query to json")
+	}
+	return js, nil
+}
+
+// QueryToMap converts a query to the map format understood by ParseQuery.
+//
+// Only queries that could have been built by ParseQuery can be converted.
+// An error is returned for expressions that can not be represented, like
+// sub queries, custom joins or aggregates.
+func QueryToMap(q *Query) (map[string]interface{}, apperror.Error) {
+	data := map[string]interface{}{
+		"collection": q.GetCollection(),
+	}
+
+	joins := make([]interface{}, 0)
+	fields := make([]interface{}, 0)
+	orders := make([]interface{}, 0)
+	filters := make([]*filterSerializer, 0)
+
+	if err := serializeQuery(q, "", q.GetCollection(), &joins, &fields, &orders, &filters); err != nil {
+		return nil, err
+	}
+
+	if len(joins) > 0 {
+		data["joins"] = joins
+	}
+	if len(fields) > 0 {
+		data["fields"] = fields
+	}
+	if len(orders) > 0 {
+		data["order"] = orders
+	}
+
+	filterDocs := make([]map[string]interface{}, 0)
+	for _, filter := range filters {
+		doc, err := filter.document(filter.filter)
+		if err != nil {
+			return nil, err
+		}
+		filterDocs = append(filterDocs, doc)
+	}
+	if len(filterDocs) > 0 {
+		data["filters"] = mergeFilterDocuments(filterDocs)
+	}
+
+	if limit := q.GetLimit(); limit > 0 {
+		data["limit"] = limit
+	}
+	if offset := q.GetOffset(); offset > 0 {
+		data["offset"] = offset
+	}
+
+	if q.withDeleted {
+		data["withDeleted"] = true
+	} else if q.onlyDeleted {
+		data["onlyDeleted"] = true
+	}
+
+	return data, nil
+}
+
+// serializeQuery collects the joins, fields, orders and filters of a query and
+// its joins.
+// Fields and filters of joins are prefixed with the join path.
+func serializeQuery(q *Query, prefix, collection string, joins, fields, orders *[]interface{}, filters *[]*filterSerializer) apperror.Error {
+	stmt := q.GetStatement()
+
+	if stmt.IsAggregate() {
+		return apperror.New("unsupported_expression", "Aggregate queries can not be serialized", true)
+	} else if len(stmt.GroupBy()) > 0 || stmt.Having() != nil {
+		return apperror.New("unsupported_expression", "Grouped queries can not be serialized", true)
+	}
+
+	if prefix != "" && (q.GetLimit() > 0 || q.GetOffset() > 0) {
+		return apperror.New("unsupported_expression", fmt.Sprintf("The join %v has a limit or offset, which can not be serialized", prefix), true)
+	}
+	if prefix != "" && (q.withDeleted || q.onlyDeleted) {
+		return apperror.New("unsupported_expression", fmt.Sprintf("The join %v includes soft deleted models, which can not be serialized", prefix), true)
+	}
+
+	for _, field := range stmt.Fields() {
+		if sel, ok := field.(*FieldSelectorExpr); ok {
+			field = sel.Expression()
+		}
+		name, err := identifierName(field, collection, stmt.Collection())
+		if err != nil {
+			return err
+		}
+		*fields = append(*fields, prefix+name)
+	}
+
+	for _, sortExpr := range stmt.Sorts() {
+		if prefix != "" {
+			return apperror.New("unsupported_expression", fmt.Sprintf("The join %v has sorts, which can not be serialized", prefix), true)
+		}
+		name, err := identifierName(sortExpr.Expression(), collection, stmt.Collection())
+		if err != nil {
+			return err
+		}
+		if !sortExpr.Ascending() {
+			name = "-" + name
+		}
+		*orders = append(*orders, name)
+	}
+
+	if filter := stmt.Filter(); filter != nil {
+		*filters = append(*filters, &filterSerializer{
+			filter:      filter,
+			prefix:      prefix,
+			collections: []string{collection, stmt.Collection()},
+		})
+	}
+
+	// Handle joins sorted by name for a stable result.
+	names := make([]string, 0)
+	for name := range q.GetJoins() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		joinQ := q.GetJoins()[name]
+		if joinQ.GetRelationName() == "" {
+			return apperror.New("unsupported_expression", "Custom joins can not be serialized", true)
+		} else if joinQ.GetJoinType() != JOIN_LEFT {
+			return apperror.New("unsupported_expression", fmt.Sprintf("The join %v has the join type %v, only left joins can be serialized", prefix+name, joinQ.GetJoinType()), true)
+		}
+
+		*joins = append(*joins, prefix+joinQ.GetRelationName())
+		err := serializeQuery(&joinQ.Query, prefix+joinQ.GetRelationName()+".", joinQ.GetCollection(), joins, fields, orders, filters)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// identifierName returns the field name of an identifier expression.
+// Collection field identifiers must reference one of the given collections.
+func identifierName(expr Expression, collections ...string) (string, apperror.Error) {
+	switch e := expr.(type) {
+	case *IdentifierExpr:
+		return e.Identifier(), nil
+
+	case *ColFieldIdentifierExpr:
+		if e.Collection() == "" {
+			return e.Field(), nil
+		}
+		for _, collection := range collections {
+			if collection != "" && collection == e.Collection() {
+				return e.Field(), nil
+			}
+		}
+		return "", apperror.New("unsupported_expression", fmt.Sprintf("The field %v.%v references another collection and can not be serialized", e.Collection(), e.Field()), true)
+	}
+
+	return "", apperror.New("unsupported_expression", fmt.Sprintf("Expressions of type %v can not be serialized", reflect.TypeOf(expr)), true)
+}
+
+// filterSerializer converts the filter of a query or join to a mongo style
+// filter document.
+type filterSerializer struct {
+	filter Expression
+
+	// prefix is the join path that is prepended to field names.
+	prefix string
+
+	// collections holds the collection names that may be referenced by
+	// field identifiers.
+	collections []string
+}
+
+// fieldName returns the prefixed name of a filtered field.
+func (f *filterSerializer) fieldName(expr Expression) (string, apperror.Error) {
+	name, err := identifierName(expr, f.collections...)
+	if err != nil {
+		return "", err
+	}
+	return f.prefix + name, nil
+}
+
+// document converts a filter expression to a filter document.
+func (f *filterSerializer) document(expr Expression) (map[string]interface{}, apperror.Error) {
+	switch e := expr.(type) {
+	case *AndExpr:
+		docs := make([]map[string]interface{}, 0)
+		for _, expr := range e.Expressions() {
+			doc, err := f.document(expr)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+		return mergeFilterDocuments(docs), nil
+
+	case *OrExpr:
+		docs, err := f.documents(e.Expressions())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$or": docs}, nil
+
+	case *NotExpr:
+		if or, ok := e.Not().(*OrExpr); ok {
+			docs, err := f.documents(or.Expressions())
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"$nor": docs}, nil
+		}
+
+		doc, err := f.document(e.Not())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$not": doc}, nil
+
+	case *ElemMatchExpr:
+		field, err := f.fieldName(e.Field())
+		if err != nil {
+			return nil, err
+		}
+
+		var doc map[string]interface{}
+		if e.MatchesScalars() {
+			doc, err = f.condition(e.Filter())
+		} else {
+			// Fields in the element filter reference the elements, so they
+			// are not prefixed.
+			doc, err = (&filterSerializer{}).document(e.Filter())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{field: map[string]interface{}{"$elemMatch": doc}}, nil
+
+	case FilterExpression:
+		field, err := f.fieldName(e.Field())
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Operator() == OPERATOR_EQ {
+			// Plain values are equality filters, unless they would be parsed as
+			// an operator document.
+			if val, ok := e.Clause().(*ValueExpr); ok {
+				if _, isMap := val.Value().(map[string]interface{}); !isMap {
+					return map[string]interface{}{field: val.Value()}, nil
+				}
+			}
+		}
+
+		condition, err := f.condition(e)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{field: condition}, nil
+	}
+
+	return nil, apperror.New("unsupported_expression", fmt.Sprintf("Filter expressions of type %v can not be serialized", reflect.TypeOf(expr)), true)
+}
+
+func (f *filterSerializer) documents(exprs []Expression) ([]interface{}, apperror.Error) {
+	docs := make([]interface{}, 0)
+	for _, expr := range exprs {
+		doc, err := f.document(expr)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// condition converts a filter on a single field to an operator document
+// like {$gt: 1, $lt: 5}.
+func (f *filterSerializer) condition(expr Expression) (map[string]interface{}, apperror.Error) {
+	switch e := expr.(type) {
+	case *AndExpr:
+		doc := make(map[string]interface{})
+		for _, expr := range e.Expressions() {
+			condition, err := f.condition(expr)
+			if err != nil {
+				return nil, err
+			}
+			for key, val := range condition {
+				if _, ok := doc[key]; ok {
+					return nil, apperror.New("unsupported_expression", fmt.Sprintf("The operator %v is used multiple times and can not be serialized", key), true)
+				}
+				doc[key] = val
+			}
+		}
+		return doc, nil
+
+	case *NotExpr:
+		condition, err := f.condition(e.Not())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$not": condition}, nil
+
+	case FilterExpression:
+		operator := e.Operator()
+
+		if IsNullOperator(operator) {
+			return map[string]interface{}{"$exists": operator == OPERATOR_NOT_NULL}, nil
+		}
+
+		mongoOperator, ok := mongoOperators[operator]
+		if !ok {
+			return nil, apperror.New("unsupported_expression", fmt.Sprintf("The operator %v can not be serialized", operator), true)
+		}
+
+		val, ok := e.Clause().(*ValueExpr)
+		if !ok {
+			return nil, apperror.New("unsupported_expression", fmt.Sprintf("Filter clauses of type %v can not be serialized", reflect.TypeOf(e.Clause())), true)
+		}
+
+		return map[string]interface{}{mongoOperator: val.Value()}, nil
+	}
+
+	return nil, apperror.New("unsupported_expression", fmt.Sprintf("Filter expressions of type %v can not be serialized", reflect.TypeOf(expr)), true)
+}
+
+// mergeFilterDocuments combines filter documents into one document.
+// If the documents use the same keys, they are combined with $and.
+func mergeFilterDocuments(docs []map[string]interface{}) map[string]interface{} {
+	if len(docs) == 1 {
+		return docs[0]
+	}
+
+	merged := make(map[string]interface{})
+	for _, doc := range docs {
+		for key, val := range doc {
+			if _, ok := merged[key]; ok {
+				and := make([]interface{}, 0)
+				for _, doc := range docs {
+					and = append(and, doc)
+				}
+				return map[string]interface{}{"$and": and}
+			}
+			merged[key] = val
+		}
+	}
+	return merged
+}
@@ -0,0 +1,137 @@
+package dukedb
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/theduke/go-apperror"
+
+	. "github.com/theduke/go-dukedb/expressions"
+)
+
+/**
+ * Soft delete.
+ */
+
+// WithDeleted includes soft deleted models in the query result.
+func (q *Query) WithDeleted() *Query {
+	q.withDeleted = true
+	q.onlyDeleted = false
+	return q
+}
+
+// OnlyDeleted restricts the query result to soft deleted models.
+func (q *Query) OnlyDeleted() *Query {
+	q.withDeleted = false
+	q.onlyDeleted = true
+	return q
+}
+
+// applySoftDeleteScope adds the filter that excludes soft deleted models,
+// or selects only them with OnlyDeleted().
+// It is called by Normalize(), and only adds the filter once.
+func (q *Query) applySoftDeleteScope(info *ModelInfo) {
+	if q.softDeleteScoped {
+		return
+	}
+	if filter := q.softDeleteFilter(info); filter != nil {
+		q.statement.FilterAnd(filter)
+		q.softDeleteScoped = true
+	}
+}
+
+// softDeleteFilter returns the soft delete filter for the query, or nil if
+// the model is not soft deletable or deleted models are included.
+func (q *Query) softDeleteFilter(info *ModelInfo) *Filter {
+	attr := info.SoftDeleteAttribute()
+	if attr == nil || q.withDeleted {
+		return nil
+	}
+
+	if q.onlyDeleted {
+		return IsNotNull(info.BackendName(), attr.BackendName())
+	}
+	return IsNull(info.BackendName(), attr.BackendName())
+}
+
+// softDelete sets the deletion time of a model.
+func (b *BaseBackend) softDelete(info *ModelInfo, model interface{}) apperror.Error {
+	now := time.Now()
+	return b.setDeletedAt(info, model, &now)
+}
+
+// setDeletedAt updates the soft delete attribute of a model.
+// On error, the model is left unchanged.
+func (b *BaseBackend) setDeletedAt(info *ModelInfo, model interface{}, deletedAt *time.Time) apperror.Error {
+	attr := info.SoftDeleteAttribute()
+
+	field := reflect.Indirect(reflect.ValueOf(model)).FieldByName(attr.Name())
+	oldDeletedAt := field.Interface()
+	field.Set(reflect.ValueOf(deletedAt))
+
+	var value interface{}
+	if deletedAt != nil {
+		value = *deletedAt
+	}
+	values := []*FieldValueExpr{NewFieldVal(attr.BackendName(), value)}
+	fields := []string{attr.BackendName()}
+
+	// With optimistic locking, the version is incremented as well.
+	var version reflect.Value
+	var oldVersion int64
+	if versionAttr := info.VersionAttribute(); versionAttr != nil {
+		version = versionField(model, versionAttr)
+		oldVersion = versionInt(version)
+		setVersion(version, oldVersion+1)
+		values = append(values, NewFieldVal(versionAttr.BackendName(), version.Interface()))
+		fields = append(fields, versionAttr.BackendName())
+	}
+
+	stmt := NewUpdateStmt(info.BackendName(), values, info.ModelSelect(model))
+	stmt.SetRawValue(model)
+
+	var err apperror.Error
+	if version.IsValid() {
+		err = b.execLocked(info, model, stmt, stmt.Select(), oldVersion)
+	} else {
+		err = b.backend.Exec(stmt)
+	}
+
+	if err != nil {
+		field.Set(reflect.ValueOf(oldDeletedAt))
+		if version.IsValid() {
+			setVersion(version, oldVersion)
+		}
+		return err
+	}
+
+	// The other fields may still be dirty, so only the written fields are
+	// updated in the snapshot.
+	return takeSnapshot(info, model, fields...)
+}
+
+// Restore undoes the soft delete of a model.
+func (b *BaseBackend) Restore(model interface{}) apperror.Error {
+	info, err := b.backend.InfoForModel(model)
+	if err != nil {
+		return err
+	}
+
+	if info.SoftDeleteAttribute() == nil {
+		return apperror.New("not_soft_deletable", "Only models with a soft-delete field can be restored")
+	}
+
+	hasId, err := info.ModelHasId(model)
+	if err != nil {
+		return err
+	} else if !hasId {
+		return apperror.New("model_without_id", "Can't restore a model without an id.")
+	}
+
+	return b.setDeletedAt(info, model, nil)
+}
+
+// ForceDelete permanently deletes a model, even if it is soft deletable.
+func (b *BaseBackend) ForceDelete(model interface{}) apperror.Error {
+	return b.deleteModel(model, true)
+}
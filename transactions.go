@@ -0,0 +1,88 @@
+package dukedb
+
+import (
+	"fmt"
+
+	"github.com/theduke/go-apperror"
+)
+
+// WithTransaction runs fn in a new transaction.
+//
+// If fn returns an error or panics, the transaction is rolled back.
+// Otherwise it is committed.
+// Errors returned by fn are returned unchanged if they are an apperror.Error,
+// and wrapped with the code transaction_failed otherwise.
+func WithTransaction(backend TransactionBackend, fn func(tx Transaction) error) apperror.Error {
+	return WithTransactionRetry(backend, 0, fn)
+}
+
+// WithTransactionRetry runs fn in a new transaction, just like
+// WithTransaction().
+//
+// If the transaction fails with an error that the backend reports as
+// retryable (see RetryableErrorBackend), like a serialization failure or a
+// deadlock, the whole transaction is retried up to maxRetries times.
+func WithTransactionRetry(backend TransactionBackend, maxRetries int, fn func(tx Transaction) error) apperror.Error {
+	for attempt := 0; ; attempt++ {
+		err := runTransaction(backend, fn)
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxRetries || !IsRetryableError(backend, err) {
+			return err
+		}
+		backend.Logger().Debugf("Retrying transaction after error: %v", err)
+	}
+}
+
+func runTransaction(backend TransactionBackend, fn func(tx Transaction) error) (err apperror.Error) {
+	tx, err := backend.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				backend.Logger().Errorf("Could not roll back transaction after panic: %v", rollbackErr)
+			}
+			err = apperror.New("transaction_panic", fmt.Sprintf("Panic in transaction: %v", r))
+		}
+	}()
+
+	if fnErr := fn(tx); fnErr != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			backend.Logger().Errorf("Could not roll back transaction: %v", rollbackErr)
+		}
+
+		if appErr, ok := fnErr.(apperror.Error); ok {
+			return appErr
+		}
+		return apperror.Wrap(fnErr, "transaction_failed")
+	}
+
+	return tx.Commit()
+}
+
+// IsRetryableError returns true if the backend implements
+// RetryableErrorBackend and reports err or one of the errors it wraps as
+// retryable.
+func IsRetryableError(backend Backend, err error) bool {
+	detector, ok := backend.(RetryableErrorBackend)
+	if !ok || err == nil {
+		return false
+	}
+
+	if detector.IsRetryableError(err) {
+		return true
+	}
+	if appErr, ok := err.(apperror.Error); ok {
+		for _, nested := range appErr.GetErrors() {
+			if IsRetryableError(backend, nested) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
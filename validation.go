@@ -0,0 +1,231 @@
+package dukedb
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/theduke/go-apperror"
+	"github.com/theduke/go-reflector"
+)
+
+/**
+ * Validation.
+ */
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	// Field is the marshal name of the field.
+	Field   string                 `json:"field"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// NewFieldError creates a new FieldError.
+// params must contain key/value pairs, like NewFieldError("name", "code", "msg", "min", 5).
+func NewFieldError(field, code, message string, params ...interface{}) *FieldError {
+	e := &FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	}
+	if len(params) > 0 {
+		e.Params = make(map[string]interface{})
+		for i := 0; i+1 < len(params); i += 2 {
+			e.Params[fmt.Sprintf("%v", params[i])] = params[i+1]
+		}
+	}
+	return e
+}
+
+// ValidationError is returned when a model fails validation.
+// It holds the errors of all invalid fields.
+type ValidationError struct {
+	*apperror.Err
+
+	// FieldErrors maps the marshal name of invalid fields to their errors.
+	FieldErrors map[string][]*FieldError `json:"fieldErrors"`
+}
+
+// NewValidationError creates an empty validation error.
+func NewValidationError(message string) *ValidationError {
+	return &ValidationError{
+		Err: &apperror.Err{
+			Code:    "validation_error",
+			Message: message,
+			Public:  true,
+		},
+		FieldErrors: make(map[string][]*FieldError),
+	}
+}
+
+// AddFieldError adds the error of a field.
+func (e *ValidationError) AddFieldError(err *FieldError) {
+	e.FieldErrors[err.Field] = append(e.FieldErrors[err.Field], err)
+	e.Errors = append(e.Errors, err)
+}
+
+// HasErrors returns true if any errors were added.
+func (e *ValidationError) HasErrors() bool {
+	return len(e.Errors) > 0
+}
+
+// FieldErrorCodes returns the error codes of a field.
+func (e *ValidationError) FieldErrorCodes(field string) []string {
+	codes := make([]string, 0)
+	for _, err := range e.FieldErrors[field] {
+		codes = append(codes, err.Code)
+	}
+	return codes
+}
+
+// stringValue returns the string value of a string or string pointer.
+func stringValue(val interface{}) (string, bool) {
+	v := reflect.Indirect(reflect.ValueOf(val))
+	if !v.IsValid() || v.Kind() != reflect.String {
+		return "", false
+	}
+	return v.String(), true
+}
+
+// validateAttribute validates the value of a single attribute and adds
+// all errors to the validation error.
+// An error is only returned if the attribute has an invalid configuration.
+func validateAttribute(info *ModelInfo, attr *Attribute, field *reflector.Reflector, errs *ValidationError) apperror.Error {
+	name := attr.MarshalName()
+
+	// If field is required, and the field is not a primary key, validate that it is
+	// not zero.
+	// Note: numeric fields will not be checked, since their zero value is "0", which might
+	// be a valid field value.
+	if !field.IsNumeric() && attr.IsRequired() && !attr.AutoIncrement() {
+		if field.IsZero() {
+			errs.AddFieldError(NewFieldError(name, "empty_required_field",
+				fmt.Sprintf("The required field %v is empty", name)))
+			// Further checks are pointless for empty fields.
+			return nil
+		}
+	}
+
+	if attr.Min() > 0 || attr.Max() > 0 {
+		// Either min or max is set, so check length.
+		var length float64
+
+		if field.IsIterable() {
+			length = float64(field.Len())
+		} else if field.IsNumeric() {
+			x, _ := field.ConvertTo(float64(0))
+			length = x.(float64)
+		} else {
+			msg := fmt.Sprintf("Field %v.%v has min or max set, but is neither numeric nor a string", info.collection, attr.Name())
+			return apperror.New("invalid_min_or_max_condition", msg)
+		}
+
+		if attr.Min() > 0 && length < attr.Min() {
+			errs.AddFieldError(NewFieldError(name, "shorter_than_min_length",
+				fmt.Sprintf("The field %v is shorter than the minimum length %v", name, attr.Min()),
+				"min", attr.Min()))
+		}
+		if attr.Max() > 0 && length > attr.Max() {
+			errs.AddFieldError(NewFieldError(name, "longer_than_max_length",
+				fmt.Sprintf("The field %v is longer than the maximum length %v", name, attr.Max()),
+				"max", attr.Max()))
+		}
+	}
+
+	if len(attr.Enum()) > 0 && !field.IsZero() {
+		val := fmt.Sprintf("%v", reflect.Indirect(reflect.ValueOf(field.Interface())).Interface())
+		valid := false
+		for _, allowed := range attr.Enum() {
+			if val == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			errs.AddFieldError(NewFieldError(name, "invalid_enum_value",
+				fmt.Sprintf("The field %v must be one of %v", name, attr.Enum()),
+				"values", attr.Enum()))
+		}
+	}
+
+	if !(attr.IsEmail() || attr.IsUrl() || attr.IsUuid() || attr.Pattern() != nil) {
+		return nil
+	}
+
+	str, ok := stringValue(field.Interface())
+	if !ok {
+		msg := fmt.Sprintf("Field %v.%v has a string validator, but is not a string", info.collection, attr.Name())
+		return apperror.New("invalid_string_validator", msg)
+	}
+	// Empty values are handled by required.
+	if str == "" {
+		return nil
+	}
+
+	if attr.IsEmail() && !emailRegexp.MatchString(str) {
+		errs.AddFieldError(NewFieldError(name, "invalid_email",
+			fmt.Sprintf("The field %v must be a valid email address", name)))
+	}
+	if attr.IsUrl() {
+		u, err := url.ParseRequestURI(str)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs.AddFieldError(NewFieldError(name, "invalid_url",
+				fmt.Sprintf("The field %v must be a valid url", name)))
+		}
+	}
+	if attr.IsUuid() && !uuidRegexp.MatchString(str) {
+		errs.AddFieldError(NewFieldError(name, "invalid_uuid",
+			fmt.Sprintf("The field %v must be a valid uuid", name)))
+	}
+	if attr.Pattern() != nil && !attr.Pattern().MatchString(str) {
+		errs.AddFieldError(NewFieldError(name, "pattern_mismatch",
+			fmt.Sprintf("The field %v does not match the pattern %v", name, attr.Pattern()),
+			"pattern", attr.Pattern().String()))
+	}
+
+	return nil
+}
+
+// mergeValidationErrors adds errors returned by a ModelValidateHook to the
+// validation error.
+// Field errors are re-keyed by the marshal name of the field, so errors of
+// adapters that use struct field names end up with the tag validators.
+func mergeValidationErrors(info *ModelInfo, errs *ValidationError, err error) {
+	hookErr, ok := err.(*ValidationError)
+	if !ok {
+		errs.Errors = append(errs.Errors, err)
+		return
+	}
+
+	fields := make([]string, 0, len(hookErr.FieldErrors))
+	for field := range hookErr.FieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		for _, fieldErr := range hookErr.FieldErrors[field] {
+			if attr := info.FindAttribute(fieldErr.Field); attr != nil {
+				fieldErr.Field = attr.MarshalName()
+			}
+			errs.AddFieldError(fieldErr)
+		}
+	}
+	// Keep errors that are not tied to a field.
+	for _, nested := range hookErr.Errors {
+		if _, ok := nested.(*FieldError); !ok {
+			errs.Errors = append(errs.Errors, nested)
+		}
+	}
+}